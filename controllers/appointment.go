@@ -45,10 +45,10 @@ func (ac *AppointmentController) Get(c *gin.Context) {
 		return
 	}
 
-	var appointment models.Appointment
+	appointment := models.Appointment{Patient: &models.Patient{}, Doctor: &models.User{}}
 	err = database.DB.QueryRow(`
 		SELECT a.id, a.patient_id, a.doctor_id, a.appointment_time, a.duration, a.status, a.notes, a.created_at, a.updated_at,
-		       p.name as patient_name, u.name as doctor_name
+		       COALESCE(p.name, '') as patient_name, COALESCE(u.name, '') as doctor_name
 		FROM appointments a
 		LEFT JOIN patients p ON a.patient_id = p.id
 		LEFT JOIN users u ON a.doctor_id = u.id
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"lighthospital/database"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BackupController 数据备份控制器
+type BackupController struct{}
+
+// Download 打包下载数据库和附件目录，压缩包内为 clinic.db 和 attachments/；
+// 数据库通过 VACUUM INTO 生成一致的副本，备份期间无需停止服务。
+// 患者信息密钥不在备份中，须单独备份并与备份文件分开保管
+func (bc *BackupController) Download(c *gin.Context) {
+	tmpDir, err := os.MkdirTemp("", "clinic-backup-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建备份失败"})
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbCopy := filepath.Join(tmpDir, "clinic.db")
+	if _, err := database.DB.Exec("VACUUM INTO ?", dbCopy); err != nil {
+		log.Printf("备份数据库失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "备份数据库失败"})
+		return
+	}
+
+	fileName := "clinic-backup-" + time.Now().Format("20060102-150405") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	c.Status(http.StatusOK)
+
+	// 响应已开始发送，之后出错只能记录日志并中断下载
+	zw := zip.NewWriter(c.Writer)
+	if err := addBackupFile(zw, dbCopy, "clinic.db", zip.Deflate); err != nil {
+		log.Printf("写入备份失败: %v", err)
+		c.Abort()
+		return
+	}
+
+	// 附件多为已压缩的图片和 PDF，直接存储不再压缩
+	dir := attachmentDir()
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), uploadTempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return addBackupFile(zw, path, "attachments/"+filepath.ToSlash(rel), zip.Store)
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("写入备份失败: %v", err)
+		c.Abort()
+	}
+}
+
+// addBackupFile 将文件写入备份压缩包
+func addBackupFile(zw *zip.Writer, path, name string, method uint16) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = method
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,449 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DiagnosisController struct{}
+
+// icdCodePattern ICD-10 编码格式：字母+两位数字，可带小数点后的亚目及扩展码，如 J06.900、A01.000x001
+var icdCodePattern = regexp.MustCompile(`^[A-Z][0-9]{2}(\.[0-9A-Za-z+]{1,8})?$`)
+
+// normalizeDiagnosisCode 校验诊断字典条目，未提供拼音首字母时按名称生成
+func normalizeDiagnosisCode(entry *models.DiagnosisCode) string {
+	entry.Code = strings.TrimSpace(entry.Code)
+	if entry.Code != "" {
+		entry.Code = strings.ToUpper(entry.Code[:1]) + entry.Code[1:]
+	}
+	entry.Name = strings.TrimSpace(entry.Name)
+	if !icdCodePattern.MatchString(entry.Code) {
+		return "无效的ICD-10编码"
+	}
+	if entry.Name == "" {
+		return "诊断名称不能为空"
+	}
+
+	entry.Pinyin = getPinyin(entry.Name)
+	entry.Initials = strings.ToLower(strings.TrimSpace(entry.Initials))
+	if entry.Initials == "" {
+		entry.Initials = getInitials(entry.Name)
+	}
+	return ""
+}
+
+// resolveDiagnoses 按字典校验编码诊断并补全名称，未标记主要诊断时以第一条为主要诊断；
+// 返回的字符串为校验失败原因
+func resolveDiagnoses(q rowQuerier, diagnoses []models.CodedDiagnosis) ([]models.CodedDiagnosis, string, error) {
+	resolved := []models.CodedDiagnosis{}
+	seen := map[string]bool{}
+	primaries := 0
+	for _, diagnosis := range diagnoses {
+		code := strings.ToUpper(strings.TrimSpace(diagnosis.Code))
+		if code == "" {
+			return nil, "诊断编码不能为空", nil
+		}
+		if seen[code] {
+			return nil, fmt.Sprintf("诊断【%s】重复", diagnosis.Code), nil
+		}
+		seen[code] = true
+
+		var entry models.DiagnosisCode
+		err := q.QueryRow("SELECT code, name, enabled FROM diagnosis_codes WHERE code = ?", code).Scan(
+			&entry.Code, &entry.Name, &entry.Enabled)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Sprintf("诊断编码【%s】不在诊断字典中", diagnosis.Code), nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if !entry.Enabled {
+			return nil, fmt.Sprintf("诊断【%s %s】已停用", entry.Code, entry.Name), nil
+		}
+		if diagnosis.IsPrimary {
+			primaries++
+		}
+		resolved = append(resolved, models.CodedDiagnosis{Code: entry.Code, Name: entry.Name, IsPrimary: diagnosis.IsPrimary})
+	}
+
+	if primaries > 1 {
+		return nil, "只能有一个主要诊断", nil
+	}
+	if primaries == 0 && len(resolved) > 0 {
+		resolved[0].IsPrimary = true
+	}
+	// 主要诊断排在首位，次要诊断保持提交顺序
+	for i := range resolved {
+		if resolved[i].IsPrimary && i > 0 {
+			primary := resolved[i]
+			copy(resolved[1:i+1], resolved[:i])
+			resolved[0] = primary
+			break
+		}
+	}
+	return resolved, "", nil
+}
+
+// resolvePrescriptionDiagnoses 按字典校验处方的编码诊断
+func resolvePrescriptionDiagnoses(tx *sql.Tx, prescription *models.Prescription) error {
+	diagnoses, msg, err := resolveDiagnoses(tx, prescription.Diagnoses)
+	if err != nil {
+		return err
+	}
+	if msg != "" {
+		return &prescriptionError{msg: msg}
+	}
+	prescription.Diagnoses = diagnoses
+	return nil
+}
+
+// saveRecordDiagnoses 覆盖保存处方或病历的编码诊断
+func saveRecordDiagnoses(tx *sql.Tx, recordType string, recordID int64, diagnoses []models.CodedDiagnosis) error {
+	if _, err := tx.Exec("DELETE FROM record_diagnoses WHERE record_type = ? AND record_id = ?", recordType, recordID); err != nil {
+		return err
+	}
+	for i, diagnosis := range diagnoses {
+		_, err := tx.Exec(`
+			INSERT INTO record_diagnoses (record_type, record_id, code, name, is_primary, sort_order)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			recordType, recordID, diagnosis.Code, diagnosis.Name, diagnosis.IsPrimary, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyRecordDiagnoses 将一条记录的编码诊断复制到另一条记录，用于修订、复制处方
+func copyRecordDiagnoses(tx *sql.Tx, recordType string, fromID, toID int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO record_diagnoses (record_type, record_id, code, name, is_primary, sort_order)
+		SELECT record_type, ?, code, name, is_primary, sort_order
+		FROM record_diagnoses WHERE record_type = ? AND record_id = ? ORDER BY sort_order`,
+		toID, recordType, fromID)
+	return err
+}
+
+// loadRecordDiagnoses 查询处方或病历的编码诊断，主要诊断在前
+func loadRecordDiagnoses(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, recordType string, recordID int) ([]models.CodedDiagnosis, error) {
+	rows, err := q.Query(`
+		SELECT code, name, is_primary FROM record_diagnoses
+		WHERE record_type = ? AND record_id = ? ORDER BY is_primary DESC, sort_order`, recordType, recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diagnoses := []models.CodedDiagnosis{}
+	for rows.Next() {
+		var diagnosis models.CodedDiagnosis
+		if err := rows.Scan(&diagnosis.Code, &diagnosis.Name, &diagnosis.IsPrimary); err != nil {
+			return nil, err
+		}
+		diagnoses = append(diagnoses, diagnosis)
+	}
+	return diagnoses, rows.Err()
+}
+
+// formatDiagnoses 打印用的诊断文字：编码诊断在前，自由文本作为补充说明
+func formatDiagnoses(diagnoses []models.CodedDiagnosis, text string) string {
+	var parts []string
+	for _, diagnosis := range diagnoses {
+		parts = append(parts, diagnosis.Code+" "+diagnosis.Name)
+	}
+	text = strings.TrimSpace(text)
+	if len(parts) == 0 {
+		return text
+	}
+	result := strings.Join(parts, "；")
+	if text != "" {
+		result += "（" + text + "）"
+	}
+	return result
+}
+
+// List 分页查询诊断字典，支持按编码、名称、拼音、首字母搜索
+func (dc *DiagnosisController) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	whereClause := "WHERE 1=1"
+	var args []interface{}
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		keyword := "%" + strings.ToLower(search) + "%"
+		whereClause += " AND (code LIKE ? OR name LIKE ? OR pinyin LIKE ? OR initials LIKE ?)"
+		args = append(args, search+"%", "%"+search+"%", keyword, keyword)
+	}
+	if enabled := c.Query("enabled"); enabled != "" {
+		whereClause += " AND enabled = ?"
+		args = append(args, enabled == "true" || enabled == "1")
+	}
+
+	var total int
+	database.DB.QueryRow("SELECT COUNT(*) FROM diagnosis_codes "+whereClause, args...).Scan(&total)
+
+	rows, err := database.DB.Query(`
+		SELECT id, code, name, pinyin, initials, enabled, created_at, updated_at
+		FROM diagnosis_codes `+whereClause+` ORDER BY code LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询诊断字典失败"})
+		return
+	}
+	defer rows.Close()
+
+	diagnoses := []models.DiagnosisCode{}
+	for rows.Next() {
+		var entry models.DiagnosisCode
+		err := rows.Scan(&entry.ID, &entry.Code, &entry.Name, &entry.Pinyin, &entry.Initials, &entry.Enabled,
+			&entry.CreatedAt, &entry.UpdatedAt)
+		if err != nil {
+			continue
+		}
+		diagnoses = append(diagnoses, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"diagnoses": diagnoses,
+		"total":     total,
+		"page":      page,
+		"limit":     limit,
+	})
+}
+
+// Lookup 开方、写病历时检索可用诊断，编码前缀和首字母前缀匹配的排在前面
+func (dc *DiagnosisController) Lookup(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusOK, gin.H{"diagnoses": []models.DiagnosisCode{}})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit < 1 || limit > 50 {
+		limit = 10
+	}
+
+	queryLower := strings.ToLower(query)
+	rows, err := database.DB.Query(`
+		SELECT id, code, name, pinyin, initials, enabled, created_at, updated_at
+		FROM diagnosis_codes
+		WHERE enabled = 1 AND (code LIKE ? OR name LIKE ? OR pinyin LIKE ? OR initials LIKE ?)
+		ORDER BY CASE WHEN code LIKE ? THEN 0 WHEN initials LIKE ? OR name LIKE ? THEN 1 ELSE 2 END,
+		         length(name), code
+		LIMIT ?`,
+		query+"%", "%"+query+"%", "%"+queryLower+"%", "%"+queryLower+"%",
+		query+"%", queryLower+"%", query+"%", limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "检索诊断失败"})
+		return
+	}
+	defer rows.Close()
+
+	diagnoses := []models.DiagnosisCode{}
+	for rows.Next() {
+		var entry models.DiagnosisCode
+		err := rows.Scan(&entry.ID, &entry.Code, &entry.Name, &entry.Pinyin, &entry.Initials, &entry.Enabled,
+			&entry.CreatedAt, &entry.UpdatedAt)
+		if err != nil {
+			continue
+		}
+		diagnoses = append(diagnoses, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"diagnoses": diagnoses})
+}
+
+// Create 新增诊断字典条目
+func (dc *DiagnosisController) Create(c *gin.Context) {
+	var entry models.DiagnosisCode
+	if err := c.ShouldBindJSON(&entry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if msg := normalizeDiagnosisCode(&entry); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	var exists int
+	database.DB.QueryRow("SELECT COUNT(*) FROM diagnosis_codes WHERE code = ?", entry.Code).Scan(&exists)
+	if exists > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "诊断编码已存在"})
+		return
+	}
+
+	now := time.Now()
+	result, err := database.DB.Exec(`
+		INSERT INTO diagnosis_codes (code, name, pinyin, initials, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, 1, ?, ?)`,
+		entry.Code, entry.Name, entry.Pinyin, entry.Initials, now, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建诊断失败"})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	c.JSON(http.StatusOK, gin.H{"message": "诊断创建成功", "id": id})
+}
+
+// Update 修改诊断名称、首字母或启用状态；已开具记录中的诊断名称不受影响
+func (dc *DiagnosisController) Update(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的诊断ID"})
+		return
+	}
+
+	var req struct {
+		Code     string `json:"code"`
+		Name     string `json:"name"`
+		Initials string `json:"initials"`
+		Enabled  *bool  `json:"enabled"` // 未提交时保持原启用状态
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	entry := models.DiagnosisCode{Code: req.Code, Name: req.Name, Initials: req.Initials}
+	err = database.DB.QueryRow("SELECT enabled FROM diagnosis_codes WHERE id = ?", id).Scan(&entry.Enabled)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "诊断不存在"})
+		return
+	}
+	if req.Enabled != nil {
+		entry.Enabled = *req.Enabled
+	}
+	if msg := normalizeDiagnosisCode(&entry); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	var exists int
+	database.DB.QueryRow("SELECT COUNT(*) FROM diagnosis_codes WHERE code = ? AND id != ?", entry.Code, id).Scan(&exists)
+	if exists > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "诊断编码已存在"})
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		UPDATE diagnosis_codes SET code = ?, name = ?, pinyin = ?, initials = ?, enabled = ?, updated_at = ?
+		WHERE id = ?`,
+		entry.Code, entry.Name, entry.Pinyin, entry.Initials, entry.Enabled, time.Now(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新诊断失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "诊断更新成功"})
+}
+
+// Import 从CSV导入诊断字典，列依次为：编码,名称[,拼音首字母]；
+// 编码已存在时更新名称并重新启用
+func (dc *DiagnosisController) Import(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传CSV文件"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取文件失败"})
+		return
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入诊断字典失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	imported := 0
+	failures := []gin.H{}
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				failures = append(failures, gin.H{"line": parseErr.Line, "error": "CSV格式错误"})
+				continue
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取文件失败"})
+			return
+		}
+		line, _ := reader.FieldPos(0)
+		if first {
+			// 去掉Excel导出文件的BOM，首行不是合法编码时视为表头
+			record[0] = strings.TrimPrefix(record[0], "\ufeff")
+			if !icdCodePattern.MatchString(strings.ToUpper(strings.TrimSpace(record[0]))) {
+				continue
+			}
+		}
+		if len(record) < 2 {
+			failures = append(failures, gin.H{"line": line, "error": "列数不足"})
+			continue
+		}
+
+		entry := models.DiagnosisCode{Code: record[0], Name: record[1]}
+		if len(record) > 2 {
+			entry.Initials = record[2]
+		}
+		if msg := normalizeDiagnosisCode(&entry); msg != "" {
+			failures = append(failures, gin.H{"line": line, "error": msg})
+			continue
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO diagnosis_codes (code, name, pinyin, initials, enabled, created_at, updated_at)
+			VALUES (?, ?, ?, ?, 1, ?, ?)
+			ON CONFLICT (code) DO UPDATE SET
+				name = excluded.name, pinyin = excluded.pinyin, initials = excluded.initials,
+				enabled = 1, updated_at = excluded.updated_at`,
+			entry.Code, entry.Name, entry.Pinyin, entry.Initials, now, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("导入第 %d 行失败", line)})
+			return
+		}
+		imported++
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入诊断字典失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  fmt.Sprintf("成功导入 %d 条诊断", imported),
+		"imported": imported,
+		"failures": failures,
+	})
+}
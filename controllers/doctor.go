@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"database/sql"
 	"lighthospital/database"
 	"lighthospital/models"
 	"net/http"
@@ -16,7 +17,7 @@ type DoctorController struct{}
 
 // 列表
 func (dc *DoctorController) List(c *gin.Context) {
-	rows, err := database.DB.Query("SELECT id, username, name FROM users WHERE role = 'doctor' ORDER BY id")
+	rows, err := database.DB.Query("SELECT id, username, name, role, antibiotic_level, controlled_drug_qualified FROM users WHERE role != 'admin' ORDER BY id")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询医生列表失败"})
 		return
@@ -25,7 +26,7 @@ func (dc *DoctorController) List(c *gin.Context) {
 	var doctors []models.User
 	for rows.Next() {
 		var u models.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Name); err == nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Name, &u.Role, &u.AntibioticLevel, &u.ControlledDrugQualified); err == nil {
 			doctors = append(doctors, u)
 		}
 	}
@@ -36,7 +37,8 @@ func (dc *DoctorController) List(c *gin.Context) {
 func (dc *DoctorController) Get(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 	var u models.User
-	err := database.DB.QueryRow("SELECT id, username, name FROM users WHERE id = ? AND role = 'doctor'", id).Scan(&u.ID, &u.Username, &u.Name)
+	err := database.DB.QueryRow("SELECT id, username, name, role, antibiotic_level, controlled_drug_qualified FROM users WHERE id = ? AND role != 'admin'", id).Scan(
+		&u.ID, &u.Username, &u.Name, &u.Role, &u.AntibioticLevel, &u.ControlledDrugQualified)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "医生不存在"})
 		return
@@ -50,11 +52,29 @@ func (dc *DoctorController) Create(c *gin.Context) {
 		Username string `json:"username"`
 		Name     string `json:"name"`
 		Password string `json:"password"`
+		Role     string `json:"role"` // doctor, pharmacist, nurse, receptionist，默认医生
+		// 抗菌药物处方权级别及麻醉药品、精神药品处方权
+		AntibioticLevel         string `json:"antibiotic_level"`
+		ControlledDrugQualified bool   `json:"controlled_drug_qualified"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" || req.Name == "" || req.Password == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
 		return
 	}
+	if req.Role == "" {
+		req.Role = models.RoleDoctor
+	}
+	if _, ok := models.StaffRoleNames[req.Role]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色"})
+		return
+	}
+	if req.AntibioticLevel == "" {
+		req.AntibioticLevel = models.AntibioticNonRestricted
+	}
+	if _, ok := models.AntibioticLevelNames[req.AntibioticLevel]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的抗菌药物处方权级别"})
+		return
+	}
 	// 检查用户名唯一
 	var exists int
 	database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", req.Username).Scan(&exists)
@@ -63,7 +83,8 @@ func (dc *DoctorController) Create(c *gin.Context) {
 		return
 	}
 	hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	_, err := database.DB.Exec("INSERT INTO users (username, name, password, role) VALUES (?, ?, ?, 'doctor')", req.Username, req.Name, string(hash))
+	_, err := database.DB.Exec("INSERT INTO users (username, name, password, role, antibiotic_level, controlled_drug_qualified) VALUES (?, ?, ?, ?, ?, ?)",
+		req.Username, req.Name, string(hash), req.Role, req.AntibioticLevel, req.ControlledDrugQualified)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "添加失败"})
 		return
@@ -71,18 +92,52 @@ func (dc *DoctorController) Create(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "添加成功"})
 }
 
-// 修改
+// 修改，未提交的角色和处方权保持不变
 func (dc *DoctorController) Update(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 	var req struct {
-		Username string `json:"username"`
-		Name     string `json:"name"`
-		Password string `json:"password"`
+		Username string  `json:"username"`
+		Name     string  `json:"name"`
+		Password string  `json:"password"`
+		Role     *string `json:"role"`
+		// 抗菌药物处方权级别及麻醉药品、精神药品处方权
+		AntibioticLevel         *string `json:"antibiotic_level"`
+		ControlledDrugQualified *bool   `json:"controlled_drug_qualified"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" || req.Name == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
 		return
 	}
+
+	var role, antibioticLevel string
+	var controlledDrugQualified bool
+	err := database.DB.QueryRow("SELECT role, antibiotic_level, controlled_drug_qualified FROM users WHERE id = ? AND role != 'admin'", id).Scan(
+		&role, &antibioticLevel, &controlledDrugQualified)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+		return
+	}
+	if req.Role != nil && *req.Role != "" {
+		role = *req.Role
+	}
+	if _, ok := models.StaffRoleNames[role]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色"})
+		return
+	}
+	if req.AntibioticLevel != nil && *req.AntibioticLevel != "" {
+		antibioticLevel = *req.AntibioticLevel
+	}
+	if _, ok := models.AntibioticLevelNames[antibioticLevel]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的抗菌药物处方权级别"})
+		return
+	}
+	if req.ControlledDrugQualified != nil {
+		controlledDrugQualified = *req.ControlledDrugQualified
+	}
 	// 检查用户名唯一（排除自己）
 	var exists int
 	database.DB.QueryRow("SELECT COUNT(*) FROM users WHERE username = ? AND id != ?", req.Username, id).Scan(&exists)
@@ -90,12 +145,13 @@ func (dc *DoctorController) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "用户名已存在"})
 		return
 	}
-	var err error
 	if req.Password != "" {
 		hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-		_, err = database.DB.Exec("UPDATE users SET username = ?, name = ?, password = ? WHERE id = ? AND role = 'doctor'", req.Username, req.Name, string(hash), id)
+		_, err = database.DB.Exec("UPDATE users SET username = ?, name = ?, password = ?, role = ?, antibiotic_level = ?, controlled_drug_qualified = ? WHERE id = ? AND role != 'admin'",
+			req.Username, req.Name, string(hash), role, antibioticLevel, controlledDrugQualified, id)
 	} else {
-		_, err = database.DB.Exec("UPDATE users SET username = ?, name = ? WHERE id = ? AND role = 'doctor'", req.Username, req.Name, id)
+		_, err = database.DB.Exec("UPDATE users SET username = ?, name = ?, role = ?, antibiotic_level = ?, controlled_drug_qualified = ? WHERE id = ? AND role != 'admin'",
+			req.Username, req.Name, role, antibioticLevel, controlledDrugQualified, id)
 	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
@@ -107,7 +163,7 @@ func (dc *DoctorController) Update(c *gin.Context) {
 // 删除
 func (dc *DoctorController) Delete(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	_, err := database.DB.Exec("DELETE FROM users WHERE id = ? AND role = 'doctor'", id)
+	_, err := database.DB.Exec("DELETE FROM users WHERE id = ? AND role != 'admin'", id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
 		return
@@ -126,7 +182,7 @@ func (dc *DoctorController) ResetPassword(c *gin.Context) {
 		return
 	}
 	hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	_, err := database.DB.Exec("UPDATE users SET password = ? WHERE id = ? AND role = 'doctor'", string(hash), id)
+	_, err := database.DB.Exec("UPDATE users SET password = ? WHERE id = ? AND role != 'admin'", string(hash), id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置失败"})
 		return
@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DrugClassController struct{}
+
+// normalizeDrugClass 校验药品管理类别和抗菌药物分级，未填写管理类别时按处方药处理
+func normalizeDrugClass(medicine *models.Medicine) string {
+	if medicine.DrugClass == "" {
+		medicine.DrugClass = models.DrugClassRx
+	}
+	if _, ok := models.DrugClassNames[medicine.DrugClass]; !ok {
+		return "无效的药品管理类别"
+	}
+	if medicine.AntibioticLevel != "" {
+		if _, ok := models.AntibioticLevelNames[medicine.AntibioticLevel]; !ok {
+			return "无效的抗菌药物分级"
+		}
+	}
+	return ""
+}
+
+// prescriptionFormOf 药品管理类别对应的处方笺类别
+func prescriptionFormOf(class string) string {
+	switch class {
+	case models.DrugClassNarcotic, models.DrugClassPsychotropic1:
+		return models.PrescriptionFormNarcotic
+	case models.DrugClassPsychotropic2:
+		return models.PrescriptionFormPsychotropic2
+	}
+	return models.PrescriptionFormNormal
+}
+
+// loadDrugClassRules 查询全部管理类别开方规则
+func loadDrugClassRules(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}) (map[string]models.DrugClassRule, error) {
+	rows, err := q.Query("SELECT drug_class, max_days, require_id_card, updated_at FROM drug_class_rules")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := map[string]models.DrugClassRule{}
+	for rows.Next() {
+		var rule models.DrugClassRule
+		if err := rows.Scan(&rule.DrugClass, &rule.MaxDays, &rule.RequireIDCard, &rule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rules[rule.DrugClass] = rule
+	}
+	return rules, rows.Err()
+}
+
+// checkDrugClassRules 按药品管理类别核对开方规则并确定处方笺类别：
+// 麻醉药品、精神药品须由有麻精处方权的医生开具且单独成方，抗菌药物不得超出医生的分级处方权，
+// 用药天数不得超过类别限制，需要时须登记患者身份证号；违规项以 drug_class 提示返回
+func checkDrugClassRules(tx *sql.Tx, prescription *models.Prescription, medicines []*checkMedicine) ([]models.PrescriptionWarning, error) {
+	warnings := []models.PrescriptionWarning{}
+	prescription.PrescriptionForm = models.PrescriptionFormNormal
+	if len(medicines) == 0 {
+		return warnings, nil
+	}
+
+	// 开方医生的处方权，管理员不受限制
+	var role, doctorLevel string
+	var qualified bool
+	err := tx.QueryRow("SELECT role, antibiotic_level, controlled_drug_qualified FROM users WHERE id = ?", prescription.DoctorID).Scan(
+		&role, &doctorLevel, &qualified)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	unrestricted := role == models.RoleAdmin
+
+	rules, err := loadDrugClassRules(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	forms := map[string]bool{}
+	idCardFor := ""
+	for _, medicine := range medicines {
+		className := models.DrugClassNames[medicine.DrugClass]
+		forms[prescriptionFormOf(medicine.DrugClass)] = true
+
+		if models.IsControlledDrugClass(medicine.DrugClass) && !unrestricted && !qualified {
+			warnings = append(warnings, drugClassWarning(medicine,
+				fmt.Sprintf("药品【%s】为%s，开方医生无麻醉药品和精神药品处方权", medicine.Name, className)))
+		}
+
+		if level := medicine.AntibioticLevel; level != "" && !unrestricted &&
+			models.AntibioticLevelRank[level] > models.AntibioticLevelRank[doctorLevel] {
+			doctorLevelName := models.AntibioticLevelNames[doctorLevel]
+			if doctorLevelName == "" {
+				doctorLevelName = "无"
+			}
+			warnings = append(warnings, drugClassWarning(medicine,
+				fmt.Sprintf("药品【%s】为%s抗菌药物，超出开方医生的抗菌药物处方权（%s）",
+					medicine.Name, models.AntibioticLevelNames[level], doctorLevelName)))
+		}
+
+		// 中药饮片按每日一剂以剂数计算用药天数；有天数限制的类别须填写用药天数，不填视为超限
+		rule := rules[medicine.DrugClass]
+		days := medicine.Item.Days
+		if prescription.PrescriptionType == models.PrescriptionHerbal {
+			days = prescription.HerbalDoses
+		}
+		if rule.MaxDays > 0 && days <= 0 {
+			warnings = append(warnings, drugClassWarning(medicine,
+				fmt.Sprintf("药品【%s】为%s，须填写用药天数（每张处方不得超过%d日用量）", medicine.Name, className, rule.MaxDays)))
+		} else if rule.MaxDays > 0 && days > rule.MaxDays {
+			warnings = append(warnings, drugClassWarning(medicine,
+				fmt.Sprintf("药品【%s】为%s，每张处方不得超过%d日用量", medicine.Name, className, rule.MaxDays)))
+		}
+		if rule.RequireIDCard && idCardFor == "" {
+			idCardFor = medicine.Name
+		}
+	}
+
+	if len(forms) > 1 {
+		warnings = append(warnings, models.PrescriptionWarning{
+			Type:     "drug_class",
+			Severity: models.SeveritySevere,
+			Message:  "麻醉药品、精神药品须按类别单独开具处方，不能与其他药品开在同一张处方上",
+		})
+	} else {
+		for form := range forms {
+			prescription.PrescriptionForm = form
+		}
+	}
+
+	if idCardFor != "" {
+		var idCard sql.NullString
+		err := tx.QueryRow("SELECT id_card FROM patients WHERE id = ?", prescription.PatientID).Scan(&idCard)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if idCard.String == "" {
+			warnings = append(warnings, models.PrescriptionWarning{
+				Type:         "drug_class",
+				Severity:     models.SeveritySevere,
+				MedicineName: idCardFor,
+				Message:      fmt.Sprintf("开具【%s】须登记患者身份证号", idCardFor),
+			})
+		}
+	}
+	return warnings, nil
+}
+
+// drugClassWarning 生成违反管理类别开方规则的提示
+func drugClassWarning(medicine *checkMedicine, message string) models.PrescriptionWarning {
+	return models.PrescriptionWarning{
+		Type:         "drug_class",
+		Severity:     models.SeveritySevere,
+		MedicineID:   medicine.ID,
+		MedicineName: medicine.Name,
+		Message:      message,
+	}
+}
+
+// recordControlledDrugs 发药时将处方中的麻醉药品、精神药品写入专用登记
+func recordControlledDrugs(tx *sql.Tx, prescriptionID int, userID int, now time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO controlled_drug_register (prescription_id, prescription_no, medicine_id, medicine_name, specification,
+		drug_class, quantity, patient_id, patient_name, patient_id_card, doctor_id, doctor_name, dispensed_by, dispenser_name,
+		dispensed_at)
+		SELECT p.id, p.prescription_no, i.medicine_id, i.medicine_name, COALESCE(i.specification, ''), m.drug_class, i.quantity,
+		       p.patient_id, COALESCE(pt.name, ''), COALESCE(pt.id_card, ''), p.doctor_id, COALESCE(u.name, ''),
+		       ?, COALESCE((SELECT name FROM users WHERE id = ?), ''), ?
+		FROM prescription_items i
+		JOIN prescriptions p ON p.id = i.prescription_id
+		JOIN medicines m ON m.id = i.medicine_id
+		LEFT JOIN patients pt ON pt.id = p.patient_id
+		LEFT JOIN users u ON u.id = p.doctor_id
+		WHERE i.prescription_id = ? AND m.drug_class IN (?, ?, ?)
+		ORDER BY i.id`,
+		userID, userID, now, prescriptionID,
+		models.DrugClassNarcotic, models.DrugClassPsychotropic1, models.DrugClassPsychotropic2)
+	return err
+}
+
+// ListRules 查询各管理类别的开方规则
+func (dc *DrugClassController) ListRules(c *gin.Context) {
+	rules, err := loadDrugClassRules(database.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询开方规则失败"})
+		return
+	}
+
+	result := []gin.H{}
+	for _, class := range []string{models.DrugClassOTCA, models.DrugClassOTCB, models.DrugClassRx,
+		models.DrugClassPsychotropic2, models.DrugClassPsychotropic1, models.DrugClassNarcotic} {
+		rule, ok := rules[class]
+		if !ok {
+			rule.DrugClass = class
+		}
+		result = append(result, gin.H{
+			"drug_class":      rule.DrugClass,
+			"name":            models.DrugClassNames[class],
+			"max_days":        rule.MaxDays,
+			"require_id_card": rule.RequireIDCard,
+			"updated_at":      rule.UpdatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": result})
+}
+
+// UpdateRule 修改管理类别的开方规则
+func (dc *DrugClassController) UpdateRule(c *gin.Context) {
+	class := c.Param("class")
+	if _, ok := models.DrugClassNames[class]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的药品管理类别"})
+		return
+	}
+
+	var rule models.DrugClassRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if rule.MaxDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "最大用药天数不能为负数"})
+		return
+	}
+
+	_, err := database.DB.Exec(`
+		INSERT INTO drug_class_rules (drug_class, max_days, require_id_card, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (drug_class) DO UPDATE SET
+			max_days = excluded.max_days, require_id_card = excluded.require_id_card, updated_at = excluded.updated_at`,
+		class, rule.MaxDays, rule.RequireIDCard, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新开方规则失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "开方规则更新成功"})
+}
+
+// Register 查询麻醉药品、精神药品专用登记，支持按药品、类别和发药日期筛选
+func (dc *DrugClassController) Register(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	where := "WHERE 1=1"
+	var args []interface{}
+	if medicineID, _ := strconv.Atoi(c.Query("medicine_id")); medicineID > 0 {
+		where += " AND medicine_id = ?"
+		args = append(args, medicineID)
+	}
+	if class := c.Query("drug_class"); class != "" {
+		where += " AND drug_class = ?"
+		args = append(args, class)
+	}
+	if startDate := c.Query("start_date"); startDate != "" {
+		start, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的开始日期"})
+			return
+		}
+		where += " AND dispensed_at >= ?"
+		args = append(args, start)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		end, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的结束日期"})
+			return
+		}
+		where += " AND dispensed_at < ?"
+		args = append(args, end.AddDate(0, 0, 1))
+	}
+
+	var total int
+	database.DB.QueryRow("SELECT COUNT(*) FROM controlled_drug_register "+where, args...).Scan(&total)
+
+	rows, err := database.DB.Query(`
+		SELECT id, prescription_id, prescription_no, medicine_id, medicine_name, specification, drug_class, quantity,
+		       patient_id, patient_name, patient_id_card, doctor_id, doctor_name, dispensed_by, dispenser_name, dispensed_at
+		FROM controlled_drug_register `+where+` ORDER BY dispensed_at DESC, id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询麻精药品登记失败"})
+		return
+	}
+	defer rows.Close()
+
+	records := []models.ControlledDrugRecord{}
+	for rows.Next() {
+		var record models.ControlledDrugRecord
+		err := rows.Scan(&record.ID, &record.PrescriptionID, &record.PrescriptionNo, &record.MedicineID, &record.MedicineName,
+			&record.Specification, &record.DrugClass, &record.Quantity, &record.PatientID, &record.PatientName,
+			&record.PatientIDCard, &record.DoctorID, &record.DoctorName, &record.DispensedBy, &record.DispenserName,
+			&record.DispensedAt)
+		if err != nil {
+			continue
+		}
+		if record.PatientIDCard, err = database.DecryptPII(record.PatientIDCard); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"records": records,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DrugInteractionController struct{}
+
+// interactionTypeAliases 导入文件中允许的对象类型写法
+var interactionTypeAliases = map[string]string{
+	models.InteractionMedicine: models.InteractionMedicine,
+	models.InteractionCategory: models.InteractionCategory,
+	"药品":                       models.InteractionMedicine,
+	"分类":                       models.InteractionCategory,
+}
+
+// severityAliases 导入文件中允许的严重程度写法
+var severityAliases = map[string]string{
+	models.SeverityMild:     models.SeverityMild,
+	models.SeverityModerate: models.SeverityModerate,
+	models.SeveritySevere:   models.SeveritySevere,
+	"轻度":                    models.SeverityMild,
+	"中度":                    models.SeverityModerate,
+	"重度":                    models.SeveritySevere,
+}
+
+// normalizeInteraction 校验相互作用规则，并将双方按固定顺序排列，避免同一规则正反各存一条
+func normalizeInteraction(rule *models.DrugInteraction) string {
+	rule.TypeA = interactionTypeAliases[strings.ToLower(strings.TrimSpace(rule.TypeA))]
+	rule.TypeB = interactionTypeAliases[strings.ToLower(strings.TrimSpace(rule.TypeB))]
+	rule.NameA = strings.TrimSpace(rule.NameA)
+	rule.NameB = strings.TrimSpace(rule.NameB)
+	rule.Message = strings.TrimSpace(rule.Message)
+	if rule.TypeA == "" || rule.TypeB == "" {
+		return "无效的对象类型，应为 medicine 或 category"
+	}
+	if rule.NameA == "" || rule.NameB == "" {
+		return "药品或分类名称不能为空"
+	}
+
+	severity := strings.ToLower(strings.TrimSpace(rule.Severity))
+	if severity == "" {
+		severity = models.SeverityModerate
+	}
+	rule.Severity = severityAliases[severity]
+	if rule.Severity == "" {
+		return "无效的严重程度"
+	}
+
+	if rule.TypeA > rule.TypeB || (rule.TypeA == rule.TypeB && rule.NameA > rule.NameB) {
+		rule.TypeA, rule.TypeB = rule.TypeB, rule.TypeA
+		rule.NameA, rule.NameB = rule.NameB, rule.NameA
+	}
+	return ""
+}
+
+// List 查询相互作用规则，支持按药品或分类名称搜索
+func (dc *DrugInteractionController) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	search := c.Query("search")
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	where := ""
+	var args []interface{}
+	if search != "" {
+		where = "WHERE name_a LIKE ? OR name_b LIKE ?"
+		args = append(args, "%"+search+"%", "%"+search+"%")
+	}
+
+	var total int
+	database.DB.QueryRow("SELECT COUNT(*) FROM drug_interactions "+where, args...).Scan(&total)
+
+	rows, err := database.DB.Query(`
+		SELECT id, type_a, name_a, type_b, name_b, severity, message, created_at, updated_at
+		FROM drug_interactions `+where+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询相互作用规则失败"})
+		return
+	}
+	defer rows.Close()
+
+	rules := []models.DrugInteraction{}
+	for rows.Next() {
+		var rule models.DrugInteraction
+		err := rows.Scan(&rule.ID, &rule.TypeA, &rule.NameA, &rule.TypeB, &rule.NameB, &rule.Severity, &rule.Message,
+			&rule.CreatedAt, &rule.UpdatedAt)
+		if err != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"interactions": rules,
+		"total":        total,
+		"page":         page,
+		"limit":        limit,
+	})
+}
+
+// Create 新增相互作用规则
+func (dc *DrugInteractionController) Create(c *gin.Context) {
+	var rule models.DrugInteraction
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if msg := normalizeInteraction(&rule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	var exists int
+	database.DB.QueryRow(`
+		SELECT COUNT(*) FROM drug_interactions WHERE type_a = ? AND name_a = ? AND type_b = ? AND name_b = ?`,
+		rule.TypeA, rule.NameA, rule.TypeB, rule.NameB).Scan(&exists)
+	if exists > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该相互作用规则已存在"})
+		return
+	}
+
+	now := time.Now()
+	result, err := database.DB.Exec(`
+		INSERT INTO drug_interactions (type_a, name_a, type_b, name_b, severity, message, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.TypeA, rule.NameA, rule.TypeB, rule.NameB, rule.Severity, rule.Message, now, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建相互作用规则失败"})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "相互作用规则创建成功",
+		"id":      id,
+	})
+}
+
+// Update 修改相互作用规则
+func (dc *DrugInteractionController) Update(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的规则ID"})
+		return
+	}
+
+	var rule models.DrugInteraction
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if msg := normalizeInteraction(&rule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	var exists int
+	database.DB.QueryRow(`
+		SELECT COUNT(*) FROM drug_interactions WHERE type_a = ? AND name_a = ? AND type_b = ? AND name_b = ? AND id != ?`,
+		rule.TypeA, rule.NameA, rule.TypeB, rule.NameB, id).Scan(&exists)
+	if exists > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该相互作用规则已存在"})
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE drug_interactions SET type_a = ?, name_a = ?, type_b = ?, name_b = ?, severity = ?, message = ?, updated_at = ?
+		WHERE id = ?`,
+		rule.TypeA, rule.NameA, rule.TypeB, rule.NameB, rule.Severity, rule.Message, time.Now(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新相互作用规则失败"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "相互作用规则不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "相互作用规则更新成功"})
+}
+
+// Delete 删除相互作用规则
+func (dc *DrugInteractionController) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的规则ID"})
+		return
+	}
+
+	result, err := database.DB.Exec("DELETE FROM drug_interactions WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除相互作用规则失败"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "相互作用规则不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "相互作用规则删除成功"})
+}
+
+// Import 从CSV文件导入相互作用规则，列依次为：类型A,名称A,类型B,名称B,严重程度,提示信息；
+// 首行为表头时自动跳过，已存在的规则更新严重程度和提示信息，格式错误的行跳过并返回行号
+func (dc *DrugInteractionController) Import(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传CSV文件"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取文件失败"})
+		return
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入相互作用规则失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	imported := 0
+	failures := []gin.H{}
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				failures = append(failures, gin.H{"line": parseErr.Line, "error": "CSV格式错误"})
+				continue
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取文件失败"})
+			return
+		}
+		line, _ := reader.FieldPos(0)
+		if first {
+			// 去掉Excel导出文件的BOM，首行不是合法类型时视为表头
+			record[0] = strings.TrimPrefix(record[0], "\ufeff")
+			if interactionTypeAliases[strings.ToLower(strings.TrimSpace(record[0]))] == "" {
+				continue
+			}
+		}
+		if len(record) < 4 {
+			failures = append(failures, gin.H{"line": line, "error": "列数不足"})
+			continue
+		}
+
+		rule := models.DrugInteraction{TypeA: record[0], NameA: record[1], TypeB: record[2], NameB: record[3]}
+		if len(record) > 4 {
+			rule.Severity = record[4]
+		}
+		if len(record) > 5 {
+			rule.Message = record[5]
+		}
+		if msg := normalizeInteraction(&rule); msg != "" {
+			failures = append(failures, gin.H{"line": line, "error": msg})
+			continue
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO drug_interactions (type_a, name_a, type_b, name_b, severity, message, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (type_a, name_a, type_b, name_b) DO UPDATE SET
+				severity = excluded.severity, message = excluded.message, updated_at = excluded.updated_at`,
+			rule.TypeA, rule.NameA, rule.TypeB, rule.NameB, rule.Severity, rule.Message, now, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("导入第 %d 行失败", line)})
+			return
+		}
+		imported++
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入相互作用规则失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  fmt.Sprintf("成功导入 %d 条相互作用规则", imported),
+		"imported": imported,
+		"failures": failures,
+	})
+}
@@ -0,0 +1,476 @@
+package controllers
+
+import (
+	"database/sql"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EncounterController struct{}
+
+// encounterRequest 门诊病历请求参数
+type encounterRequest struct {
+	PatientID      int       `json:"patient_id"`
+	AppointmentID  int       `json:"appointment_id"`
+	VisitTime      time.Time `json:"visit_time"`
+	ChiefComplaint string    `json:"chief_complaint"`
+	PresentIllness string    `json:"present_illness"`
+	PastHistory    string    `json:"past_history"`
+	PhysicalExam   string    `json:"physical_exam"`
+	Diagnosis      string    `json:"diagnosis"`
+	Plan           string    `json:"plan"`
+
+	// 编码诊断；修改病历时未提交表示保留原有诊断，提交空列表表示清除
+	Diagnoses []models.CodedDiagnosis `json:"diagnoses"`
+}
+
+// normalize 去除各项记录首尾空白，未填写就诊时间时取当前时间
+func (req *encounterRequest) normalize() {
+	req.ChiefComplaint = strings.TrimSpace(req.ChiefComplaint)
+	req.PresentIllness = strings.TrimSpace(req.PresentIllness)
+	req.PastHistory = strings.TrimSpace(req.PastHistory)
+	req.PhysicalExam = strings.TrimSpace(req.PhysicalExam)
+	req.Diagnosis = strings.TrimSpace(req.Diagnosis)
+	req.Plan = strings.TrimSpace(req.Plan)
+	if req.VisitTime.IsZero() {
+		req.VisitTime = time.Now()
+	}
+}
+
+// attachEncounter 校验处方关联的就诊病历属于同一患者，处方未填写诊断或编码诊断时沿用病历诊断
+func attachEncounter(tx *sql.Tx, prescription *models.Prescription) error {
+	if prescription.EncounterID <= 0 {
+		prescription.EncounterID = 0
+		return nil
+	}
+
+	var patientID int
+	var diagnosis string
+	err := tx.QueryRow("SELECT patient_id, diagnosis FROM encounters WHERE id = ?", prescription.EncounterID).Scan(&patientID, &diagnosis)
+	if err == sql.ErrNoRows {
+		return &prescriptionError{msg: "就诊病历不存在"}
+	}
+	if err != nil {
+		return err
+	}
+	if patientID != prescription.PatientID {
+		return &prescriptionError{msg: "处方患者与就诊病历的患者不一致"}
+	}
+	if strings.TrimSpace(prescription.Diagnosis) == "" {
+		prescription.Diagnosis = diagnosis
+	}
+	if len(prescription.Diagnoses) == 0 {
+		prescription.Diagnoses, err = loadRecordDiagnoses(tx, models.DiagnosisRecordEncounter, prescription.EncounterID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadEncounter 查询门诊病历及编码诊断、患者和医生姓名
+func loadEncounter(id int) (*models.Encounter, error) {
+	var encounter models.Encounter
+	err := database.DB.QueryRow(`
+		SELECT e.id, e.patient_id, e.doctor_id, e.appointment_id, e.visit_time, e.chief_complaint, e.present_illness,
+		       e.past_history, e.physical_exam, e.diagnosis, e.plan, e.status, e.signed_by, e.signed_at,
+		       e.created_at, e.updated_at, COALESCE(pt.name, ''), COALESCE(u.name, '')
+		FROM encounters e
+		LEFT JOIN patients pt ON e.patient_id = pt.id
+		LEFT JOIN users u ON e.doctor_id = u.id
+		WHERE e.id = ?`, id).Scan(
+		&encounter.ID, &encounter.PatientID, &encounter.DoctorID, &encounter.AppointmentID, &encounter.VisitTime,
+		&encounter.ChiefComplaint, &encounter.PresentIllness, &encounter.PastHistory, &encounter.PhysicalExam,
+		&encounter.Diagnosis, &encounter.Plan, &encounter.Status, &encounter.SignedBy, &encounter.SignedAt,
+		&encounter.CreatedAt, &encounter.UpdatedAt, &encounter.PatientName, &encounter.DoctorName)
+	if err != nil {
+		return nil, err
+	}
+
+	encounter.Diagnoses, err = loadRecordDiagnoses(database.DB, models.DiagnosisRecordEncounter, id)
+	if err != nil {
+		return nil, err
+	}
+	return &encounter, nil
+}
+
+// loadEncounterPrescriptions 查询病历下开具的处方
+func loadEncounterPrescriptions(encounterID int) ([]models.Prescription, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, prescription_no, prescription_type, diagnosis, total_amount, status, created_at
+		FROM prescriptions WHERE encounter_id = ? ORDER BY id`, encounterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prescriptions := []models.Prescription{}
+	for rows.Next() {
+		var prescription models.Prescription
+		var diagnosis sql.NullString
+		err := rows.Scan(&prescription.ID, &prescription.PrescriptionNo, &prescription.PrescriptionType, &diagnosis,
+			&prescription.TotalAmount, &prescription.Status, &prescription.CreatedAt)
+		if err != nil {
+			continue
+		}
+		prescription.Diagnosis = diagnosis.String
+		prescription.EncounterID = encounterID
+		prescriptions = append(prescriptions, prescription)
+	}
+	return prescriptions, rows.Err()
+}
+
+// requireEncounterEditable 只有未签名的病历可由接诊医生或管理员修改、签名
+func requireEncounterEditable(c *gin.Context, encounter *models.Encounter) bool {
+	if encounter.Status == models.EncounterSigned {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "病历已签名，不能修改"})
+		return false
+	}
+	if encounter.DoctorID != currentUserID(c) && currentUserRole(c) != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能修改本人接诊的病历"})
+		return false
+	}
+	return true
+}
+
+// Create 新建门诊病历，接诊医生为当前用户；关联预约时将预约标记为已完成
+func (ec *EncounterController) Create(c *gin.Context) {
+	var req encounterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	req.normalize()
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建病历失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var exists int
+	tx.QueryRow("SELECT COUNT(*) FROM patients WHERE id = ?", req.PatientID).Scan(&exists)
+	if exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+
+	if req.AppointmentID > 0 {
+		var patientID int
+		var status string
+		err := tx.QueryRow("SELECT patient_id, status FROM appointments WHERE id = ?", req.AppointmentID).Scan(&patientID, &status)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "预约不存在"})
+			return
+		}
+		if patientID != req.PatientID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "预约患者与病历患者不一致"})
+			return
+		}
+		if status == "scheduled" {
+			_, err = tx.Exec("UPDATE appointments SET status = 'completed', updated_at = ? WHERE id = ?", time.Now(), req.AppointmentID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "更新预约状态失败"})
+				return
+			}
+		}
+	} else {
+		req.AppointmentID = 0
+	}
+
+	diagnoses, msg, err := resolveDiagnoses(tx, req.Diagnoses)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建病历失败"})
+		return
+	}
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO encounters (patient_id, doctor_id, appointment_id, visit_time, chief_complaint, present_illness,
+		past_history, physical_exam, diagnosis, plan, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.PatientID, currentUserID(c), req.AppointmentID, req.VisitTime, req.ChiefComplaint, req.PresentIllness,
+		req.PastHistory, req.PhysicalExam, req.Diagnosis, req.Plan, models.EncounterDraft, now, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建病历失败"})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	if err := saveRecordDiagnoses(tx, models.DiagnosisRecordEncounter, id, diagnoses); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存病历诊断失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建病历失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "病历创建成功", "id": id})
+}
+
+// Get 查询门诊病历及本次就诊开具的处方
+func (ec *EncounterController) Get(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的病历ID"})
+		return
+	}
+
+	encounter, err := loadEncounter(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "病历不存在"})
+		return
+	}
+
+	encounter.Prescriptions, err = loadEncounterPrescriptions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询病历处方失败"})
+		return
+	}
+
+	encounter.VitalSigns, err = queryVitalSigns("WHERE v.encounter_id = ? ORDER BY v.measured_at, v.id", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询生命体征失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"encounter": encounter})
+}
+
+// Update 修改未签名的门诊病历
+func (ec *EncounterController) Update(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的病历ID"})
+		return
+	}
+
+	var req encounterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	encounter, err := loadEncounter(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "病历不存在"})
+		return
+	}
+	if !requireEncounterEditable(c, encounter) {
+		return
+	}
+
+	if req.VisitTime.IsZero() {
+		req.VisitTime = encounter.VisitTime
+	}
+	req.normalize()
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新病历失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	// 更新时再次确认病历未签名，避免并发签名后仍被修改
+	result, err := tx.Exec(`
+		UPDATE encounters SET visit_time = ?, chief_complaint = ?, present_illness = ?, past_history = ?,
+		physical_exam = ?, diagnosis = ?, plan = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		req.VisitTime, req.ChiefComplaint, req.PresentIllness, req.PastHistory,
+		req.PhysicalExam, req.Diagnosis, req.Plan, time.Now(), id, models.EncounterDraft)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新病历失败"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "病历已签名，不能修改"})
+		return
+	}
+
+	if req.Diagnoses != nil {
+		diagnoses, msg, err := resolveDiagnoses(tx, req.Diagnoses)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新病历失败"})
+			return
+		}
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if err := saveRecordDiagnoses(tx, models.DiagnosisRecordEncounter, int64(id), diagnoses); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存病历诊断失败"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新病历失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "病历更新成功"})
+}
+
+// Sign 医生签名确认病历，签名后病历不可再修改
+func (ec *EncounterController) Sign(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的病历ID"})
+		return
+	}
+
+	encounter, err := loadEncounter(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "病历不存在"})
+		return
+	}
+	if !requireEncounterEditable(c, encounter) {
+		return
+	}
+	if encounter.ChiefComplaint == "" || (encounter.Diagnosis == "" && len(encounter.Diagnoses) == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请填写主诉和诊断后再签名"})
+		return
+	}
+
+	now := time.Now()
+	result, err := database.DB.Exec(`
+		UPDATE encounters SET status = ?, signed_by = ?, signed_at = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		models.EncounterSigned, currentUserID(c), now, now, id, models.EncounterDraft)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "病历签名失败"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "病历已签名"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "病历已签名"})
+}
+
+// List 分页查询门诊病历，支持按患者、医生、状态和就诊日期筛选
+func (ec *EncounterController) List(c *gin.Context) {
+	patientID, _ := strconv.Atoi(c.Query("patient_id"))
+	listEncounters(c, patientID)
+}
+
+// ListEncounters 查询患者的历次门诊病历
+func (pc *PatientController) ListEncounters(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+	listEncounters(c, patientID)
+}
+
+func listEncounters(c *gin.Context, patientID int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+	offset := (page - 1) * limit
+
+	whereClause := "WHERE 1=1"
+	var args []interface{}
+	if patientID > 0 {
+		whereClause += " AND e.patient_id = ?"
+		args = append(args, patientID)
+	}
+	if doctorID, _ := strconv.Atoi(c.Query("doctor_id")); doctorID > 0 {
+		whereClause += " AND e.doctor_id = ?"
+		args = append(args, doctorID)
+	}
+	if status := c.Query("status"); status != "" {
+		whereClause += " AND e.status = ?"
+		args = append(args, status)
+	}
+	if startDate := c.Query("start_date"); startDate != "" {
+		start, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的开始日期"})
+			return
+		}
+		whereClause += " AND e.visit_time >= ?"
+		args = append(args, start)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		end, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的结束日期"})
+			return
+		}
+		whereClause += " AND e.visit_time < ?"
+		args = append(args, end.AddDate(0, 0, 1))
+	}
+
+	var total int
+	database.DB.QueryRow("SELECT COUNT(*) FROM encounters e "+whereClause, args...).Scan(&total)
+
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.patient_id, e.doctor_id, e.appointment_id, e.visit_time, e.chief_complaint, e.diagnosis,
+		       e.status, e.signed_at, e.created_at, e.updated_at, COALESCE(pt.name, ''), COALESCE(u.name, ''),
+		       (SELECT COUNT(*) FROM prescriptions p WHERE p.encounter_id = e.id)
+		FROM encounters e
+		LEFT JOIN patients pt ON e.patient_id = pt.id
+		LEFT JOIN users u ON e.doctor_id = u.id
+		`+whereClause+` ORDER BY e.visit_time DESC, e.id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询病历列表失败"})
+		return
+	}
+	defer rows.Close()
+
+	encounters := []gin.H{}
+	for rows.Next() {
+		var encounter models.Encounter
+		var prescriptionCount int
+		err := rows.Scan(&encounter.ID, &encounter.PatientID, &encounter.DoctorID, &encounter.AppointmentID,
+			&encounter.VisitTime, &encounter.ChiefComplaint, &encounter.Diagnosis, &encounter.Status, &encounter.SignedAt,
+			&encounter.CreatedAt, &encounter.UpdatedAt, &encounter.PatientName, &encounter.DoctorName, &prescriptionCount)
+		if err != nil {
+			continue
+		}
+		encounters = append(encounters, gin.H{
+			"id":                 encounter.ID,
+			"patient_id":         encounter.PatientID,
+			"patient_name":       encounter.PatientName,
+			"doctor_id":          encounter.DoctorID,
+			"doctor_name":        encounter.DoctorName,
+			"appointment_id":     encounter.AppointmentID,
+			"visit_time":         encounter.VisitTime,
+			"chief_complaint":    encounter.ChiefComplaint,
+			"diagnosis":          encounter.Diagnosis,
+			"status":             encounter.Status,
+			"signed_at":          encounter.SignedAt,
+			"prescription_count": prescriptionCount,
+			"created_at":         encounter.CreatedAt,
+			"updated_at":         encounter.UpdatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"encounters": encounters,
+		"total":      total,
+		"page":       page,
+		"limit":      limit,
+	})
+}
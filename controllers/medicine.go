@@ -3,6 +3,7 @@ package controllers
 import (
 	"lighthospital/database"
 	"lighthospital/models"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -20,13 +21,33 @@ func (mc *MedicineController) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
 		return
 	}
+	if msg := normalizeDrugClass(&medicine); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if medicine.DosingRule != nil {
+		if msg := validateDosingRule(medicine.DosingRule); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建药品失败"})
+		return
+	}
+	defer tx.Rollback()
 
+	// 期初库存通过库存流水登记
 	now := time.Now()
-	result, err := database.DB.Exec(`
-		INSERT INTO medicines (name, specification, unit, price, stock, min_stock, category, manufacturer, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		medicine.Name, medicine.Specification, medicine.Unit, medicine.Price, medicine.Stock,
-		medicine.MinStock, medicine.Category, medicine.Manufacturer, now, now)
+	result, err := tx.Exec(`
+		INSERT INTO medicines (name, specification, unit, price, stock, min_stock, category, manufacturer, ingredients,
+		drug_class, antibiotic_level, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		medicine.Name, medicine.Specification, medicine.Unit, medicine.Price, 0,
+		medicine.MinStock, medicine.Category, medicine.Manufacturer, medicine.Ingredients,
+		medicine.DrugClass, medicine.AntibioticLevel, now, now)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建药品失败"})
@@ -34,6 +55,32 @@ func (mc *MedicineController) Create(c *gin.Context) {
 	}
 
 	id, _ := result.LastInsertId()
+	if medicine.Stock != 0 {
+		_, err = changeMedicineStock(tx, stockChange{
+			MedicineID:   int(id),
+			Quantity:     medicine.Stock,
+			MovementType: models.MovementPurchase,
+			UserID:       currentUserID(c),
+			Reason:       "期初库存",
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "期初库存不能为负数"})
+			return
+		}
+	}
+
+	if medicine.DosingRule != nil {
+		if err := saveDosingRule(tx, int(id), medicine.DosingRule); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存剂量规则失败"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建药品失败"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "药品创建成功",
 		"id":      id,
@@ -49,16 +96,23 @@ func (mc *MedicineController) Get(c *gin.Context) {
 
 	var medicine models.Medicine
 	err = database.DB.QueryRow(`
-		SELECT id, name, specification, unit, price, stock, min_stock, category, manufacturer, created_at, updated_at
+		SELECT id, name, specification, unit, price, stock, min_stock, category, manufacturer, ingredients, drug_class, antibiotic_level, created_at, updated_at
 		FROM medicines WHERE id = ?`, id).Scan(
 		&medicine.ID, &medicine.Name, &medicine.Specification, &medicine.Unit, &medicine.Price,
-		&medicine.Stock, &medicine.MinStock, &medicine.Category, &medicine.Manufacturer, &medicine.CreatedAt, &medicine.UpdatedAt)
+		&medicine.Stock, &medicine.MinStock, &medicine.Category, &medicine.Manufacturer, &medicine.Ingredients,
+		&medicine.DrugClass, &medicine.AntibioticLevel, &medicine.CreatedAt, &medicine.UpdatedAt)
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "药品不存在"})
 		return
 	}
 
+	medicine.DosingRule, err = loadDosingRule(database.DB, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询剂量规则失败"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"medicine": medicine})
 }
 
@@ -74,18 +128,76 @@ func (mc *MedicineController) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
 		return
 	}
+	if msg := normalizeDrugClass(&medicine); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if medicine.DosingRule != nil {
+		if msg := validateDosingRule(medicine.DosingRule); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新药品失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var stock int
+	err = tx.QueryRow("SELECT stock FROM medicines WHERE id = ?", id).Scan(&stock)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "药品不存在"})
+		return
+	}
 
-	_, err = database.DB.Exec(`
-		UPDATE medicines SET name = ?, specification = ?, unit = ?, price = ?, stock = ?, 
-		min_stock = ?, category = ?, manufacturer = ?, updated_at = ? WHERE id = ?`,
-		medicine.Name, medicine.Specification, medicine.Unit, medicine.Price, medicine.Stock,
-		medicine.MinStock, medicine.Category, medicine.Manufacturer, time.Now(), id)
+	_, err = tx.Exec(`
+		UPDATE medicines SET name = ?, specification = ?, unit = ?, price = ?, 
+		min_stock = ?, category = ?, manufacturer = ?, ingredients = ?, drug_class = ?, antibiotic_level = ?,
+		updated_at = ? WHERE id = ?`,
+		medicine.Name, medicine.Specification, medicine.Unit, medicine.Price,
+		medicine.MinStock, medicine.Category, medicine.Manufacturer, medicine.Ingredients,
+		medicine.DrugClass, medicine.AntibioticLevel, time.Now(), id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新药品失败"})
 		return
 	}
 
+	// 编辑时修改了库存，按手工调整登记流水
+	if medicine.Stock != stock {
+		_, err = changeMedicineStock(tx, stockChange{
+			MedicineID:   id,
+			Quantity:     medicine.Stock - stock,
+			MovementType: models.MovementAdjustment,
+			UserID:       currentUserID(c),
+			Reason:       "编辑药品信息",
+		})
+		if _, ok := err.(*stockShortageError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "库存不能为负数"})
+			return
+		}
+		if err != nil {
+			log.Printf("编辑药品 %d 登记库存调整失败: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "登记库存调整失败"})
+			return
+		}
+	}
+
+	if medicine.DosingRule != nil {
+		if err := saveDosingRule(tx, id, medicine.DosingRule); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存剂量规则失败"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新药品失败"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "药品信息更新成功"})
 }
 
@@ -125,9 +237,13 @@ func (mc *MedicineController) List(c *gin.Context) {
 		whereClause += " AND category = ?"
 		args = append(args, category)
 	}
+	if drugClass := c.Query("drug_class"); drugClass != "" {
+		whereClause += " AND drug_class = ?"
+		args = append(args, drugClass)
+	}
 
 	query = `
-		SELECT id, name, specification, unit, price, stock, min_stock, category, manufacturer, created_at, updated_at
+		SELECT id, name, specification, unit, price, stock, min_stock, category, manufacturer, ingredients, drug_class, antibiotic_level, created_at, updated_at
 		FROM medicines ` + whereClause + ` ORDER BY created_at DESC LIMIT ? OFFSET ?`
 	args = append(args, limit, offset)
 
@@ -143,7 +259,8 @@ func (mc *MedicineController) List(c *gin.Context) {
 		var medicine models.Medicine
 		err := rows.Scan(
 			&medicine.ID, &medicine.Name, &medicine.Specification, &medicine.Unit, &medicine.Price,
-			&medicine.Stock, &medicine.MinStock, &medicine.Category, &medicine.Manufacturer, &medicine.CreatedAt, &medicine.UpdatedAt)
+			&medicine.Stock, &medicine.MinStock, &medicine.Category, &medicine.Manufacturer, &medicine.Ingredients,
+			&medicine.DrugClass, &medicine.AntibioticLevel, &medicine.CreatedAt, &medicine.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -172,7 +289,7 @@ func (mc *MedicineController) Search(c *gin.Context) {
 	}
 
 	query := `
-		SELECT id, name, specification, unit, price, stock, min_stock, category, manufacturer, created_at, updated_at
+		SELECT id, name, specification, unit, price, stock, min_stock, category, manufacturer, ingredients, drug_class, antibiotic_level, created_at, updated_at
 		FROM medicines WHERE 1=1`
 	var args []interface{}
 
@@ -203,7 +320,8 @@ func (mc *MedicineController) Search(c *gin.Context) {
 		var medicine models.Medicine
 		err := rows.Scan(
 			&medicine.ID, &medicine.Name, &medicine.Specification, &medicine.Unit, &medicine.Price,
-			&medicine.Stock, &medicine.MinStock, &medicine.Category, &medicine.Manufacturer, &medicine.CreatedAt, &medicine.UpdatedAt)
+			&medicine.Stock, &medicine.MinStock, &medicine.Category, &medicine.Manufacturer, &medicine.Ingredients,
+			&medicine.DrugClass, &medicine.AntibioticLevel, &medicine.CreatedAt, &medicine.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -221,19 +339,54 @@ func (mc *MedicineController) UpdateStock(c *gin.Context) {
 	}
 
 	var req struct {
-		Stock int `json:"stock" binding:"required"`
+		Stock        *int   `json:"stock" binding:"required"`
+		MovementType string `json:"movement_type"` // adjustment（默认）或 stocktake
+		Reason       string `json:"reason"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil || *req.Stock < 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
 		return
 	}
+	if req.MovementType == "" {
+		req.MovementType = models.MovementAdjustment
+	}
+	if req.MovementType != models.MovementAdjustment && req.MovementType != models.MovementStocktake {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的库存变动类型"})
+		return
+	}
 
-	_, err = database.DB.Exec("UPDATE medicines SET stock = ?, updated_at = ? WHERE id = ?",
-		req.Stock, time.Now(), id)
+	tx, err := database.DB.Begin()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新库存失败"})
 		return
 	}
+	defer tx.Rollback()
+
+	var stock int
+	err = tx.QueryRow("SELECT stock FROM medicines WHERE id = ?", id).Scan(&stock)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "药品不存在"})
+		return
+	}
+
+	if *req.Stock != stock {
+		_, err = changeMedicineStock(tx, stockChange{
+			MedicineID:   id,
+			Quantity:     *req.Stock - stock,
+			MovementType: req.MovementType,
+			UserID:       currentUserID(c),
+			Reason:       req.Reason,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新库存失败"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新库存失败"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "库存更新成功"})
 }
@@ -260,7 +413,7 @@ func (mc *MedicineController) GetCategories(c *gin.Context) {
 
 func (mc *MedicineController) GetLowStock(c *gin.Context) {
 	rows, err := database.DB.Query(`
-		SELECT id, name, specification, unit, price, stock, min_stock, category, manufacturer, created_at, updated_at
+		SELECT id, name, specification, unit, price, stock, min_stock, category, manufacturer, ingredients, drug_class, antibiotic_level, created_at, updated_at
 		FROM medicines WHERE stock <= min_stock ORDER BY stock ASC`)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询低库存药品失败"})
@@ -273,7 +426,8 @@ func (mc *MedicineController) GetLowStock(c *gin.Context) {
 		var medicine models.Medicine
 		err := rows.Scan(
 			&medicine.ID, &medicine.Name, &medicine.Specification, &medicine.Unit, &medicine.Price,
-			&medicine.Stock, &medicine.MinStock, &medicine.Category, &medicine.Manufacturer, &medicine.CreatedAt, &medicine.UpdatedAt)
+			&medicine.Stock, &medicine.MinStock, &medicine.Category, &medicine.Manufacturer, &medicine.Ingredients,
+			&medicine.DrugClass, &medicine.AntibioticLevel, &medicine.CreatedAt, &medicine.UpdatedAt)
 		if err != nil {
 			continue
 		}
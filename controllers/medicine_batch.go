@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"database/sql"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListBatches 查询药品的批次库存
+func (mc *MedicineController) ListBatches(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的药品ID"})
+		return
+	}
+
+	// 默认只显示有库存的批次
+	query := `
+		SELECT id, medicine_id, batch_no, COALESCE(expiry_date, ''), quantity, created_at, updated_at
+		FROM medicine_batches WHERE medicine_id = ?`
+	if c.Query("all") != "true" {
+		query += " AND quantity > 0"
+	}
+	query += " ORDER BY expiry_date IS NULL, expiry_date, id"
+
+	rows, err := database.DB.Query(query, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询药品批次失败"})
+		return
+	}
+	defer rows.Close()
+
+	today := time.Now().Format("2006-01-02")
+	var batches []models.MedicineBatch
+	for rows.Next() {
+		var batch models.MedicineBatch
+		err := rows.Scan(&batch.ID, &batch.MedicineID, &batch.BatchNo, &batch.ExpiryDate, &batch.Quantity,
+			&batch.CreatedAt, &batch.UpdatedAt)
+		if err != nil {
+			continue
+		}
+		batch.Expired = batch.ExpiryDate != "" && batch.ExpiryDate < today
+		batches = append(batches, batch)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"batches": batches})
+}
+
+// ReceiveBatch 按批号、有效期登记采购入库
+func (mc *MedicineController) ReceiveBatch(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的药品ID"})
+		return
+	}
+
+	var req struct {
+		BatchNo    string `json:"batch_no" binding:"required"`
+		ExpiryDate string `json:"expiry_date" binding:"required"`
+		Quantity   int    `json:"quantity" binding:"required"`
+		Reason     string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if req.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入库数量必须大于0"})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", req.ExpiryDate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "有效期格式错误，应为 YYYY-MM-DD"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "批次入库失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM medicines WHERE id = ?", id).Scan(&exists); err != nil || exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "药品不存在"})
+		return
+	}
+
+	// 同一批号同一有效期的入库合并到已有批次
+	var batchID int
+	err = tx.QueryRow("SELECT id FROM medicine_batches WHERE medicine_id = ? AND batch_no = ? AND expiry_date = ?",
+		id, req.BatchNo, req.ExpiryDate).Scan(&batchID)
+	if err == sql.ErrNoRows {
+		now := time.Now()
+		result, err := tx.Exec(`
+			INSERT INTO medicine_batches (medicine_id, batch_no, expiry_date, quantity, created_at, updated_at)
+			VALUES (?, ?, ?, 0, ?, ?)`, id, req.BatchNo, req.ExpiryDate, now, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "批次入库失败"})
+			return
+		}
+		newID, _ := result.LastInsertId()
+		batchID = int(newID)
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "批次入库失败"})
+		return
+	}
+
+	balance, err := applyStockChange(tx, stockChange{
+		MedicineID:   id,
+		BatchID:      batchID,
+		Quantity:     req.Quantity,
+		MovementType: models.MovementPurchase,
+		UserID:       currentUserID(c),
+		Reason:       req.Reason,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "批次入库失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "批次入库失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "批次入库成功",
+		"batch_id": batchID,
+		"stock":    balance,
+	})
+}
+
+// GetExpiringBatches 查询指定天数内到期（含已过期）且仍有库存的批次
+func (mc *MedicineController) GetExpiringBatches(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的天数"})
+		return
+	}
+
+	now := time.Now()
+	deadline := now.AddDate(0, 0, days).Format("2006-01-02")
+	rows, err := database.DB.Query(`
+		SELECT b.id, b.medicine_id, b.batch_no, b.expiry_date, b.quantity, b.created_at, b.updated_at,
+		       m.name, m.specification, m.unit
+		FROM medicine_batches b
+		JOIN medicines m ON b.medicine_id = m.id
+		WHERE b.quantity > 0 AND b.expiry_date IS NOT NULL AND b.expiry_date <= ?
+		ORDER BY b.expiry_date ASC, m.name ASC`, deadline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询近效期药品失败"})
+		return
+	}
+	defer rows.Close()
+
+	today := now.Format("2006-01-02")
+	var batches []models.MedicineBatch
+	for rows.Next() {
+		var batch models.MedicineBatch
+		var medicine models.Medicine
+		err := rows.Scan(&batch.ID, &batch.MedicineID, &batch.BatchNo, &batch.ExpiryDate, &batch.Quantity,
+			&batch.CreatedAt, &batch.UpdatedAt, &medicine.Name, &medicine.Specification, &medicine.Unit)
+		if err != nil {
+			continue
+		}
+		medicine.ID = batch.MedicineID
+		batch.Medicine = &medicine
+		batch.Expired = batch.ExpiryDate < today
+		batches = append(batches, batch)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"batches": batches})
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"fmt"
 	"lighthospital/database"
 	"lighthospital/models"
 	"net/http"
@@ -14,6 +15,9 @@ import (
 
 type PatientController struct{}
 
+// maxPatientAge 允许登记的最大年龄
+const maxPatientAge = 150
+
 func (pc *PatientController) Create(c *gin.Context) {
 	var patient models.Patient
 	if err := c.ShouldBindJSON(&patient); err != nil {
@@ -21,15 +25,34 @@ func (pc *PatientController) Create(c *gin.Context) {
 		return
 	}
 
+	msg, err := normalizePatient(&patient, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建患者失败"})
+		return
+	}
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
 	// 生成拼音
 	pinyin := generatePinyin(patient.Name)
 
+	// 敏感字段加密保存
+	pii, err := database.EncryptPatientPII(patient.Phone, patient.Address, patient.IDCard, patient.MedicalHistory)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建患者失败"})
+		return
+	}
+
 	now := time.Now()
 	result, err := database.DB.Exec(`
-		INSERT INTO patients (name, pinyin, gender, age, phone, address, id_card, medical_history, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		patient.Name, pinyin, patient.Gender, patient.Age, patient.Phone, patient.Address,
-		patient.IDCard, patient.MedicalHistory, now, now)
+		INSERT INTO patients (name, pinyin, gender, age, phone, address, id_card, medical_history, birth_date,
+		birth_date_estimated, phone_bidx, phone_tail_bidx, id_card_bidx, id_card_tail_bidx, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		patient.Name, pinyin, patient.Gender, patient.Age, pii.Phone, pii.Address,
+		pii.IDCard, pii.MedicalHistory, patient.BirthDate, patient.BirthDateEstimated,
+		pii.PhoneIndex, pii.PhoneTailIndex, pii.IDCardIndex, pii.IDCardTailIndex, now, now)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建患者失败"})
@@ -50,18 +73,13 @@ func (pc *PatientController) Get(c *gin.Context) {
 		return
 	}
 
-	var patient models.Patient
-	err = database.DB.QueryRow(`
-		SELECT id, name, pinyin, gender, age, phone, address, id_card, medical_history, created_at, updated_at
-		FROM patients WHERE id = ?`, id).Scan(
-		&patient.ID, &patient.Name, &patient.Pinyin, &patient.Gender, &patient.Age, &patient.Phone,
-		&patient.Address, &patient.IDCard, &patient.MedicalHistory, &patient.CreatedAt, &patient.UpdatedAt)
-
+	patient, err := scanPatient(database.DB.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id = ?", id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
 		return
 	}
 
+	maskPatient(c, &patient)
 	c.JSON(http.StatusOK, gin.H{"patient": patient})
 }
 
@@ -78,14 +96,39 @@ func (pc *PatientController) Update(c *gin.Context) {
 		return
 	}
 
+	existing, err := scanPatient(database.DB.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id = ?", id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+	keepMaskedPII(&patient, existing)
+	msg, err := normalizePatient(&patient, &existing)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新患者失败"})
+		return
+	}
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
 	// 生成拼音
 	pinyin := generatePinyin(patient.Name)
 
+	// 敏感字段加密保存
+	pii, err := database.EncryptPatientPII(patient.Phone, patient.Address, patient.IDCard, patient.MedicalHistory)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新患者失败"})
+		return
+	}
+
 	_, err = database.DB.Exec(`
 		UPDATE patients SET name = ?, pinyin = ?, gender = ?, age = ?, phone = ?, address = ?, 
-		id_card = ?, medical_history = ?, updated_at = ? WHERE id = ?`,
-		patient.Name, pinyin, patient.Gender, patient.Age, patient.Phone, patient.Address,
-		patient.IDCard, patient.MedicalHistory, time.Now(), id)
+		id_card = ?, medical_history = ?, birth_date = ?, birth_date_estimated = ?, phone_bidx = ?, phone_tail_bidx = ?,
+		id_card_bidx = ?, id_card_tail_bidx = ?, updated_at = ? WHERE id = ?`,
+		patient.Name, pinyin, patient.Gender, patient.Age, pii.Phone, pii.Address,
+		pii.IDCard, pii.MedicalHistory, patient.BirthDate, patient.BirthDateEstimated, pii.PhoneIndex, pii.PhoneTailIndex,
+		pii.IDCardIndex, pii.IDCardTailIndex, time.Now(), id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新患者失败"})
@@ -102,15 +145,71 @@ func (pc *PatientController) Delete(c *gin.Context) {
 		return
 	}
 
-	_, err = database.DB.Exec("DELETE FROM patients WHERE id = ?", id)
+	tx, err := database.DB.Begin()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除患者失败"})
 		return
 	}
+	defer tx.Rollback()
+
+	// 已有处方、病历、附件等记录的档案不能删除，以免留下无主的记录和附件文件；重复档案应合并
+	for _, table := range patientReferenceTables {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE patient_id = ?", id).Scan(&count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除患者失败"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "该患者已有处方、预约、病历、生命体征、过敏史或附件等记录，不能删除；重复建档请使用合并"})
+			return
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM patients WHERE id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除患者失败"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除患者失败"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "患者删除成功"})
 }
 
+// minNumberSearchLength 电话、身份证号加密保存，只能按完整号码或后4位查找
+const minNumberSearchLength = 4
+
+// numberSearchNote 按号码搜索时随结果返回的说明：加密保存后不再支持按号码前缀或中间几位模糊搜索
+const numberSearchNote = "电话、身份证号只能按完整号码或后4位匹配，不支持按号码前缀或中间几位搜索"
+
+// isNumberQuery 查询条件是否由数字组成（末位可为身份证号校验码 X）
+func isNumberQuery(value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return false
+	}
+	for i, r := range value {
+		if (r < '0' || r > '9') && !(i == len(value)-1 && (r == 'x' || r == 'X')) {
+			return false
+		}
+	}
+	return true
+}
+
+// numberSearchHint 检查电话、身份证号的查询条件，不足4位无法匹配时返回提示；
+// numericOnly 为 true 时只检查由数字组成的查询（综合搜索中的姓名不受影响）
+func numberSearchHint(value string, numericOnly bool) string {
+	value = strings.TrimSpace(value)
+	if value == "" || (numericOnly && !isNumberQuery(value)) {
+		return ""
+	}
+	if len([]rune(value)) < minNumberSearchLength {
+		return "电话、身份证号请输入完整号码或后4位，不支持按号码前缀或中间几位搜索"
+	}
+	return ""
+}
+
 func (pc *PatientController) List(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
@@ -118,25 +217,35 @@ func (pc *PatientController) List(c *gin.Context) {
 
 	offset := (page - 1) * limit
 
-	var query string
+	whereClause := "WHERE 1=1"
 	var args []interface{}
 
+	if msg := numberSearchHint(search, true); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
 	if search != "" {
-		query = `
-			SELECT id, name, pinyin, gender, age, phone, address, id_card, medical_history, created_at, updated_at
-			FROM patients 
-			WHERE name LIKE ? OR pinyin LIKE ? OR phone LIKE ? OR id_card LIKE ?
-			ORDER BY created_at DESC LIMIT ? OFFSET ?`
-		args = []interface{}{"%" + search + "%", "%" + search + "%", "%" + search + "%", "%" + search + "%", limit, offset}
-	} else {
-		query = `
-			SELECT id, name, pinyin, gender, age, phone, address, id_card, medical_history, created_at, updated_at
-			FROM patients 
-			ORDER BY created_at DESC LIMIT ? OFFSET ?`
-		args = []interface{}{limit, offset}
+		// 电话、身份证号加密保存，按完整号码或后4位的查询索引匹配
+		phoneIndex, phoneTailIndex := database.PhoneIndexes(search)
+		idCardIndex, idCardTailIndex := database.IDCardIndexes(search)
+		whereClause += ` AND (name LIKE ? OR pinyin LIKE ? OR phone_bidx = ? OR phone_tail_bidx = ?
+			OR id_card_bidx = ? OR id_card_tail_bidx = ?)`
+		args = append(args, "%"+search+"%", "%"+search+"%", phoneIndex, phoneTailIndex, idCardIndex, idCardTailIndex)
 	}
 
-	rows, err := database.DB.Query(query, args...)
+	// 按年龄范围筛选（周岁）
+	minAge, minErr := parseAgeParam(c.Query("min_age"))
+	maxAge, maxErr := parseAgeParam(c.Query("max_age"))
+	if minErr != nil || maxErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的年龄范围"})
+		return
+	}
+	ageClause, ageArgs := ageRangeClause(minAge, maxAge, time.Now())
+	whereClause += ageClause
+	args = append(args, ageArgs...)
+
+	rows, err := database.DB.Query("SELECT "+patientColumns+" FROM patients "+whereClause+
+		" ORDER BY created_at DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询患者列表失败"})
 		return
@@ -145,31 +254,29 @@ func (pc *PatientController) List(c *gin.Context) {
 
 	var patients []models.Patient
 	for rows.Next() {
-		var patient models.Patient
-		err := rows.Scan(
-			&patient.ID, &patient.Name, &patient.Pinyin, &patient.Gender, &patient.Age, &patient.Phone,
-			&patient.Address, &patient.IDCard, &patient.MedicalHistory, &patient.CreatedAt, &patient.UpdatedAt)
+		patient, err := scanPatient(rows)
 		if err != nil {
 			continue
 		}
 		patients = append(patients, patient)
 	}
 
+	maskPatients(c, patients)
+
 	// 获取总数
 	var total int
-	if search != "" {
-		database.DB.QueryRow("SELECT COUNT(*) FROM patients WHERE name LIKE ? OR pinyin LIKE ? OR phone LIKE ? OR id_card LIKE ?",
-			"%"+search+"%", "%"+search+"%", "%"+search+"%", "%"+search+"%").Scan(&total)
-	} else {
-		database.DB.QueryRow("SELECT COUNT(*) FROM patients").Scan(&total)
-	}
+	database.DB.QueryRow("SELECT COUNT(*) FROM patients "+whereClause, args...).Scan(&total)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"patients": patients,
 		"total":    total,
 		"page":     page,
 		"limit":    limit,
-	})
+	}
+	if isNumberQuery(search) {
+		response["search_note"] = numberSearchNote
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (pc *PatientController) Search(c *gin.Context) {
@@ -179,9 +286,14 @@ func (pc *PatientController) Search(c *gin.Context) {
 		return
 	}
 
-	query := `
-		SELECT id, name, pinyin, gender, age, phone, address, id_card, medical_history, created_at, updated_at
-		FROM patients WHERE 1=1`
+	for _, value := range []string{search.Phone, search.IDCard} {
+		if msg := numberSearchHint(value, false); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+	}
+
+	query := "SELECT " + patientColumns + " FROM patients WHERE 1=1"
 	var args []interface{}
 
 	if search.Name != "" {
@@ -189,13 +301,22 @@ func (pc *PatientController) Search(c *gin.Context) {
 		args = append(args, "%"+search.Name+"%", "%"+search.Name+"%")
 	}
 	if search.Phone != "" {
-		query += " AND phone LIKE ?"
-		args = append(args, "%"+search.Phone+"%")
+		phoneIndex, phoneTailIndex := database.PhoneIndexes(search.Phone)
+		query += " AND (phone_bidx = ? OR phone_tail_bidx = ?)"
+		args = append(args, phoneIndex, phoneTailIndex)
 	}
 	if search.IDCard != "" {
-		query += " AND id_card LIKE ?"
-		args = append(args, "%"+search.IDCard+"%")
+		idCardIndex, idCardTailIndex := database.IDCardIndexes(search.IDCard)
+		query += " AND (id_card_bidx = ? OR id_card_tail_bidx = ?)"
+		args = append(args, idCardIndex, idCardTailIndex)
+	}
+	if (search.MinAge != nil && *search.MinAge < 0) || (search.MaxAge != nil && *search.MaxAge < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的年龄范围"})
+		return
 	}
+	ageClause, ageArgs := ageRangeClause(search.MinAge, search.MaxAge, time.Now())
+	query += ageClause
+	args = append(args, ageArgs...)
 
 	query += " ORDER BY created_at DESC"
 
@@ -208,26 +329,30 @@ func (pc *PatientController) Search(c *gin.Context) {
 
 	var patients []models.Patient
 	for rows.Next() {
-		var patient models.Patient
-		err := rows.Scan(
-			&patient.ID, &patient.Name, &patient.Pinyin, &patient.Gender, &patient.Age, &patient.Phone,
-			&patient.Address, &patient.IDCard, &patient.MedicalHistory, &patient.CreatedAt, &patient.UpdatedAt)
+		patient, err := scanPatient(rows)
 		if err != nil {
 			continue
 		}
 		patients = append(patients, patient)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"patients": patients})
+	maskPatients(c, patients)
+	response := gin.H{"patients": patients}
+	if search.Phone != "" || search.IDCard != "" {
+		response["search_note"] = numberSearchNote
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // FindOrCreateByName 根据姓名查找患者，如果不存在则创建
 func (pc *PatientController) FindOrCreateByName(c *gin.Context) {
 	var request struct {
-		Name   string `json:"name" binding:"required"`
-		Gender string `json:"gender"`
-		Age    int    `json:"age"`
-		Phone  string `json:"phone"`
+		Name      string `json:"name" binding:"required"`
+		Gender    string `json:"gender"`
+		Age       int    `json:"age"`
+		BirthDate string `json:"birth_date"`
+		Phone     string `json:"phone"`
+		IDCard    string `json:"id_card"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -235,18 +360,66 @@ func (pc *PatientController) FindOrCreateByName(c *gin.Context) {
 		return
 	}
 
-	// 先查找是否存在同名患者
-	var patient models.Patient
-	err := database.DB.QueryRow(`
-		SELECT id, name, pinyin, gender, age, phone, address, id_card, medical_history, created_at, updated_at
-		FROM patients WHERE name = ?`, request.Name).Scan(
-		&patient.ID, &patient.Name, &patient.Pinyin, &patient.Gender, &patient.Age, &patient.Phone,
-		&patient.Address, &patient.IDCard, &patient.MedicalHistory, &patient.CreatedAt, &patient.UpdatedAt)
+	requested := models.Patient{Name: request.Name, Gender: request.Gender, Age: request.Age, BirthDate: request.BirthDate,
+		Phone: request.Phone, IDCard: request.IDCard}
+	if msg := normalizePatientBirth(&requested, nil); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if msg := applyPatientIDCard(&requested); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	// 身份证号已登记时直接返回该档案，姓名不一致的视为录入错误
+	owner, err := idCardOwner(database.DB, requested.IDCard, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查找患者失败"})
+		return
+	}
+	if owner != nil {
+		if owner.Name != strings.TrimSpace(request.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("身份证号已登记在患者【%s】的档案中", owner.Name)})
+			return
+		}
+		maskPatient(c, owner)
+		c.JSON(http.StatusOK, gin.H{
+			"patient": owner,
+			"created": false,
+			"message": "找到现有患者",
+		})
+		return
+	}
 
-	if err == nil {
+	// 同名患者中性别、年龄、电话均不冲突且评分最高的视为同一人，同名不同人时新建档案
+	rows, err := database.DB.Query("SELECT "+patientColumns+" FROM patients WHERE name = ? ORDER BY id", request.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查找患者失败"})
+		return
+	}
+	var matched *models.Patient
+	bestScore := 0
+	for rows.Next() {
+		patient, err := scanPatient(rows)
+		if err != nil {
+			continue
+		}
+		phone := strings.TrimSpace(request.Phone)
+		if phone != "" && patient.Phone != "" && phone != patient.Phone {
+			continue
+		}
+		if score, _ := scoreDuplicate(requested, patient); score >= duplicateScoreName && score > bestScore {
+			matched = &patient
+			bestScore = score
+		}
+	}
+	rows.Close()
+
+	if matched != nil {
 		// 患者已存在，返回患者信息
+		maskPatient(c, matched)
 		c.JSON(http.StatusOK, gin.H{
-			"patient": patient,
+			"patient": matched,
 			"created": false,
 			"message": "找到现有患者",
 		})
@@ -255,11 +428,18 @@ func (pc *PatientController) FindOrCreateByName(c *gin.Context) {
 
 	// 患者不存在，创建新患者
 	pinyin := generatePinyin(request.Name)
+	pii, err := database.EncryptPatientPII(requested.Phone, "", requested.IDCard, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建患者失败"})
+		return
+	}
 	now := time.Now()
 	result, err := database.DB.Exec(`
-		INSERT INTO patients (name, pinyin, gender, age, phone, address, id_card, medical_history, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		request.Name, pinyin, request.Gender, request.Age, request.Phone, "", "", "", now, now)
+		INSERT INTO patients (name, pinyin, gender, age, phone, address, id_card, medical_history, birth_date,
+		birth_date_estimated, phone_bidx, phone_tail_bidx, id_card_bidx, id_card_tail_bidx, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		request.Name, pinyin, requested.Gender, requested.Age, pii.Phone, "", pii.IDCard, "", requested.BirthDate,
+		requested.BirthDateEstimated, pii.PhoneIndex, pii.PhoneTailIndex, pii.IDCardIndex, pii.IDCardTailIndex, now, now)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建患者失败"})
@@ -269,17 +449,13 @@ func (pc *PatientController) FindOrCreateByName(c *gin.Context) {
 	id, _ := result.LastInsertId()
 
 	// 返回新创建的患者信息
-	newPatient := models.Patient{
-		ID:        int(id),
-		Name:      request.Name,
-		Pinyin:    pinyin,
-		Gender:    request.Gender,
-		Age:       request.Age,
-		Phone:     request.Phone,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
+	newPatient := requested
+	newPatient.ID = int(id)
+	newPatient.Pinyin = pinyin
+	newPatient.CreatedAt = now
+	newPatient.UpdatedAt = now
 
+	maskPatient(c, &newPatient)
 	c.JSON(http.StatusOK, gin.H{
 		"patient": newPatient,
 		"created": true,
@@ -287,6 +463,99 @@ func (pc *PatientController) FindOrCreateByName(c *gin.Context) {
 	})
 }
 
+// normalizePatient 校验出生日期和身份证号，按身份证号补全性别和出生日期，并检查身份证号是否已登记在其他档案中
+func normalizePatient(patient *models.Patient, existing *models.Patient) (string, error) {
+	if msg := normalizePatientBirth(patient, existing); msg != "" {
+		return msg, nil
+	}
+	if msg := applyPatientIDCard(patient); msg != "" {
+		return msg, nil
+	}
+
+	excludeID := 0
+	if existing != nil {
+		excludeID = existing.ID
+	}
+	owner, err := idCardOwner(database.DB, patient.IDCard, excludeID)
+	if err != nil {
+		return "", err
+	}
+	if owner != nil {
+		return fmt.Sprintf("身份证号已登记在患者【%s】（ID %d）的档案中", owner.Name, owner.ID), nil
+	}
+	return "", nil
+}
+
+// normalizePatientBirth 校验出生日期并计算年龄；未填写出生日期时按录入的年龄推算并标记为估算。
+// 修改档案时 existing 为原档案，出生日期未填写且年龄未变的保留原出生日期
+func normalizePatientBirth(patient *models.Patient, existing *models.Patient) string {
+	now := time.Now()
+	patient.BirthDate = strings.TrimSpace(patient.BirthDate)
+	if patient.Age < 0 || patient.Age > maxPatientAge {
+		return "无效的年龄"
+	}
+
+	switch {
+	case patient.BirthDate != "":
+		birth, err := time.ParseInLocation(models.BirthDateLayout, patient.BirthDate, time.Local)
+		if err != nil {
+			return "出生日期格式应为 YYYY-MM-DD"
+		}
+		if birth.After(now) {
+			return "出生日期不能晚于今天"
+		}
+		if birth.Before(now.AddDate(-maxPatientAge, 0, 0)) {
+			return "无效的出生日期"
+		}
+		patient.BirthDate = birth.Format(models.BirthDateLayout)
+		// 原样提交的估算出生日期仍为估算
+		patient.BirthDateEstimated = existing != nil && existing.BirthDateEstimated && existing.BirthDate == patient.BirthDate
+	case existing != nil && existing.BirthDate != "" && existing.Age == patient.Age:
+		patient.BirthDate = existing.BirthDate
+		patient.BirthDateEstimated = existing.BirthDateEstimated
+	case patient.Age > 0:
+		patient.BirthDate = now.AddDate(-patient.Age, 0, 0).Format(models.BirthDateLayout)
+		patient.BirthDateEstimated = true
+	default:
+		patient.BirthDateEstimated = false
+	}
+
+	patient.FillAge(now)
+	return ""
+}
+
+// parseAgeParam 解析年龄查询参数，未填写时返回 nil
+func parseAgeParam(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	age, err := strconv.Atoi(value)
+	if err != nil || age < 0 {
+		return nil, fmt.Errorf("无效的年龄：%s", value)
+	}
+	return &age, nil
+}
+
+// ageRangeClause 将周岁范围换算为出生日期范围的查询条件，未登记出生日期的患者不在结果中
+func ageRangeClause(minAge, maxAge *int, now time.Time) (string, []interface{}) {
+	if minAge == nil && maxAge == nil {
+		return "", nil
+	}
+	clause := " AND birth_date != ''"
+	var args []interface{}
+	if minAge != nil {
+		// 年满 minAge 周岁：出生日期不晚于 minAge 年前的今天
+		clause += " AND birth_date <= ?"
+		args = append(args, now.AddDate(-*minAge, 0, 0).Format(models.BirthDateLayout))
+	}
+	if maxAge != nil {
+		// 未满 maxAge+1 周岁：出生日期晚于 maxAge+1 年前的今天
+		clause += " AND birth_date > ?"
+		args = append(args, now.AddDate(-*maxAge-1, 0, 0).Format(models.BirthDateLayout))
+	}
+	return clause, args
+}
+
 func generatePinyin(name string) string {
 	p := pinyin.NewArgs()
 	p.Style = pinyin.Normal
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"database/sql"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// allergyRequest 过敏记录请求参数
+type allergyRequest struct {
+	AllergenType string `json:"allergen_type"`
+	MedicineID   int    `json:"medicine_id"`
+	Allergen     string `json:"allergen"`
+	Reaction     string `json:"reaction"`
+	Severity     string `json:"severity"`
+	Notes        string `json:"notes"`
+}
+
+// normalize 校验过敏记录参数，过敏原为药品时以药品库名称为准
+func (req *allergyRequest) normalize(db *sql.DB) string {
+	req.Allergen = strings.TrimSpace(req.Allergen)
+	if req.Severity == "" {
+		req.Severity = models.SeverityModerate
+	}
+	switch req.Severity {
+	case models.SeverityMild, models.SeverityModerate, models.SeveritySevere:
+	default:
+		return "无效的严重程度"
+	}
+
+	switch req.AllergenType {
+	case models.AllergenMedicine:
+		if req.MedicineID <= 0 {
+			return "请选择过敏药品"
+		}
+		err := db.QueryRow("SELECT name FROM medicines WHERE id = ?", req.MedicineID).Scan(&req.Allergen)
+		if err != nil {
+			return "药品不存在"
+		}
+	case models.AllergenCategory, models.AllergenIngredient:
+		req.MedicineID = 0
+		if req.Allergen == "" {
+			return "请填写过敏原"
+		}
+	default:
+		return "无效的过敏原类型"
+	}
+	return ""
+}
+
+// ListAllergies 获取患者的有效过敏记录
+func (pc *PatientController) ListAllergies(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, patient_id, allergen_type, medicine_id, allergen, COALESCE(reaction, ''), severity,
+		       COALESCE(notes, ''), created_by, created_at, updated_at
+		FROM patient_allergies WHERE patient_id = ? AND deleted_at IS NULL
+		ORDER BY id`, patientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询过敏记录失败"})
+		return
+	}
+	defer rows.Close()
+
+	allergies := []models.PatientAllergy{}
+	for rows.Next() {
+		var allergy models.PatientAllergy
+		err := rows.Scan(&allergy.ID, &allergy.PatientID, &allergy.AllergenType, &allergy.MedicineID, &allergy.Allergen,
+			&allergy.Reaction, &allergy.Severity, &allergy.Notes, &allergy.CreatedBy, &allergy.CreatedAt, &allergy.UpdatedAt)
+		if err != nil {
+			continue
+		}
+		allergies = append(allergies, allergy)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"allergies": allergies})
+}
+
+// CreateAllergy 为患者添加过敏记录
+func (pc *PatientController) CreateAllergy(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	var req allergyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var exists int
+	database.DB.QueryRow("SELECT COUNT(*) FROM patients WHERE id = ?", patientID).Scan(&exists)
+	if exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+
+	if msg := req.normalize(database.DB); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	now := time.Now()
+	result, err := database.DB.Exec(`
+		INSERT INTO patient_allergies (patient_id, allergen_type, medicine_id, allergen, reaction, severity, notes,
+		created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		patientID, req.AllergenType, req.MedicineID, req.Allergen, req.Reaction, req.Severity, req.Notes,
+		currentUserID(c), now, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "添加过敏记录失败"})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "过敏记录添加成功",
+		"id":      id,
+	})
+}
+
+// UpdateAllergy 修改患者的过敏记录
+func (pc *PatientController) UpdateAllergy(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+	allergyID, err := strconv.Atoi(c.Param("allergyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的过敏记录ID"})
+		return
+	}
+
+	var req allergyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if msg := req.normalize(database.DB); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE patient_allergies SET allergen_type = ?, medicine_id = ?, allergen = ?, reaction = ?, severity = ?,
+		notes = ?, updated_at = ?
+		WHERE id = ? AND patient_id = ? AND deleted_at IS NULL`,
+		req.AllergenType, req.MedicineID, req.Allergen, req.Reaction, req.Severity, req.Notes, time.Now(),
+		allergyID, patientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新过敏记录失败"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "过敏记录不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "过敏记录更新成功"})
+}
+
+// DeleteAllergy 删除患者的过敏记录，仅标记删除以保留历史
+func (pc *PatientController) DeleteAllergy(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+	allergyID, err := strconv.Atoi(c.Param("allergyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的过敏记录ID"})
+		return
+	}
+
+	now := time.Now()
+	result, err := database.DB.Exec(`
+		UPDATE patient_allergies SET deleted_at = ?, updated_at = ?
+		WHERE id = ? AND patient_id = ? AND deleted_at IS NULL`,
+		now, now, allergyID, patientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除过敏记录失败"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "过敏记录不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "过敏记录删除成功"})
+}
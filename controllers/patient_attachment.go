@@ -0,0 +1,539 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"lighthospital/database"
+	"lighthospital/middleware"
+	"lighthospital/models"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	_ "image/gif" // 注册 GIF、PNG 解码器，用于生成缩略图
+	_ "image/png"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 附件按内容的 SHA-256 保存在附件目录的 <前两位>/<完整哈希> 下，图片另存 <完整哈希>.thumb.jpg 缩略图；
+// 附件目录默认为程序目录下的 attachments，可通过环境变量 CLINIC_ATTACHMENT_DIR 指定
+const (
+	attachmentDirEnv     = "CLINIC_ATTACHMENT_DIR"
+	defaultAttachmentDir = "./attachments"
+
+	// maxAttachmentSize 单个附件的大小上限
+	maxAttachmentSize = 20 << 20
+	// thumbnailSize 缩略图长边的像素数
+	thumbnailSize = 240
+	// maxThumbnailPixels 超过该像素数的图片不生成缩略图，避免解码占用过多内存
+	maxThumbnailPixels = 50000000
+
+	thumbnailSuffix  = ".thumb.jpg"
+	uploadTempPrefix = ".upload-"
+)
+
+// attachmentMimeTypes 允许上传的文件类型（按文件内容识别）及对应的扩展名
+var attachmentMimeTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+var (
+	errEmptyAttachment = errors.New("empty attachment")
+	errAttachmentType  = errors.New("unsupported attachment type")
+)
+
+// attachmentFilesMu 内容相同的附件共用一个文件，保存文件到写入记录、删除记录到删除文件须串行执行，
+// 否则删除时统计的引用数可能漏掉正在上传的同一文件
+var attachmentFilesMu sync.Mutex
+
+// attachmentDir 附件保存目录
+func attachmentDir() string {
+	if dir := strings.TrimSpace(os.Getenv(attachmentDirEnv)); dir != "" {
+		return dir
+	}
+	return defaultAttachmentDir
+}
+
+// attachmentPath 按内容哈希返回附件文件路径
+func attachmentPath(sha string) string {
+	return filepath.Join(attachmentDir(), sha[:2], sha)
+}
+
+// storeAttachment 保存上传的文件并返回内容哈希、大小和识别出的类型；
+// 先写入临时文件同时计算哈希，内容相同的文件已存在时直接复用
+func storeAttachment(src io.Reader) (string, int64, string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", 0, "", err
+	}
+	head = head[:n]
+	if n == 0 {
+		return "", 0, "", errEmptyAttachment
+	}
+	mimeType := strings.SplitN(http.DetectContentType(head), ";", 2)[0]
+	if _, ok := attachmentMimeTypes[mimeType]; !ok {
+		return "", 0, mimeType, errAttachmentType
+	}
+
+	dir := attachmentDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", 0, "", err
+	}
+	tmp, err := os.CreateTemp(dir, uploadTempPrefix+"*")
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.MultiReader(bytes.NewReader(head), src))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	sha := hex.EncodeToString(hash.Sum(nil))
+	path := attachmentPath(sha)
+	if _, err := os.Stat(path); err == nil {
+		return sha, size, mimeType, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", 0, "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, "", err
+	}
+	return sha, size, mimeType, nil
+}
+
+// makeThumbnail 为图片附件生成缩略图，无法解码或尺寸过大的图片返回 false
+func makeThumbnail(sha string) bool {
+	thumbPath := attachmentPath(sha) + thumbnailSuffix
+	if _, err := os.Stat(thumbPath); err == nil {
+		return true
+	}
+
+	f, err := os.Open(attachmentPath(sha))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+	if err != nil || config.Width == 0 || config.Height == 0 || config.Width*config.Height > maxThumbnailPixels {
+		return false
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return false
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return false
+	}
+	if err := os.WriteFile(thumbPath, buf.Bytes(), 0600); err != nil {
+		log.Printf("保存缩略图失败: %v", err)
+		return false
+	}
+	return true
+}
+
+// scaleDown 按区域平均将图片缩小到长边不超过 maxSide，透明部分以白色填充
+func scaleDown(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dstW, dstH := srcW, srcH
+	if srcW >= srcH && srcW > maxSide {
+		dstW, dstH = maxSide, srcH*maxSide/srcW
+	} else if srcH > srcW && srcH > maxSide {
+		dstW, dstH = srcW*maxSide/srcH, maxSide
+	}
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := bounds.Min.Y + (y+1)*srcH/dstH
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := bounds.Min.X + (x+1)*srcW/dstW
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					// 颜色值已按透明度预乘，补上白色背景
+					r += uint64(pr + 0xffff - pa)
+					g += uint64(pg + 0xffff - pa)
+					b += uint64(pb + 0xffff - pa)
+					count++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / count >> 8),
+				G: uint8(g / count >> 8),
+				B: uint8(b / count >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+// removeAttachmentFile 删除不再被任何附件记录引用的文件及缩略图
+func removeAttachmentFile(sha string) {
+	var refs int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM patient_attachments WHERE sha256 = ?", sha).Scan(&refs); err != nil || refs > 0 {
+		return
+	}
+	path := attachmentPath(sha)
+	for _, p := range []string{path, path + thumbnailSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("删除附件文件失败: %v", err)
+		}
+	}
+}
+
+// attachmentFileName 清理上传时的文件名，去掉路径并补全与文件类型一致的扩展名
+func attachmentFileName(name, mimeType string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == "/" {
+		name = ""
+	}
+	for utf8.RuneCountInString(name) > 100 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	ext := attachmentMimeTypes[mimeType]
+	if name == "" {
+		return "附件" + ext
+	}
+	if !strings.EqualFold(filepath.Ext(name), ext) && !(ext == ".jpg" && strings.EqualFold(filepath.Ext(name), ".jpeg")) {
+		name += ext
+	}
+	return name
+}
+
+// queryAttachments 查询附件记录及上传人姓名
+func queryAttachments(query string, args ...interface{}) ([]models.PatientAttachment, error) {
+	rows, err := database.DB.Query(`
+		SELECT a.id, a.patient_id, a.prescription_id, a.encounter_id, a.category, a.file_name, a.mime_type, a.size,
+		       a.sha256, a.has_thumbnail, a.description, a.uploaded_by, a.created_at, COALESCE(u.name, '')
+		FROM patient_attachments a
+		LEFT JOIN users u ON a.uploaded_by = u.id
+		`+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []models.PatientAttachment{}
+	for rows.Next() {
+		var attachment models.PatientAttachment
+		err := rows.Scan(&attachment.ID, &attachment.PatientID, &attachment.PrescriptionID, &attachment.EncounterID,
+			&attachment.Category, &attachment.FileName, &attachment.MimeType, &attachment.Size, &attachment.SHA256,
+			&attachment.HasThumbnail, &attachment.Description, &attachment.UploadedBy, &attachment.CreatedAt,
+			&attachment.UploaderName)
+		if err != nil {
+			continue
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// patientAttachment 按患者ID和附件ID查询一条附件记录
+func patientAttachment(c *gin.Context) (*models.PatientAttachment, bool) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return nil, false
+	}
+	attachmentID, err := strconv.Atoi(c.Param("attachmentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的附件ID"})
+		return nil, false
+	}
+
+	attachments, err := queryAttachments("WHERE a.id = ? AND a.patient_id = ?", attachmentID, patientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询附件失败"})
+		return nil, false
+	}
+	if len(attachments) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "附件不存在"})
+		return nil, false
+	}
+	return &attachments[0], true
+}
+
+// UploadAttachment 上传患者的检验报告、影像报告等资料（multipart 表单，文件字段为 file），
+// 可关联到该患者的处方或门诊病历；仅接受图片和 PDF，按文件内容识别类型
+func (pc *PatientController) UploadAttachment(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	var exists int
+	database.DB.QueryRow("SELECT COUNT(*) FROM patients WHERE id = ?", patientID).Scan(&exists)
+	if exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+
+	// 表单其他字段很短，额外留出 1MB
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAttachmentSize+1<<20)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("文件不能超过 %dMB", maxAttachmentSize>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要上传的文件"})
+		return
+	}
+	if fileHeader.Size > maxAttachmentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("文件不能超过 %dMB", maxAttachmentSize>>20)})
+		return
+	}
+
+	category := strings.TrimSpace(c.DefaultPostForm("category", models.AttachmentOther))
+	if _, ok := models.AttachmentCategoryNames[category]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的附件类别"})
+		return
+	}
+	description := strings.TrimSpace(c.PostForm("description"))
+
+	prescriptionID, _ := strconv.Atoi(c.PostForm("prescription_id"))
+	if prescriptionID > 0 {
+		var prescriptionPatientID int
+		err := database.DB.QueryRow("SELECT patient_id FROM prescriptions WHERE id = ?", prescriptionID).Scan(&prescriptionPatientID)
+		if err == sql.ErrNoRows || (err == nil && prescriptionPatientID != patientID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "处方不存在或不属于该患者"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "上传附件失败"})
+			return
+		}
+	} else {
+		prescriptionID = 0
+	}
+	encounterID, _ := strconv.Atoi(c.PostForm("encounter_id"))
+	if encounterID > 0 {
+		var encounterPatientID int
+		err := database.DB.QueryRow("SELECT patient_id FROM encounters WHERE id = ?", encounterID).Scan(&encounterPatientID)
+		if err == sql.ErrNoRows || (err == nil && encounterPatientID != patientID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "就诊病历不存在或不属于该患者"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "上传附件失败"})
+			return
+		}
+	} else {
+		encounterID = 0
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "上传附件失败"})
+		return
+	}
+	defer file.Close()
+
+	attachmentFilesMu.Lock()
+	defer attachmentFilesMu.Unlock()
+
+	sha, size, mimeType, err := storeAttachment(file)
+	switch {
+	case err == errEmptyAttachment:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件内容为空"})
+		return
+	case err == errAttachmentType:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "仅支持上传 JPG、PNG、GIF、WebP 图片和 PDF 文件"})
+		return
+	case err != nil:
+		log.Printf("保存附件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存附件失败"})
+		return
+	}
+
+	hasThumbnail := strings.HasPrefix(mimeType, "image/") && makeThumbnail(sha)
+	fileName := attachmentFileName(fileHeader.Filename, mimeType)
+
+	result, err := database.DB.Exec(`
+		INSERT INTO patient_attachments (patient_id, prescription_id, encounter_id, category, file_name, mime_type, size,
+		sha256, has_thumbnail, description, uploaded_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		patientID, prescriptionID, encounterID, category, fileName, mimeType, size,
+		sha, hasThumbnail, description, currentUserID(c), time.Now())
+	if err != nil {
+		removeAttachmentFile(sha)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "上传附件失败"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	middleware.SetOperationDescription(c, fmt.Sprintf("为患者（ID %d）上传%s【%s】", patientID,
+		models.AttachmentCategoryNames[category], fileName))
+
+	attachments, err := queryAttachments("WHERE a.id = ?", id)
+	if err != nil || len(attachments) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "附件上传成功", "id": id})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "附件上传成功", "id": id, "attachment": attachments[0]})
+}
+
+// ListAttachments 查询患者的附件，最近上传的在前，可按类别、处方或门诊病历筛选
+func (pc *PatientController) ListAttachments(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	whereClause := "WHERE a.patient_id = ?"
+	args := []interface{}{patientID}
+	if category := c.Query("category"); category != "" {
+		if _, ok := models.AttachmentCategoryNames[category]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的附件类别"})
+			return
+		}
+		whereClause += " AND a.category = ?"
+		args = append(args, category)
+	}
+	if prescriptionID, _ := strconv.Atoi(c.Query("prescription_id")); prescriptionID > 0 {
+		whereClause += " AND a.prescription_id = ?"
+		args = append(args, prescriptionID)
+	}
+	if encounterID, _ := strconv.Atoi(c.Query("encounter_id")); encounterID > 0 {
+		whereClause += " AND a.encounter_id = ?"
+		args = append(args, encounterID)
+	}
+
+	attachments, err := queryAttachments(whereClause+" ORDER BY a.created_at DESC, a.id DESC", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询附件失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"attachments": attachments,
+		"total":       len(attachments),
+		"categories":  models.AttachmentCategoryNames,
+	})
+}
+
+// DownloadAttachment 下载附件原文件，inline=1 时在浏览器中直接打开
+func (pc *PatientController) DownloadAttachment(c *gin.Context) {
+	attachment, ok := patientAttachment(c)
+	if !ok {
+		return
+	}
+
+	path := attachmentPath(attachment.SHA256)
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "附件文件不存在，请检查附件目录"})
+		return
+	}
+
+	middleware.SetOperationDescription(c, fmt.Sprintf("下载患者（ID %d）的附件【%s】", attachment.PatientID, attachment.FileName))
+	c.Header("Content-Type", attachment.MimeType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	if c.Query("inline") == "1" {
+		c.Header("Content-Disposition", "inline")
+		c.File(path)
+		return
+	}
+	c.FileAttachment(path, attachment.FileName)
+}
+
+// AttachmentThumbnail 返回图片附件的缩略图
+func (pc *PatientController) AttachmentThumbnail(c *gin.Context) {
+	attachment, ok := patientAttachment(c)
+	if !ok {
+		return
+	}
+	if !attachment.HasThumbnail {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该附件没有缩略图"})
+		return
+	}
+
+	// 缩略图丢失时重新生成，与删除附件串行，避免删除后留下缩略图
+	path := attachmentPath(attachment.SHA256) + thumbnailSuffix
+	attachmentFilesMu.Lock()
+	_, err := os.Stat(path)
+	exists := err == nil || makeThumbnail(attachment.SHA256)
+	attachmentFilesMu.Unlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该附件没有缩略图"})
+		return
+	}
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.File(path)
+}
+
+// DeleteAttachment 删除上传错误的附件，仅上传人或管理员可删除；文件不再被其他附件引用时一并删除
+func (pc *PatientController) DeleteAttachment(c *gin.Context) {
+	attachment, ok := patientAttachment(c)
+	if !ok {
+		return
+	}
+	if attachment.UploadedBy != currentUserID(c) && currentUserRole(c) != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能删除本人上传的附件"})
+		return
+	}
+
+	attachmentFilesMu.Lock()
+	defer attachmentFilesMu.Unlock()
+
+	if _, err := database.DB.Exec("DELETE FROM patient_attachments WHERE id = ?", attachment.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除附件失败"})
+		return
+	}
+	removeAttachmentFile(attachment.SHA256)
+	middleware.SetOperationDescription(c, fmt.Sprintf("删除患者（ID %d）的附件【%s】", attachment.PatientID, attachment.FileName))
+
+	c.JSON(http.StatusOK, gin.H{"message": "附件已删除"})
+}
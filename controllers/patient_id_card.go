@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/database"
+	"lighthospital/models"
+	"strings"
+	"time"
+)
+
+// idCardWeights 公民身份号码前17位的加权因子（GB 11643）
+var idCardWeights = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+
+// idCardCheckCodes 加权和除以11的余数对应的校验码
+const idCardCheckCodes = "10X98765432"
+
+// idCardProvinces 身份证号前两位的省级行政区划代码
+var idCardProvinces = map[string]bool{
+	"11": true, "12": true, "13": true, "14": true, "15": true,
+	"21": true, "22": true, "23": true,
+	"31": true, "32": true, "33": true, "34": true, "35": true, "36": true, "37": true,
+	"41": true, "42": true, "43": true, "44": true, "45": true, "46": true,
+	"50": true, "51": true, "52": true, "53": true, "54": true,
+	"61": true, "62": true, "63": true, "64": true, "65": true,
+	"71": true, "81": true, "82": true, "83": true,
+}
+
+// parseIDCard 校验18位公民身份号码的地区码、出生日期和校验码，返回规范化的号码、出生日期和性别
+func parseIDCard(idCard string) (string, time.Time, string, string) {
+	idCard = strings.ToUpper(strings.TrimSpace(idCard))
+	if len(idCard) != 18 {
+		return "", time.Time{}, "", "身份证号应为18位"
+	}
+
+	sum := 0
+	for i := 0; i < 17; i++ {
+		if idCard[i] < '0' || idCard[i] > '9' {
+			return "", time.Time{}, "", "身份证号前17位应为数字"
+		}
+		sum += int(idCard[i]-'0') * idCardWeights[i]
+	}
+	if idCard[17] != idCardCheckCodes[sum%11] {
+		return "", time.Time{}, "", "身份证号校验码错误，请核对"
+	}
+
+	if !idCardProvinces[idCard[:2]] || idCard[2:6] == "0000" {
+		return "", time.Time{}, "", "身份证号地区码无效"
+	}
+
+	birth, err := time.ParseInLocation("20060102", idCard[6:14], time.Local)
+	if err != nil || birth.After(time.Now()) || birth.Year() < 1900 {
+		return "", time.Time{}, "", "身份证号中的出生日期无效"
+	}
+
+	// 第17位为顺序码，奇数为男性，偶数为女性
+	gender := "女"
+	if (idCard[16]-'0')%2 == 1 {
+		gender = "男"
+	}
+	return idCard, birth, gender, ""
+}
+
+// applyPatientIDCard 校验患者身份证号，按身份证号补全性别和出生日期；
+// 已录入的性别或准确的出生日期与身份证号不符时返回错误，估算的出生日期以身份证号为准
+func applyPatientIDCard(patient *models.Patient) string {
+	if strings.TrimSpace(patient.IDCard) == "" {
+		patient.IDCard = ""
+		return ""
+	}
+
+	idCard, birth, gender, msg := parseIDCard(patient.IDCard)
+	if msg != "" {
+		return msg
+	}
+	patient.IDCard = idCard
+
+	patient.Gender = strings.TrimSpace(patient.Gender)
+	if patient.Gender == "" {
+		patient.Gender = gender
+	} else if patient.Gender != gender {
+		return fmt.Sprintf("性别与身份证号不符，身份证号对应的性别为%s", gender)
+	}
+
+	birthDate := birth.Format(models.BirthDateLayout)
+	if patient.BirthDate != "" && !patient.BirthDateEstimated && patient.BirthDate != birthDate {
+		return fmt.Sprintf("出生日期与身份证号不符，身份证号对应的出生日期为%s", birthDate)
+	}
+	patient.BirthDate = birthDate
+	patient.BirthDateEstimated = false
+	patient.FillAge(time.Now())
+	return ""
+}
+
+// idCardOwner 查询已登记该身份证号的其他患者，未登记时返回 nil
+func idCardOwner(q rowQuerier, idCard string, excludeID int) (*models.Patient, error) {
+	if idCard == "" {
+		return nil, nil
+	}
+	idCardIndex, _ := database.IDCardIndexes(idCard)
+	patient, err := scanPatient(q.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id_card_bidx = ? AND id != ?",
+		idCardIndex, excludeID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &patient, nil
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+// withCheckCode 为前17位补上正确的校验码，用于构造地区码、出生日期无效但校验码正确的号码
+func withCheckCode(first17 string) string {
+	sum := 0
+	for i := 0; i < 17; i++ {
+		sum += int(first17[i]-'0') * idCardWeights[i]
+	}
+	return first17 + string(idCardCheckCodes[sum%11])
+}
+
+func TestParseIDCard(t *testing.T) {
+	future := time.Now().AddDate(1, 0, 0).Format("20060102")
+
+	tests := []struct {
+		name       string
+		idCard     string
+		wantIDCard string
+		wantBirth  string
+		wantGender string
+		wantMsg    string
+	}{
+		{
+			name:       "校验码为X",
+			idCard:     "11010519491231002X",
+			wantIDCard: "11010519491231002X",
+			wantBirth:  "1949-12-31",
+			wantGender: "女",
+		},
+		{
+			name:       "小写x及首尾空白",
+			idCard:     " 11010519491231002x ",
+			wantIDCard: "11010519491231002X",
+			wantBirth:  "1949-12-31",
+			wantGender: "女",
+		},
+		{
+			name:       "男性",
+			idCard:     "44030419900307123X",
+			wantIDCard: "44030419900307123X",
+			wantBirth:  "1990-03-07",
+			wantGender: "男",
+		},
+		{
+			name:       "数字校验码",
+			idCard:     "110101201506010010",
+			wantIDCard: "110101201506010010",
+			wantBirth:  "2015-06-01",
+			wantGender: "男",
+		},
+		{
+			name:       "闰年2月29日",
+			idCard:     withCheckCode("11010120000229002"),
+			wantIDCard: withCheckCode("11010120000229002"),
+			wantBirth:  "2000-02-29",
+			wantGender: "女",
+		},
+		{name: "校验码错误", idCard: "440304199003071235", wantMsg: "身份证号校验码错误，请核对"},
+		{name: "校验码应为X却填写数字", idCard: "110105194912310021", wantMsg: "身份证号校验码错误，请核对"},
+		{name: "位数不足", idCard: "11010519491231002", wantMsg: "身份证号应为18位"},
+		{name: "15位旧号码", idCard: "110105491231002", wantMsg: "身份证号应为18位"},
+		{name: "前17位含字母", idCard: "1101051949123100AX", wantMsg: "身份证号前17位应为数字"},
+		{name: "非闰年2月29日", idCard: withCheckCode("11010120230229001"), wantMsg: "身份证号中的出生日期无效"},
+		{name: "月份无效", idCard: withCheckCode("11010119901301001"), wantMsg: "身份证号中的出生日期无效"},
+		{name: "出生日期在未来", idCard: withCheckCode("110101" + future + "001"), wantMsg: "身份证号中的出生日期无效"},
+		{name: "出生年份早于1900年", idCard: withCheckCode("11010118991231001"), wantMsg: "身份证号中的出生日期无效"},
+		{name: "未知省份", idCard: withCheckCode("99010119900307001"), wantMsg: "身份证号地区码无效"},
+		{name: "地区码全为0", idCard: withCheckCode("11000019900307001"), wantMsg: "身份证号地区码无效"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idCard, birth, gender, msg := parseIDCard(tt.idCard)
+			if msg != tt.wantMsg {
+				t.Fatalf("parseIDCard(%q) 提示 %q，应为 %q", tt.idCard, msg, tt.wantMsg)
+			}
+			if tt.wantMsg != "" {
+				return
+			}
+			if idCard != tt.wantIDCard {
+				t.Errorf("号码 %q，应为 %q", idCard, tt.wantIDCard)
+			}
+			if got := birth.Format("2006-01-02"); got != tt.wantBirth {
+				t.Errorf("出生日期 %s，应为 %s", got, tt.wantBirth)
+			}
+			if gender != tt.wantGender {
+				t.Errorf("性别 %s，应为 %s", gender, tt.wantGender)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"fmt"
+	"lighthospital/database"
+	"lighthospital/middleware"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maskPatient 当前用户的角色不能直接查看完整号码时，对患者电话和身份证号脱敏
+func maskPatient(c *gin.Context, patient *models.Patient) {
+	if patient != nil && !models.RoleViewsFullPII(currentUserRole(c)) {
+		patient.MaskPII()
+	}
+}
+
+// maskPatients 对患者列表逐条脱敏
+func maskPatients(c *gin.Context, patients []models.Patient) {
+	for i := range patients {
+		maskPatient(c, &patients[i])
+	}
+}
+
+// keepMaskedPII 修改档案时提交的电话、身份证号为脱敏后的号码（未修改）时，保留原号码
+func keepMaskedPII(patient *models.Patient, existing models.Patient) {
+	if strings.Contains(patient.Phone, "*") && patient.Phone == models.MaskPhone(existing.Phone) {
+		patient.Phone = existing.Phone
+	}
+	if strings.Contains(patient.IDCard, "*") && patient.IDCard == models.MaskIDCard(existing.IDCard) {
+		patient.IDCard = existing.IDCard
+	}
+}
+
+// Reveal 填写原因后查看患者完整的电话和身份证号，原因写入操作日志
+func (pc *PatientController) Reveal(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请填写查看原因"})
+		return
+	}
+
+	patient, err := scanPatient(database.DB.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id = ?", id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+
+	middleware.SetOperationDescription(c, fmt.Sprintf("查看患者【%s】（ID %d）的完整电话和身份证号，原因：%s", patient.Name, patient.ID, req.Reason))
+	c.JSON(http.StatusOK, gin.H{
+		"id":      patient.ID,
+		"phone":   patient.Phone,
+		"id_card": patient.IDCard,
+	})
+}
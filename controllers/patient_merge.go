@@ -0,0 +1,496 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"lighthospital/database"
+	"lighthospital/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 查重评分：身份证号、姓名、电话一致加分，性别不同或年龄相差较大减分；身份证号不同视为不同的人
+const (
+	duplicateScoreIDCard   = 60
+	duplicateScoreName     = 30
+	duplicateScorePinyin   = 20 // 姓名不同但拼音相同，如 张伟/章伟
+	duplicateScorePhone    = 30
+	duplicateScoreAge      = 10
+	duplicatePenaltyGender = 40
+	duplicatePenaltyAge    = 20
+
+	duplicateThreshold = 40 // 低于此分不列为疑似重复
+	duplicateHighScore = 80
+)
+
+// patientReferenceTables 所有按 patient_id 引用患者的表，合并档案时逐表转移，删除档案前逐表检查；新增关联患者的表需同步添加
+var patientReferenceTables = []string{
+	"prescriptions",
+	"appointments",
+	"encounters",
+	"vital_signs",
+	"patient_allergies",
+	"controlled_drug_register",
+	"patient_attachments",
+}
+
+// patientColumns 查询患者档案的字段，可为空的列统一转为空字符串
+const patientColumns = `id, name, COALESCE(pinyin, ''), gender, age, COALESCE(phone, ''), COALESCE(address, ''),
+	COALESCE(id_card, ''), COALESCE(medical_history, ''), created_at, updated_at, birth_date, birth_date_estimated`
+
+// scanPatient 按 patientColumns 的字段顺序读取患者档案，解密敏感字段并按出生日期计算当前年龄
+func scanPatient(row interface {
+	Scan(dest ...interface{}) error
+}) (models.Patient, error) {
+	var patient models.Patient
+	err := row.Scan(&patient.ID, &patient.Name, &patient.Pinyin, &patient.Gender, &patient.Age, &patient.Phone,
+		&patient.Address, &patient.IDCard, &patient.MedicalHistory, &patient.CreatedAt, &patient.UpdatedAt,
+		&patient.BirthDate, &patient.BirthDateEstimated)
+	if err != nil {
+		return patient, err
+	}
+	for _, field := range []*string{&patient.Phone, &patient.Address, &patient.IDCard, &patient.MedicalHistory} {
+		if *field, err = database.DecryptPII(*field); err != nil {
+			return patient, err
+		}
+	}
+	patient.FillAge(time.Now())
+	return patient, nil
+}
+
+// idCardConflict 两份档案都填写了身份证号且不一致，可确定不是同一人
+func idCardConflict(a, b models.Patient) bool {
+	idCardA := strings.ToUpper(strings.TrimSpace(a.IDCard))
+	idCardB := strings.ToUpper(strings.TrimSpace(b.IDCard))
+	return idCardA != "" && idCardB != "" && idCardA != idCardB
+}
+
+// scoreDuplicate 计算两份档案属于同一人的评分（0-100）及依据
+func scoreDuplicate(a, b models.Patient) (int, []string) {
+	if idCardConflict(a, b) {
+		return 0, []string{"身份证号不同"}
+	}
+
+	score := 0
+	var reasons []string
+	idCardA := strings.ToUpper(strings.TrimSpace(a.IDCard))
+	if idCardA != "" && idCardA == strings.ToUpper(strings.TrimSpace(b.IDCard)) {
+		score += duplicateScoreIDCard
+		reasons = append(reasons, "身份证号相同")
+	}
+
+	nameA := strings.TrimSpace(a.Name)
+	nameB := strings.TrimSpace(b.Name)
+	if nameA != "" && nameA == nameB {
+		score += duplicateScoreName
+		reasons = append(reasons, "姓名相同")
+	} else if pinyinA := generatePinyin(nameA); pinyinA != "" && pinyinA == generatePinyin(nameB) {
+		score += duplicateScorePinyin
+		reasons = append(reasons, "姓名拼音相同")
+	}
+
+	phoneA := strings.TrimSpace(a.Phone)
+	if phoneA != "" && phoneA == strings.TrimSpace(b.Phone) {
+		score += duplicateScorePhone
+		reasons = append(reasons, "电话相同")
+	}
+
+	if a.Gender != "" && b.Gender != "" && a.Gender != b.Gender {
+		score -= duplicatePenaltyGender
+		reasons = append(reasons, "性别不同")
+	}
+
+	if !a.BirthDateEstimated && !b.BirthDateEstimated && a.BirthDate != "" && a.BirthDate == b.BirthDate {
+		score += duplicateScoreAge
+		reasons = append(reasons, "出生日期相同")
+	} else if a.Age > 0 && b.Age > 0 {
+		diff := a.Age - b.Age
+		if diff < 0 {
+			diff = -diff
+		}
+		switch {
+		case diff <= 1:
+			score += duplicateScoreAge
+			reasons = append(reasons, "年龄相符")
+		case diff > 5:
+			score -= duplicatePenaltyAge
+			reasons = append(reasons, fmt.Sprintf("年龄相差%d岁", diff))
+		}
+	}
+
+	if score < 0 {
+		score = 0
+	}
+	if score > 100 {
+		score = 100
+	}
+	return score, reasons
+}
+
+func duplicateLevel(score int) string {
+	if score >= duplicateHighScore {
+		return models.DuplicateHigh
+	}
+	return models.DuplicateMedium
+}
+
+// duplicateCandidates 查找与指定档案疑似重复的其他档案，按评分从高到低排列；
+// 仅对姓名、拼音、电话或身份证号有交集的档案评分
+func duplicateCandidates(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, patient models.Patient) ([]models.DuplicateCandidate, error) {
+	phoneIndex, _ := database.PhoneIndexes(patient.Phone)
+	idCardIndex, _ := database.IDCardIndexes(patient.IDCard)
+	rows, err := q.Query(`
+		SELECT `+patientColumns+` FROM patients
+		WHERE id != ? AND (name = ? OR (pinyin != '' AND pinyin = ?)
+		      OR (phone_bidx != '' AND phone_bidx = ?) OR (id_card_bidx != '' AND id_card_bidx = ?))`,
+		patient.ID, patient.Name, generatePinyin(patient.Name), phoneIndex, idCardIndex)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []models.DuplicateCandidate{}
+	for rows.Next() {
+		other, err := scanPatient(rows)
+		if err != nil {
+			return nil, err
+		}
+		score, reasons := scoreDuplicate(patient, other)
+		if score < duplicateThreshold {
+			continue
+		}
+		candidates = append(candidates, models.DuplicateCandidate{
+			Patient: other,
+			Score:   score,
+			Level:   duplicateLevel(score),
+			Reasons: reasons,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates, nil
+}
+
+// Duplicates 查询与指定患者疑似重复的档案
+func (pc *PatientController) Duplicates(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	patient, err := scanPatient(database.DB.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id = ?", id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+
+	candidates, err := duplicateCandidates(database.DB, patient)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询疑似重复档案失败"})
+		return
+	}
+
+	maskPatient(c, &patient)
+	for i := range candidates {
+		maskPatient(c, &candidates[i].Patient)
+	}
+	c.JSON(http.StatusOK, gin.H{"patient": patient, "candidates": candidates})
+}
+
+// ScanDuplicates 全院查重，列出疑似重复的档案对，按评分从高到低排列
+func (pc *PatientController) ScanDuplicates(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	rows, err := database.DB.Query("SELECT " + patientColumns + " FROM patients ORDER BY id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询患者失败"})
+		return
+	}
+	defer rows.Close()
+
+	// 按姓名拼音、电话、身份证号分组，只比较同组内的档案
+	var patients []models.Patient
+	groups := map[string][]int{}
+	for rows.Next() {
+		patient, err := scanPatient(rows)
+		if err != nil {
+			continue
+		}
+		index := len(patients)
+		patients = append(patients, patient)
+		if key := generatePinyin(patient.Name); key != "" {
+			groups["name:"+key] = append(groups["name:"+key], index)
+		}
+		if phone := strings.TrimSpace(patient.Phone); phone != "" {
+			groups["phone:"+phone] = append(groups["phone:"+phone], index)
+		}
+		if idCard := strings.ToUpper(strings.TrimSpace(patient.IDCard)); idCard != "" {
+			groups["id_card:"+idCard] = append(groups["id_card:"+idCard], index)
+		}
+	}
+
+	pairs := []models.DuplicatePair{}
+	seen := map[[2]int]bool{}
+	for _, members := range groups {
+		for i := 0; i < len(members); i++ {
+			for j := i + 1; j < len(members); j++ {
+				a, b := patients[members[i]], patients[members[j]]
+				key := [2]int{a.ID, b.ID}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				score, reasons := scoreDuplicate(a, b)
+				if score < duplicateThreshold {
+					continue
+				}
+				pairs = append(pairs, models.DuplicatePair{
+					PatientA: a,
+					PatientB: b,
+					Score:    score,
+					Level:    duplicateLevel(score),
+					Reasons:  reasons,
+				})
+			}
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		return pairs[i].PatientA.ID < pairs[j].PatientA.ID
+	})
+	total := len(pairs)
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+
+	for i := range pairs {
+		maskPatient(c, &pairs[i].PatientA)
+		maskPatient(c, &pairs[i].PatientB)
+	}
+
+	// 旧数据中身份证号重复的档案须先合并，之后才能启用身份证号唯一约束
+	conflicts, err := database.PatientIDCardConflicts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询重复身份证号失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"pairs":             pairs,
+		"total":             total,
+		"id_card_conflicts": conflicts,
+		"id_card_unique":    len(conflicts) == 0,
+	})
+}
+
+// Merge 将另一份档案合并到当前档案：在同一事务内转移全部关联记录，补全当前档案的空白信息，
+// 删除被合并的档案并保存合并记录；身份证号不同的档案不能合并
+func (pc *PatientController) Merge(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	var req struct {
+		MergeID int    `json:"merge_id" binding:"required"` // 被合并的档案
+		Reason  string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请填写合并原因"})
+		return
+	}
+	if req.MergeID == id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能与自身合并"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "合并患者失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	survivor, err := scanPatient(tx.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id = ?", id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+	merged, err := scanPatient(tx.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id = ?", req.MergeID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "被合并的患者不存在"})
+		return
+	}
+
+	if idCardConflict(survivor, merged) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "两份档案身份证号不同，不能合并"})
+		return
+	}
+	score, _ := scoreDuplicate(survivor, merged)
+
+	reassigned := map[string]int{}
+	for _, table := range patientReferenceTables {
+		result, err := tx.Exec("UPDATE "+table+" SET patient_id = ? WHERE patient_id = ?", id, req.MergeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "转移关联记录失败"})
+			return
+		}
+		affected, _ := result.RowsAffected()
+		reassigned[table] = int(affected)
+	}
+
+	// 先删除被合并档案，身份证号唯一，补全到保留档案前需释放
+	if _, err := tx.Exec("DELETE FROM patients WHERE id = ?", req.MergeID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除被合并的档案失败"})
+		return
+	}
+
+	// 保留档案的信息为准，空白项用被合并档案补全；保留档案的出生日期为估算时取被合并档案的准确出生日期
+	now := time.Now()
+	useMergedBirth := merged.BirthDate != "" && !merged.BirthDateEstimated && (survivor.BirthDate == "" || survivor.BirthDateEstimated)
+	pii, err := database.EncryptPatientPII(merged.Phone, merged.Address, merged.IDCard, merged.MedicalHistory)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "合并患者失败"})
+		return
+	}
+	_, err = tx.Exec(`
+		UPDATE patients SET
+			gender = CASE WHEN COALESCE(gender, '') = '' THEN ? ELSE gender END,
+			phone = CASE WHEN COALESCE(phone, '') = '' THEN ? ELSE phone END,
+			phone_bidx = CASE WHEN COALESCE(phone, '') = '' THEN ? ELSE phone_bidx END,
+			phone_tail_bidx = CASE WHEN COALESCE(phone, '') = '' THEN ? ELSE phone_tail_bidx END,
+			address = CASE WHEN COALESCE(address, '') = '' THEN ? ELSE address END,
+			id_card = CASE WHEN COALESCE(id_card, '') = '' THEN ? ELSE id_card END,
+			id_card_bidx = CASE WHEN COALESCE(id_card, '') = '' THEN ? ELSE id_card_bidx END,
+			id_card_tail_bidx = CASE WHEN COALESCE(id_card, '') = '' THEN ? ELSE id_card_tail_bidx END,
+			medical_history = CASE WHEN COALESCE(medical_history, '') = '' THEN ? ELSE medical_history END,
+			birth_date = CASE WHEN ? THEN ? ELSE birth_date END,
+			birth_date_estimated = CASE WHEN ? THEN 0 ELSE birth_date_estimated END,
+			updated_at = ?
+		WHERE id = ?`,
+		merged.Gender, pii.Phone, pii.PhoneIndex, pii.PhoneTailIndex, pii.Address, pii.IDCard, pii.IDCardIndex,
+		pii.IDCardTailIndex, pii.MedicalHistory, useMergedBirth, merged.BirthDate, useMergedBirth, now, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "合并患者失败"})
+		return
+	}
+
+	snapshot, _ := json.Marshal(merged)
+	counts, _ := json.Marshal(reassigned)
+	encryptedSnapshot, err := database.EncryptPII(string(snapshot))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存合并记录失败"})
+		return
+	}
+	_, err = tx.Exec(`
+		INSERT INTO patient_merges (surviving_patient_id, merged_patient_id, merged_patient, reassigned, score, reason, merged_by, merged_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, req.MergeID, encryptedSnapshot, string(counts), score, req.Reason, currentUserID(c), now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存合并记录失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "合并患者失败"})
+		return
+	}
+
+	// 重复身份证号全部合并后启用唯一约束
+	if _, err := database.EnsurePatientIDCardIndex(); err != nil {
+		log.Printf("建立身份证号唯一索引失败: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "患者档案合并成功",
+		"score":      score,
+		"reassigned": reassigned,
+	})
+}
+
+// ListMerges 分页查询患者档案合并记录，可按保留档案的患者ID筛选
+func (pc *PatientController) ListMerges(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	whereClause := "WHERE 1=1"
+	var args []interface{}
+	if patientID, _ := strconv.Atoi(c.Query("patient_id")); patientID > 0 {
+		whereClause += " AND (m.surviving_patient_id = ? OR m.merged_patient_id = ?)"
+		args = append(args, patientID, patientID)
+	}
+
+	var total int
+	database.DB.QueryRow("SELECT COUNT(*) FROM patient_merges m "+whereClause, args...).Scan(&total)
+
+	rows, err := database.DB.Query(`
+		SELECT m.id, m.surviving_patient_id, m.merged_patient_id, m.merged_patient, m.reassigned, m.score, m.reason,
+		       m.merged_by, COALESCE(u.name, ''), m.merged_at
+		FROM patient_merges m
+		LEFT JOIN users u ON m.merged_by = u.id
+		`+whereClause+` ORDER BY m.merged_at DESC, m.id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询合并记录失败"})
+		return
+	}
+	defer rows.Close()
+
+	merges := []models.PatientMerge{}
+	for rows.Next() {
+		var merge models.PatientMerge
+		var snapshot, counts string
+		err := rows.Scan(&merge.ID, &merge.SurvivingPatientID, &merge.MergedPatientID, &snapshot, &counts, &merge.Score,
+			&merge.Reason, &merge.MergedBy, &merge.MergedByName, &merge.MergedAt)
+		if err != nil {
+			continue
+		}
+		if snapshot, err = database.DecryptPII(snapshot); err != nil {
+			continue
+		}
+		json.Unmarshal([]byte(snapshot), &merge.MergedPatient)
+		json.Unmarshal([]byte(counts), &merge.Reassigned)
+		merges = append(merges, merge)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"merges": merges,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"database/sql"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timelineSources 各类事件的来源查询，统一返回事件类型、动作、记录ID和事件时间
+var timelineSources = []struct {
+	eventType string
+	query     string
+}{
+	{models.TimelineAppointment, `SELECT 'appointment' AS type, '' AS action, id AS record_id, appointment_time AS event_time
+		FROM appointments WHERE patient_id = ?`},
+	{models.TimelineEncounter, `SELECT 'encounter' AS type, '' AS action, id AS record_id, visit_time AS event_time
+		FROM encounters WHERE patient_id = ?`},
+	{models.TimelinePrescription, `SELECT 'prescription' AS type, '' AS action, id AS record_id, created_at AS event_time
+		FROM prescriptions WHERE patient_id = ?`},
+	{models.TimelineVitalSign, `SELECT 'vital_sign' AS type, '' AS action, id AS record_id, measured_at AS event_time
+		FROM vital_signs WHERE patient_id = ?`},
+	{models.TimelineAllergy, `SELECT 'allergy' AS type, 'added' AS action, id AS record_id, created_at AS event_time
+		FROM patient_allergies WHERE patient_id = ?`},
+	{models.TimelineAllergy, `SELECT 'allergy' AS type, 'removed' AS action, id AS record_id, deleted_at AS event_time
+		FROM patient_allergies WHERE patient_id = ? AND deleted_at IS NOT NULL`},
+	{models.TimelineAttachment, `SELECT 'attachment' AS type, '' AS action, id AS record_id, created_at AS event_time
+		FROM patient_attachments WHERE patient_id = ?`},
+}
+
+// Timeline 按时间倒序合并患者的预约、病历、处方（含明细）、生命体征、过敏史变更和附件，
+// 支持按事件类型（types=prescription,vital_sign）和日期筛选并分页
+func (pc *PatientController) Timeline(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	var exists int
+	database.DB.QueryRow("SELECT COUNT(*) FROM patients WHERE id = ?", patientID).Scan(&exists)
+	if exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	// 未指定类型时返回全部事件
+	known := map[string]bool{}
+	for _, source := range timelineSources {
+		known[source.eventType] = true
+	}
+	types := map[string]bool{}
+	if typesParam := strings.TrimSpace(c.Query("types")); typesParam != "" {
+		for _, eventType := range strings.Split(typesParam, ",") {
+			eventType = strings.TrimSpace(eventType)
+			if !known[eventType] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的事件类型：" + eventType})
+				return
+			}
+			types[eventType] = true
+		}
+	}
+
+	var sources []string
+	var args []interface{}
+	for _, source := range timelineSources {
+		if len(types) > 0 && !types[source.eventType] {
+			continue
+		}
+		sources = append(sources, source.query)
+		args = append(args, patientID)
+	}
+
+	whereClause := "WHERE 1=1"
+	if startDate := c.Query("start_date"); startDate != "" {
+		start, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的开始日期"})
+			return
+		}
+		whereClause += " AND event_time >= ?"
+		args = append(args, start)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		end, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的结束日期"})
+			return
+		}
+		whereClause += " AND event_time < ?"
+		args = append(args, end.AddDate(0, 0, 1))
+	}
+
+	union := "(" + strings.Join(sources, " UNION ALL ") + ") t "
+	var total int
+	database.DB.QueryRow("SELECT COUNT(*) FROM "+union+whereClause, args...).Scan(&total)
+
+	rows, err := database.DB.Query(`
+		SELECT type, action, record_id FROM `+union+whereClause+`
+		ORDER BY event_time DESC, record_id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询诊疗时间线失败"})
+		return
+	}
+	events := []models.TimelineEvent{}
+	for rows.Next() {
+		var event models.TimelineEvent
+		if err := rows.Scan(&event.Type, &event.Action, &event.ID); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+
+	// 逐条补全事件详情
+	for i := range events {
+		if err := loadTimelineEvent(&events[i]); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询诊疗时间线失败"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
+
+// loadTimelineEvent 按事件类型查询完整记录并填写事件时间
+func loadTimelineEvent(event *models.TimelineEvent) error {
+	switch event.Type {
+	case models.TimelineAppointment:
+		var appointment models.Appointment
+		var doctorName string
+		err := database.DB.QueryRow(`
+			SELECT a.id, a.patient_id, a.doctor_id, a.appointment_time, a.duration, a.status, COALESCE(a.notes, ''),
+			       a.created_at, a.updated_at, COALESCE(u.name, '')
+			FROM appointments a
+			LEFT JOIN users u ON a.doctor_id = u.id
+			WHERE a.id = ?`, event.ID).Scan(
+			&appointment.ID, &appointment.PatientID, &appointment.DoctorID, &appointment.AppointmentTime, &appointment.Duration,
+			&appointment.Status, &appointment.Notes, &appointment.CreatedAt, &appointment.UpdatedAt, &doctorName)
+		if err != nil {
+			return err
+		}
+		appointment.Doctor = &models.User{ID: appointment.DoctorID, Name: doctorName}
+		event.Time = appointment.AppointmentTime
+		event.Data = appointment
+
+	case models.TimelineEncounter:
+		encounter, err := loadEncounter(event.ID)
+		if err != nil {
+			return err
+		}
+		event.Time = encounter.VisitTime
+		event.Data = encounter
+
+	case models.TimelinePrescription:
+		prescription, err := loadTimelinePrescription(event.ID)
+		if err != nil {
+			return err
+		}
+		event.Time = prescription.CreatedAt
+		event.Data = prescription
+
+	case models.TimelineVitalSign:
+		vitals, err := queryVitalSigns("WHERE v.id = ?", event.ID)
+		if err != nil {
+			return err
+		}
+		if len(vitals) == 0 {
+			return sql.ErrNoRows
+		}
+		event.Time = vitals[0].MeasuredAt
+		event.Data = vitals[0]
+
+	case models.TimelineAllergy:
+		var allergy models.PatientAllergy
+		err := database.DB.QueryRow(`
+			SELECT id, patient_id, allergen_type, medicine_id, allergen, COALESCE(reaction, ''), severity,
+			       COALESCE(notes, ''), created_by, created_at, updated_at, deleted_at
+			FROM patient_allergies WHERE id = ?`, event.ID).Scan(
+			&allergy.ID, &allergy.PatientID, &allergy.AllergenType, &allergy.MedicineID, &allergy.Allergen,
+			&allergy.Reaction, &allergy.Severity, &allergy.Notes, &allergy.CreatedBy, &allergy.CreatedAt, &allergy.UpdatedAt,
+			&allergy.DeletedAt)
+		if err != nil {
+			return err
+		}
+		event.Time = allergy.CreatedAt
+		if event.Action == "removed" && allergy.DeletedAt != nil {
+			event.Time = *allergy.DeletedAt
+		}
+		event.Data = allergy
+
+	case models.TimelineAttachment:
+		attachments, err := queryAttachments("WHERE a.id = ?", event.ID)
+		if err != nil {
+			return err
+		}
+		if len(attachments) == 0 {
+			return sql.ErrNoRows
+		}
+		event.Time = attachments[0].CreatedAt
+		event.Data = attachments[0]
+	}
+	return nil
+}
+
+// loadTimelinePrescription 查询处方基本信息、编码诊断及明细
+func loadTimelinePrescription(id int) (*models.Prescription, error) {
+	var prescription models.Prescription
+	var doctorName string
+	err := database.DB.QueryRow(`
+		SELECT p.id, p.prescription_no, p.patient_id, p.doctor_id, COALESCE(p.diagnosis, ''), COALESCE(p.doctor_advice, ''),
+		       p.total_amount, p.status, COALESCE(p.notes, ''), p.prescription_type, p.herbal_doses, p.decoction_method,
+		       p.encounter_id, p.created_at, p.updated_at, COALESCE(u.name, '')
+		FROM prescriptions p
+		LEFT JOIN users u ON p.doctor_id = u.id
+		WHERE p.id = ?`, id).Scan(
+		&prescription.ID, &prescription.PrescriptionNo, &prescription.PatientID, &prescription.DoctorID, &prescription.Diagnosis,
+		&prescription.DoctorAdvice, &prescription.TotalAmount, &prescription.Status, &prescription.Notes,
+		&prescription.PrescriptionType, &prescription.HerbalDoses, &prescription.DecoctionMethod,
+		&prescription.EncounterID, &prescription.CreatedAt, &prescription.UpdatedAt, &doctorName)
+	if err != nil {
+		return nil, err
+	}
+	prescription.Doctor = &models.User{ID: prescription.DoctorID, Name: doctorName}
+
+	prescription.Diagnoses, err = loadRecordDiagnoses(database.DB, models.DiagnosisRecordPrescription, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, prescription_id, medicine_id, medicine_name, specification, dosage, usage, frequency, days, quantity,
+		       unit_price, total_price, herb_grams, special_processing
+		FROM prescription_items WHERE prescription_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.PrescriptionItem
+		err := rows.Scan(&item.ID, &item.PrescriptionID, &item.MedicineID, &item.MedicineName, &item.Specification,
+			&item.Dosage, &item.Usage, &item.Frequency, &item.Days, &item.Quantity, &item.UnitPrice, &item.TotalPrice,
+			&item.HerbGrams, &item.SpecialProcessing)
+		if err != nil {
+			return nil, err
+		}
+		prescription.Items = append(prescription.Items, item)
+	}
+	return &prescription, rows.Err()
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"database/sql"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PharmacyController 药房审核与发药
+type PharmacyController struct{}
+
+// Queue 药房工作队列：stage=review 为待审核（已完成、已打印），按完成时间先后排列；
+// stage=dispense 为审核通过待发药，按审核时间先后排列
+func (pc *PharmacyController) Queue(c *gin.Context) {
+	stage := c.DefaultQuery("stage", "review")
+
+	var where, orderBy string
+	var args []interface{}
+	switch stage {
+	case "review":
+		where = "p.status IN (?, ?)"
+		args = append(args, models.PrescriptionCompleted, models.PrescriptionPrinted)
+		orderBy = "p.completed_at, p.id"
+	case "dispense":
+		where = "p.status = ?"
+		args = append(args, models.PrescriptionApproved)
+		orderBy = "p.reviewed_at, p.id"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的队列类型"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT p.id, p.prescription_no, p.patient_id, p.doctor_id, p.diagnosis, p.total_amount, p.status, p.prescription_type,
+		       p.completed_at, p.reviewed_at, p.review_note, p.created_at, p.updated_at,
+		       pt.name as patient_name, u.name as doctor_name, COALESCE(r.name, '') as reviewer_name
+		FROM prescriptions p
+		LEFT JOIN patients pt ON p.patient_id = pt.id
+		LEFT JOIN users u ON p.doctor_id = u.id
+		LEFT JOIN users r ON p.reviewed_by = r.id
+		WHERE `+where+` ORDER BY `+orderBy, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询药房队列失败"})
+		return
+	}
+	defer rows.Close()
+
+	prescriptions := []models.Prescription{}
+	for rows.Next() {
+		var prescription models.Prescription
+		var patientName, doctorName sql.NullString
+		err := rows.Scan(
+			&prescription.ID, &prescription.PrescriptionNo, &prescription.PatientID, &prescription.DoctorID,
+			&prescription.Diagnosis, &prescription.TotalAmount, &prescription.Status, &prescription.PrescriptionType,
+			&prescription.CompletedAt, &prescription.ReviewedAt, &prescription.ReviewNote, &prescription.CreatedAt,
+			&prescription.UpdatedAt, &patientName, &doctorName, &prescription.ReviewerName)
+		if err != nil {
+			continue
+		}
+		prescription.Patient = &models.Patient{ID: prescription.PatientID, Name: patientName.String}
+		prescription.Doctor = &models.User{ID: prescription.DoctorID, Name: doctorName.String}
+		prescriptions = append(prescriptions, prescription)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"prescriptions": prescriptions,
+		"total":         len(prescriptions),
+		"stage":         stage,
+	})
+}
+
+// checkNotPrescriber 开方医生不得审核或发放本人开具的处方，不通过时写入响应并返回 false
+func checkNotPrescriber(c *gin.Context, tx *sql.Tx, id int, msg, fallback string) bool {
+	var doctorID int
+	err := tx.QueryRow("SELECT doctor_id FROM prescriptions WHERE id = ?", id).Scan(&doctorID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "处方不存在"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+		return false
+	}
+	if doctorID == currentUserID(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
+		return false
+	}
+	return true
+}
+
+// reviewPrescription 药师审核处方，记录审核人、时间及审核意见
+func reviewPrescription(c *gin.Context, to string, note string) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的处方ID"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "审核处方失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if !checkNotPrescriber(c, tx, id, "不能审核本人开具的处方", "审核处方失败") {
+		return
+	}
+
+	userID := currentUserID(c)
+	if err := transitionPrescription(tx, id, to, userID); err != nil {
+		respondPrescriptionError(c, err, "审核处方失败")
+		return
+	}
+	_, err = tx.Exec("UPDATE prescriptions SET reviewed_by = ?, reviewed_at = ?, review_note = ? WHERE id = ?",
+		userID, time.Now(), strings.TrimSpace(note), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "审核处方失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "审核处方失败"})
+		return
+	}
+
+	message := "处方审核通过"
+	if to == models.PrescriptionRejected {
+		message = "处方已驳回"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// Approve 审核通过，可附审核意见
+func (pc *PharmacyController) Approve(c *gin.Context) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	c.ShouldBindJSON(&req)
+	reviewPrescription(c, models.PrescriptionApproved, req.Note)
+}
+
+// Reject 审核驳回，原因返回给开方医生
+func (pc *PharmacyController) Reject(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请填写驳回原因"})
+		return
+	}
+	reviewPrescription(c, models.PrescriptionRejected, req.Reason)
+}
+
+// Dispense 对审核通过的处方发药，记录发药人和时间
+func (pc *PharmacyController) Dispense(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的处方ID"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发药失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if !checkNotPrescriber(c, tx, id, "不能为本人开具的处方发药", "发药失败") {
+		return
+	}
+
+	userID := currentUserID(c)
+	if err := transitionPrescription(tx, id, models.PrescriptionDispensed, userID); err != nil {
+		respondPrescriptionError(c, err, "发药失败")
+		return
+	}
+	now := time.Now()
+	_, err = tx.Exec("UPDATE prescriptions SET dispensed_by = ?, dispensed_at = ? WHERE id = ?", userID, now, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发药失败"})
+		return
+	}
+
+	// 麻醉药品、精神药品发药后专册登记
+	if err := recordControlledDrugs(tx, id, userID, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登记麻精药品失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发药失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "发药完成"})
+}
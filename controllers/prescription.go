@@ -1,13 +1,14 @@
 package controllers
 
 import (
+	"database/sql"
 	"lighthospital/database"
 	"lighthospital/models"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
@@ -20,8 +21,11 @@ func (pc *PrescriptionController) Create(c *gin.Context) {
 		return
 	}
 
-	session := sessions.Default(c)
-	doctorID := session.Get("user_id")
+	prescription.DoctorID = currentUserID(c)
+	if prescription.PatientWeight < 0 || prescription.PatientWeight > 300 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "患者体重无效"})
+		return
+	}
 
 	// 开始事务
 	tx, err := database.DB.Begin()
@@ -31,35 +35,44 @@ func (pc *PrescriptionController) Create(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	now := time.Now()
+	// 按药品库价格计算金额，不信任客户端提交的金额
+	if err := pricePrescription(tx, &prescription); err != nil {
+		respondPrescriptionError(c, err, "创建处方失败")
+		return
+	}
 
-	// 创建处方
-	result, err := tx.Exec(`
-		INSERT INTO prescriptions (patient_id, doctor_id, diagnosis, doctor_advice, total_amount, status, notes, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		prescription.PatientID, doctorID, prescription.Diagnosis, prescription.DoctorAdvice, prescription.TotalAmount,
-		"draft", prescription.Notes, now, now)
+	if err := resolvePrescriptionDiagnoses(tx, &prescription); err != nil {
+		respondPrescriptionError(c, err, "创建处方失败")
+		return
+	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建处方失败"})
+	if err := attachEncounter(tx, &prescription); err != nil {
+		respondPrescriptionError(c, err, "创建处方失败")
 		return
 	}
 
-	prescriptionID, _ := result.LastInsertId()
+	// 核对患者过敏史，中度及以上冲突需填写坚持用药原因
+	warnings, err := checkPrescription(tx, &prescription)
+	if err != nil {
+		respondPrescriptionError(c, err, "创建处方失败")
+		return
+	}
+	// 坚持用药原因的填写人以服务端为准，不采用客户端提交的值
+	prescription.AllergyOverrideBy = 0
+	if prescription.AllergyOverrideReason != "" {
+		prescription.AllergyOverrideBy = prescription.DoctorID
+	}
 
-	// 创建处方明细
-	for _, item := range prescription.Items {
-		_, err := tx.Exec(`
-			INSERT INTO prescription_items (prescription_id, medicine_id, medicine_name, specification, 
-			dosage, usage, frequency, days, quantity, unit_price, total_price)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			prescriptionID, item.MedicineID, item.MedicineName, item.Specification,
-			item.Dosage, item.Usage, item.Frequency, item.Days, item.Quantity, item.UnitPrice, item.TotalPrice)
+	doseSuggestions, err := suggestDoses(tx, &prescription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "计算建议剂量失败"})
+		return
+	}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建处方明细失败"})
-			return
-		}
+	prescriptionID, err := insertDraftPrescription(tx, &prescription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建处方失败"})
+		return
 	}
 
 	// 提交事务
@@ -69,11 +82,63 @@ func (pc *PrescriptionController) Create(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "处方创建成功",
-		"id":      prescriptionID,
+		"message":          "处方创建成功",
+		"id":               prescriptionID,
+		"total_amount":     prescription.TotalAmount,
+		"warnings":         warnings,
+		"dose_suggestions": doseSuggestions,
 	})
 }
 
+// insertDraftPrescription 在事务内保存草稿处方及其明细、编码诊断，返回处方ID
+func insertDraftPrescription(tx *sql.Tx, prescription *models.Prescription) (int64, error) {
+	now := time.Now()
+
+	// 创建处方
+	result, err := tx.Exec(`
+		INSERT INTO prescriptions (patient_id, doctor_id, diagnosis, doctor_advice, total_amount, status, notes,
+		allergy_override_reason, allergy_override_by, patient_weight, prescription_type, herbal_doses, decoction_method,
+		prescription_form, encounter_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		prescription.PatientID, prescription.DoctorID, prescription.Diagnosis, prescription.DoctorAdvice, prescription.TotalAmount,
+		models.PrescriptionDraft, prescription.Notes, prescription.AllergyOverrideReason, prescription.AllergyOverrideBy,
+		prescription.PatientWeight, prescription.PrescriptionType, prescription.HerbalDoses, prescription.DecoctionMethod,
+		prescription.PrescriptionForm, prescription.EncounterID, now, now)
+	if err != nil {
+		return 0, err
+	}
+
+	prescriptionID, _ := result.LastInsertId()
+
+	// 创建处方明细
+	if err := insertPrescriptionItems(tx, prescriptionID, prescription.Items); err != nil {
+		return 0, err
+	}
+
+	if err := saveRecordDiagnoses(tx, models.DiagnosisRecordPrescription, prescriptionID, prescription.Diagnoses); err != nil {
+		return 0, err
+	}
+
+	return prescriptionID, nil
+}
+
+// insertPrescriptionItems 保存处方明细
+func insertPrescriptionItems(tx *sql.Tx, prescriptionID int64, items []models.PrescriptionItem) error {
+	for _, item := range items {
+		_, err := tx.Exec(`
+			INSERT INTO prescription_items (prescription_id, medicine_id, medicine_name, specification, 
+			dosage, usage, frequency, days, quantity, unit_price, total_price, herb_grams, special_processing)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			prescriptionID, item.MedicineID, item.MedicineName, item.Specification,
+			item.Dosage, item.Usage, item.Frequency, item.Days, item.Quantity, item.UnitPrice, item.TotalPrice,
+			item.HerbGrams, item.SpecialProcessing)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (pc *PrescriptionController) Get(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -85,13 +150,26 @@ func (pc *PrescriptionController) Get(c *gin.Context) {
 	var prescription models.Prescription
 	var doctorName string
 	err = database.DB.QueryRow(`
-		SELECT p.id, p.patient_id, p.doctor_id, p.diagnosis, p.doctor_advice, p.total_amount, p.status, p.notes, p.created_at, p.updated_at,
+		SELECT p.id, p.patient_id, p.doctor_id, p.diagnosis, p.doctor_advice, p.total_amount, p.status, p.notes, p.stock_deducted, p.amended_from_id,
+		       p.allergy_override_reason, p.allergy_override_by, p.patient_weight,
+		       p.prescription_type, p.herbal_doses, p.decoction_method, p.prescription_no, p.prescription_form,
+		       p.encounter_id, p.void_reason, p.voided_by, p.voided_at, p.refund_amount,
+		       p.completed_at, p.reviewed_by, p.reviewed_at, p.review_note, p.dispensed_by, p.dispensed_at,
+		       COALESCE(r.name, ''), COALESCE(d.name, ''), p.created_at, p.updated_at,
 		       u.name as doctor_name
 		FROM prescriptions p
 		LEFT JOIN users u ON p.doctor_id = u.id
+		LEFT JOIN users r ON p.reviewed_by = r.id
+		LEFT JOIN users d ON p.dispensed_by = d.id
 		WHERE p.id = ?`, id).Scan(
 		&prescription.ID, &prescription.PatientID, &prescription.DoctorID, &prescription.Diagnosis, &prescription.DoctorAdvice,
-		&prescription.TotalAmount, &prescription.Status, &prescription.Notes, &prescription.CreatedAt, &prescription.UpdatedAt,
+		&prescription.TotalAmount, &prescription.Status, &prescription.Notes, &prescription.StockDeducted, &prescription.AmendedFromID,
+		&prescription.AllergyOverrideReason, &prescription.AllergyOverrideBy, &prescription.PatientWeight,
+		&prescription.PrescriptionType, &prescription.HerbalDoses, &prescription.DecoctionMethod, &prescription.PrescriptionNo,
+		&prescription.PrescriptionForm, &prescription.EncounterID, &prescription.VoidReason, &prescription.VoidedBy, &prescription.VoidedAt, &prescription.RefundAmount,
+		&prescription.CompletedAt, &prescription.ReviewedBy, &prescription.ReviewedAt, &prescription.ReviewNote,
+		&prescription.DispensedBy, &prescription.DispensedAt, &prescription.ReviewerName, &prescription.DispenserName,
+		&prescription.CreatedAt, &prescription.UpdatedAt,
 		&doctorName)
 
 	if err != nil {
@@ -113,12 +191,7 @@ func (pc *PrescriptionController) Get(c *gin.Context) {
 	}
 
 	// 查询患者信息
-	var patient models.Patient
-	err = database.DB.QueryRow(`
-		SELECT id, name, gender, age, phone, address, id_card, medical_history, created_at, updated_at
-		FROM patients WHERE id = ?`, prescription.PatientID).Scan(
-		&patient.ID, &patient.Name, &patient.Gender, &patient.Age, &patient.Phone,
-		&patient.Address, &patient.IDCard, &patient.MedicalHistory, &patient.CreatedAt, &patient.UpdatedAt)
+	patient, err := scanPatient(database.DB.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id = ?", prescription.PatientID))
 
 	if err != nil {
 		// 如果患者不存在，创建一个空的患者信息
@@ -127,11 +200,13 @@ func (pc *PrescriptionController) Get(c *gin.Context) {
 			Name: "未知患者",
 		}
 	}
+	maskPatient(c, &patient)
 	prescription.Patient = &patient
 
 	// 查询处方明细
 	rows, err := database.DB.Query(`
-		SELECT id, prescription_id, medicine_id, medicine_name, specification, dosage, usage, frequency, days, quantity, unit_price, total_price
+		SELECT id, prescription_id, medicine_id, medicine_name, specification, dosage, usage, frequency, days, quantity, unit_price, total_price,
+		       herb_grams, special_processing
 		FROM prescription_items WHERE prescription_id = ? ORDER BY id`, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方明细失败"})
@@ -143,14 +218,37 @@ func (pc *PrescriptionController) Get(c *gin.Context) {
 		var item models.PrescriptionItem
 		err := rows.Scan(
 			&item.ID, &item.PrescriptionID, &item.MedicineID, &item.MedicineName, &item.Specification,
-			&item.Dosage, &item.Usage, &item.Frequency, &item.Days, &item.Quantity, &item.UnitPrice, &item.TotalPrice)
+			&item.Dosage, &item.Usage, &item.Frequency, &item.Days, &item.Quantity, &item.UnitPrice, &item.TotalPrice,
+			&item.HerbGrams, &item.SpecialProcessing)
 		if err != nil {
 			continue
 		}
 		prescription.Items = append(prescription.Items, item)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"prescription": prescription})
+	prescription.Diagnoses, err = loadRecordDiagnoses(database.DB, models.DiagnosisRecordPrescription, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方诊断失败"})
+		return
+	}
+
+	// 按当前过敏史和相互作用规则重新审核，打印前提示医生
+	warnings := []models.PrescriptionWarning{}
+	doseSuggestions := []models.DoseSuggestion{}
+	if tx, err := database.DB.Begin(); err == nil {
+		// 重新审核会按当前规则推算处方笺类别，返回时保留已保存的类别
+		form := prescription.PrescriptionForm
+		if checkWarnings, err := collectPrescriptionWarnings(tx, &prescription); err == nil {
+			warnings = checkWarnings
+		}
+		prescription.PrescriptionForm = form
+		if suggestions, err := suggestDoses(tx, &prescription); err == nil {
+			doseSuggestions = suggestions
+		}
+		tx.Rollback()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"prescription": prescription, "warnings": warnings, "dose_suggestions": doseSuggestions})
 }
 
 func (pc *PrescriptionController) Update(c *gin.Context) {
@@ -165,6 +263,10 @@ func (pc *PrescriptionController) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
 		return
 	}
+	if prescription.PatientWeight < 0 || prescription.PatientWeight > 300 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "患者体重无效"})
+		return
+	}
 
 	// 开始事务
 	tx, err := database.DB.Begin()
@@ -174,10 +276,75 @@ func (pc *PrescriptionController) Update(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	// 只有草稿可以直接修改，已完成的处方需通过修订更正
+	if err := requirePrescriptionDraft(tx, id, "已完成的处方不能直接修改，请创建修订处方"); err != nil {
+		respondPrescriptionError(c, err, "更新处方失败")
+		return
+	}
+
+	// 按药品库价格计算金额，不信任客户端提交的金额
+	if err := pricePrescription(tx, &prescription); err != nil {
+		respondPrescriptionError(c, err, "更新处方失败")
+		return
+	}
+
+	// 核对患者过敏史，中度及以上冲突需填写坚持用药原因
+	var patientID, doctorID, encounterID int
+	err = tx.QueryRow("SELECT patient_id, doctor_id, encounter_id FROM prescriptions WHERE id = ?", id).Scan(&patientID, &doctorID, &encounterID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新处方失败"})
+		return
+	}
+	prescription.PatientID = patientID
+	prescription.DoctorID = doctorID
+
+	// 未指定病历时保留原有关联
+	if prescription.EncounterID == 0 {
+		prescription.EncounterID = encounterID
+	}
+
+	// 未提交编码诊断时保留原有诊断，提交空列表表示清除
+	if prescription.Diagnoses == nil {
+		prescription.Diagnoses, err = loadRecordDiagnoses(tx, models.DiagnosisRecordPrescription, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新处方失败"})
+			return
+		}
+	} else if err := resolvePrescriptionDiagnoses(tx, &prescription); err != nil {
+		respondPrescriptionError(c, err, "更新处方失败")
+		return
+	}
+	if err := attachEncounter(tx, &prescription); err != nil {
+		respondPrescriptionError(c, err, "更新处方失败")
+		return
+	}
+	warnings, err := checkPrescription(tx, &prescription)
+	if err != nil {
+		respondPrescriptionError(c, err, "更新处方失败")
+		return
+	}
+	// 坚持用药原因的填写人以服务端为准，不采用客户端提交的值
+	prescription.AllergyOverrideBy = 0
+	if prescription.AllergyOverrideReason != "" {
+		prescription.AllergyOverrideBy = currentUserID(c)
+	}
+
+	doseSuggestions, err := suggestDoses(tx, &prescription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "计算建议剂量失败"})
+		return
+	}
+
 	// 更新处方基本信息
 	_, err = tx.Exec(`
-		UPDATE prescriptions SET diagnosis = ?, doctor_advice = ?, total_amount = ?, notes = ?, updated_at = ? WHERE id = ?`,
-		prescription.Diagnosis, prescription.DoctorAdvice, prescription.TotalAmount, prescription.Notes, time.Now(), id)
+		UPDATE prescriptions SET diagnosis = ?, doctor_advice = ?, total_amount = ?, notes = ?,
+		allergy_override_reason = ?, allergy_override_by = ?, patient_weight = ?,
+		prescription_type = ?, herbal_doses = ?, decoction_method = ?, prescription_form = ?, encounter_id = ?,
+		updated_at = ? WHERE id = ?`,
+		prescription.Diagnosis, prescription.DoctorAdvice, prescription.TotalAmount, prescription.Notes,
+		prescription.AllergyOverrideReason, prescription.AllergyOverrideBy, prescription.PatientWeight,
+		prescription.PrescriptionType, prescription.HerbalDoses, prescription.DecoctionMethod, prescription.PrescriptionForm,
+		prescription.EncounterID, time.Now(), id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新处方失败"})
@@ -192,18 +359,14 @@ func (pc *PrescriptionController) Update(c *gin.Context) {
 	}
 
 	// 重新创建明细
-	for _, item := range prescription.Items {
-		_, err := tx.Exec(`
-			INSERT INTO prescription_items (prescription_id, medicine_id, medicine_name, specification, 
-			dosage, usage, frequency, days, quantity, unit_price, total_price)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			id, item.MedicineID, item.MedicineName, item.Specification,
-			item.Dosage, item.Usage, item.Frequency, item.Days, item.Quantity, item.UnitPrice, item.TotalPrice)
+	if err := insertPrescriptionItems(tx, int64(id), prescription.Items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新处方明细失败"})
+		return
+	}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新处方明细失败"})
-			return
-		}
+	if err := saveRecordDiagnoses(tx, models.DiagnosisRecordPrescription, int64(id), prescription.Diagnoses); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新处方诊断失败"})
+		return
 	}
 
 	// 提交事务
@@ -212,7 +375,12 @@ func (pc *PrescriptionController) Update(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "处方更新成功"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "处方更新成功",
+		"total_amount":     prescription.TotalAmount,
+		"warnings":         warnings,
+		"dose_suggestions": doseSuggestions,
+	})
 }
 
 func (pc *PrescriptionController) UpdateStatus(c *gin.Context) {
@@ -224,15 +392,38 @@ func (pc *PrescriptionController) UpdateStatus(c *gin.Context) {
 
 	var req struct {
 		Status string `json:"status" binding:"required"`
+		Reason string `json:"reason"` // 作废原因，作废时必填
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
 		return
 	}
 
-	_, err = database.DB.Exec("UPDATE prescriptions SET status = ?, updated_at = ? WHERE id = ?",
-		req.Status, time.Now(), id)
+	// 开始事务，状态变更与库存变动必须同时成功
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新处方状态失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if models.PharmacyStatuses[req.Status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "审核和发药须由药师在药房队列中操作"})
+		return
+	}
+
+	if req.Status == models.PrescriptionVoided {
+		err = voidPrescription(tx, id, currentUserID(c), req.Reason)
+	} else {
+		err = transitionPrescription(tx, id, req.Status, currentUserID(c))
+	}
 	if err != nil {
+		respondPrescriptionError(c, err, "更新处方状态失败")
+		return
+	}
+
+	// 提交事务
+	if err := tx.Commit(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新处方状态失败"})
 		return
 	}
@@ -247,6 +438,15 @@ func (pc *PrescriptionController) Delete(c *gin.Context) {
 		return
 	}
 
+	// 作废原因，已开具的处方只能作废，保留记录
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindJSON(&req)
+	if req.Reason == "" {
+		req.Reason = c.Query("reason")
+	}
+
 	// 开始事务
 	tx, err := database.DB.Begin()
 	if err != nil {
@@ -255,6 +455,28 @@ func (pc *PrescriptionController) Delete(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	var status string
+	if err := tx.QueryRow("SELECT status FROM prescriptions WHERE id = ?", id).Scan(&status); err != nil {
+		respondPrescriptionError(c, err, "删除处方失败")
+		return
+	}
+	if status != models.PrescriptionDraft {
+		if strings.TrimSpace(req.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "已开具的处方不能删除，请填写作废原因进行作废"})
+			return
+		}
+		if err := voidPrescription(tx, id, currentUserID(c), req.Reason); err != nil {
+			respondPrescriptionError(c, err, "作废处方失败")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "作废处方失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "处方已作废", "voided": true})
+		return
+	}
+
 	// 删除处方明细
 	_, err = tx.Exec("DELETE FROM prescription_items WHERE prescription_id = ?", id)
 	if err != nil {
@@ -291,16 +513,29 @@ func (pc *PrescriptionController) List(c *gin.Context) {
 
 	whereClause := "WHERE 1=1"
 	if search != "" {
-		whereClause += " AND pt.name LIKE ?"
-		args = append(args, "%"+search+"%")
+		whereClause += " AND (pt.name LIKE ? OR p.prescription_no LIKE ?)"
+		args = append(args, "%"+search+"%", "%"+search+"%")
 	}
 	if status != "" {
 		whereClause += " AND p.status = ?"
 		args = append(args, status)
 	}
+	if patientID, _ := strconv.Atoi(c.Query("patient_id")); patientID > 0 {
+		whereClause += " AND p.patient_id = ?"
+		args = append(args, patientID)
+	}
+	if encounterID, _ := strconv.Atoi(c.Query("encounter_id")); encounterID > 0 {
+		whereClause += " AND p.encounter_id = ?"
+		args = append(args, encounterID)
+	}
+	if diagnosisCode := strings.TrimSpace(c.Query("diagnosis_code")); diagnosisCode != "" {
+		whereClause += ` AND EXISTS (SELECT 1 FROM record_diagnoses rd
+			WHERE rd.record_type = ? AND rd.record_id = p.id AND rd.code = ? COLLATE NOCASE)`
+		args = append(args, models.DiagnosisRecordPrescription, diagnosisCode)
+	}
 
 	query = `
-		SELECT p.id, p.patient_id, p.doctor_id, p.diagnosis, p.total_amount, p.status, p.notes, p.created_at, p.updated_at,
+		SELECT p.id, p.prescription_no, p.patient_id, p.doctor_id, p.diagnosis, p.total_amount, p.status, p.notes, p.created_at, p.updated_at,
 		       pt.name as patient_name, u.name as doctor_name
 		FROM prescriptions p
 		LEFT JOIN patients pt ON p.patient_id = pt.id
@@ -320,7 +555,7 @@ func (pc *PrescriptionController) List(c *gin.Context) {
 		var prescription models.Prescription
 		var patientName, doctorName string
 		err := rows.Scan(
-			&prescription.ID, &prescription.PatientID, &prescription.DoctorID, &prescription.Diagnosis,
+			&prescription.ID, &prescription.PrescriptionNo, &prescription.PatientID, &prescription.DoctorID, &prescription.Diagnosis,
 			&prescription.TotalAmount, &prescription.Status, &prescription.Notes, &prescription.CreatedAt, &prescription.UpdatedAt,
 			&patientName, &doctorName)
 		if err != nil {
@@ -333,7 +568,7 @@ func (pc *PrescriptionController) List(c *gin.Context) {
 	}
 
 	// 获取总数
-	countQuery := "SELECT COUNT(*) FROM prescriptions p " + whereClause
+	countQuery := "SELECT COUNT(*) FROM prescriptions p LEFT JOIN patients pt ON p.patient_id = pt.id " + whereClause
 	countArgs := args[:len(args)-2] // 去掉 LIMIT 和 OFFSET 参数
 	var total int
 	database.DB.QueryRow(countQuery, countArgs...).Scan(&total)
@@ -354,7 +589,7 @@ func (pc *PrescriptionController) Search(c *gin.Context) {
 	}
 
 	query := `
-		SELECT p.id, p.patient_id, p.doctor_id, p.diagnosis, p.total_amount, p.status, p.notes, p.created_at, p.updated_at,
+		SELECT p.id, p.prescription_no, p.patient_id, p.doctor_id, p.diagnosis, p.total_amount, p.status, p.notes, p.created_at, p.updated_at,
 		       pt.name as patient_name, u.name as doctor_name
 		FROM prescriptions p
 		LEFT JOIN patients pt ON p.patient_id = pt.id
@@ -362,6 +597,14 @@ func (pc *PrescriptionController) Search(c *gin.Context) {
 		WHERE 1=1`
 	var args []interface{}
 
+	if search.PrescriptionNo != "" {
+		query += " AND p.prescription_no LIKE ?"
+		args = append(args, "%"+search.PrescriptionNo+"%")
+	}
+	if search.PatientID > 0 {
+		query += " AND p.patient_id = ?"
+		args = append(args, search.PatientID)
+	}
 	if search.PatientName != "" {
 		query += " AND pt.name LIKE ?"
 		args = append(args, "%"+search.PatientName+"%")
@@ -397,7 +640,7 @@ func (pc *PrescriptionController) Search(c *gin.Context) {
 		var prescription models.Prescription
 		var patientName, doctorName string
 		err := rows.Scan(
-			&prescription.ID, &prescription.PatientID, &prescription.DoctorID, &prescription.Diagnosis,
+			&prescription.ID, &prescription.PrescriptionNo, &prescription.PatientID, &prescription.DoctorID, &prescription.Diagnosis,
 			&prescription.TotalAmount, &prescription.Status, &prescription.Notes, &prescription.CreatedAt, &prescription.UpdatedAt,
 			&patientName, &doctorName)
 		if err != nil {
@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/models"
+	"strings"
+)
+
+// checkMedicine 处方审核所需的药品信息
+type checkMedicine struct {
+	ID              int
+	Name            string
+	Category        string
+	Ingredients     []string
+	DrugClass       string
+	AntibioticLevel string
+	Item            models.PrescriptionItem // 对应的处方明细
+}
+
+// loadCheckMedicine 查询处方审核所需的药品信息
+func loadCheckMedicine(tx *sql.Tx, medicineID int) (*checkMedicine, error) {
+	var medicine checkMedicine
+	var category sql.NullString
+	var ingredients string
+	err := tx.QueryRow("SELECT id, name, category, ingredients, drug_class, antibiotic_level FROM medicines WHERE id = ?", medicineID).Scan(
+		&medicine.ID, &medicine.Name, &category, &ingredients, &medicine.DrugClass, &medicine.AntibioticLevel)
+	if err != nil {
+		return nil, err
+	}
+	medicine.Category = category.String
+	medicine.Ingredients = splitIngredients(ingredients)
+	return &medicine, nil
+}
+
+// splitIngredients 拆分以逗号、顿号分隔的成分列表
+func splitIngredients(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == '，' || r == '、' || r == ';' || r == '；'
+	})
+	var ingredients []string
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			ingredients = append(ingredients, field)
+		}
+	}
+	return ingredients
+}
+
+// matchAllergy 判断药品是否命中过敏记录
+func matchAllergy(allergy models.PatientAllergy, medicine *checkMedicine) bool {
+	switch allergy.AllergenType {
+	case models.AllergenMedicine:
+		return allergy.MedicineID > 0 && allergy.MedicineID == medicine.ID
+	case models.AllergenCategory:
+		return medicine.Category != "" && strings.EqualFold(medicine.Category, allergy.Allergen)
+	case models.AllergenIngredient:
+		if strings.Contains(medicine.Name, allergy.Allergen) {
+			return true
+		}
+		for _, ingredient := range medicine.Ingredients {
+			if strings.Contains(ingredient, allergy.Allergen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadActiveAllergies 查询患者当前有效的过敏记录
+func loadActiveAllergies(tx *sql.Tx, patientID int) ([]models.PatientAllergy, error) {
+	rows, err := tx.Query(`
+		SELECT id, allergen_type, medicine_id, allergen, COALESCE(reaction, ''), severity
+		FROM patient_allergies WHERE patient_id = ? AND deleted_at IS NULL`, patientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var allergies []models.PatientAllergy
+	for rows.Next() {
+		var allergy models.PatientAllergy
+		err := rows.Scan(&allergy.ID, &allergy.AllergenType, &allergy.MedicineID, &allergy.Allergen,
+			&allergy.Reaction, &allergy.Severity)
+		if err != nil {
+			return nil, err
+		}
+		allergies = append(allergies, allergy)
+	}
+	return allergies, rows.Err()
+}
+
+// loadCheckMedicines 查询处方明细对应的药品，药品库中已不存在的药品不参与审核
+func loadCheckMedicines(tx *sql.Tx, items []models.PrescriptionItem) ([]*checkMedicine, error) {
+	var medicines []*checkMedicine
+	for _, item := range items {
+		medicine, err := loadCheckMedicine(tx, item.MedicineID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		medicine.Item = item
+		medicines = append(medicines, medicine)
+	}
+	return medicines, nil
+}
+
+// checkAllergies 核对处方药品与患者过敏史
+func checkAllergies(tx *sql.Tx, patientID int, medicines []*checkMedicine) ([]models.PrescriptionWarning, error) {
+	allergies, err := loadActiveAllergies(tx, patientID)
+	if err != nil || len(allergies) == 0 {
+		return nil, err
+	}
+
+	var warnings []models.PrescriptionWarning
+	for _, medicine := range medicines {
+		for _, allergy := range allergies {
+			if !matchAllergy(allergy, medicine) {
+				continue
+			}
+			message := fmt.Sprintf("患者对【%s】过敏，药品【%s】存在过敏风险", allergy.Allergen, medicine.Name)
+			if allergy.Reaction != "" {
+				message += "（既往反应：" + allergy.Reaction + "）"
+			}
+			warnings = append(warnings, models.PrescriptionWarning{
+				Type:         "allergy",
+				Severity:     allergy.Severity,
+				MedicineID:   medicine.ID,
+				MedicineName: medicine.Name,
+				Message:      message,
+			})
+		}
+	}
+	return warnings, nil
+}
+
+// matchInteractionSide 判断药品是否为相互作用规则的一方
+func matchInteractionSide(kind, name string, medicine *checkMedicine) bool {
+	switch kind {
+	case models.InteractionMedicine:
+		return medicine.Name == name
+	case models.InteractionCategory:
+		return medicine.Category != "" && strings.EqualFold(medicine.Category, name)
+	}
+	return false
+}
+
+// checkInteractions 按相互作用规则两两核对处方药品
+func checkInteractions(tx *sql.Tx, medicines []*checkMedicine) ([]models.PrescriptionWarning, error) {
+	if len(medicines) < 2 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query("SELECT type_a, name_a, type_b, name_b, severity, message FROM drug_interactions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.DrugInteraction
+	for rows.Next() {
+		var rule models.DrugInteraction
+		if err := rows.Scan(&rule.TypeA, &rule.NameA, &rule.TypeB, &rule.NameB, &rule.Severity, &rule.Message); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var warnings []models.PrescriptionWarning
+	for i := 0; i < len(medicines); i++ {
+		for j := i + 1; j < len(medicines); j++ {
+			a, b := medicines[i], medicines[j]
+			if a.ID == b.ID {
+				continue
+			}
+			for _, rule := range rules {
+				matched := (matchInteractionSide(rule.TypeA, rule.NameA, a) && matchInteractionSide(rule.TypeB, rule.NameB, b)) ||
+					(matchInteractionSide(rule.TypeA, rule.NameA, b) && matchInteractionSide(rule.TypeB, rule.NameB, a))
+				if !matched {
+					continue
+				}
+				message := fmt.Sprintf("药品【%s】与【%s】存在相互作用", a.Name, b.Name)
+				if rule.Message != "" {
+					message += "：" + rule.Message
+				}
+				warnings = append(warnings, models.PrescriptionWarning{
+					Type:                "interaction",
+					Severity:            rule.Severity,
+					MedicineID:          a.ID,
+					MedicineName:        a.Name,
+					RelatedMedicineID:   b.ID,
+					RelatedMedicineName: b.Name,
+					Message:             message,
+				})
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// checkDuplicateCategories 提示同一治疗分类的重复用药，每个药品与该分类中首个药品配对提示；
+// 中药饮片处方的药味通常同属一类，只提示同一味药重复开具
+func checkDuplicateCategories(medicines []*checkMedicine, herbal bool) []models.PrescriptionWarning {
+	var warnings []models.PrescriptionWarning
+	first := make(map[string]*checkMedicine)
+	for _, medicine := range medicines {
+		key := strings.ToLower(medicine.Category)
+		if herbal {
+			key = fmt.Sprintf("medicine:%d", medicine.ID)
+		} else if key == "" {
+			continue
+		}
+		existing, ok := first[key]
+		if !ok {
+			first[key] = medicine
+			continue
+		}
+		var message string
+		if existing.ID == medicine.ID {
+			message = fmt.Sprintf("药品【%s】在处方中重复开具", medicine.Name)
+		} else {
+			message = fmt.Sprintf("药品【%s】与【%s】同属【%s】类，请确认是否重复用药", medicine.Name, existing.Name, medicine.Category)
+		}
+		warnings = append(warnings, models.PrescriptionWarning{
+			Type:                "duplicate_category",
+			MedicineID:          medicine.ID,
+			MedicineName:        medicine.Name,
+			RelatedMedicineID:   existing.ID,
+			RelatedMedicineName: existing.Name,
+			Message:             message,
+		})
+	}
+	return warnings
+}
+
+// collectPrescriptionWarnings 汇总处方的用药安全提示：管理类别开方规则、过敏、药物相互作用、同类重复用药、剂量超限
+func collectPrescriptionWarnings(tx *sql.Tx, prescription *models.Prescription) ([]models.PrescriptionWarning, error) {
+	warnings := []models.PrescriptionWarning{}
+
+	medicines, err := loadCheckMedicines(tx, prescription.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	drugClassWarnings, err := checkDrugClassRules(tx, prescription, medicines)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, drugClassWarnings...)
+
+	allergyWarnings, err := checkAllergies(tx, prescription.PatientID, medicines)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, allergyWarnings...)
+
+	interactionWarnings, err := checkInteractions(tx, medicines)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, interactionWarnings...)
+
+	herbal := prescription.PrescriptionType == models.PrescriptionHerbal
+	warnings = append(warnings, checkDuplicateCategories(medicines, herbal)...)
+
+	dosageWarnings, err := checkDosage(tx, prescription, medicines)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, dosageWarnings...)
+	return warnings, nil
+}
+
+// checkPrescription 对处方进行用药安全审核，返回全部提示；违反管理类别开方规则，
+// 或存在中度及以上过敏冲突且未填写坚持用药原因时返回 prescriptionError，其余提示不阻止保存
+func checkPrescription(tx *sql.Tx, prescription *models.Prescription) ([]models.PrescriptionWarning, error) {
+	warnings, err := collectPrescriptionWarnings(tx, prescription)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, warning := range warnings {
+		if warning.Type == "drug_class" {
+			return warnings, &prescriptionError{msg: warning.Message, warnings: warnings}
+		}
+	}
+
+	hasAllergy, blocking := false, false
+	for _, warning := range warnings {
+		if warning.Type != "allergy" {
+			continue
+		}
+		hasAllergy = true
+		if warning.Severity != models.SeverityMild {
+			blocking = true
+		}
+	}
+	if blocking && strings.TrimSpace(prescription.AllergyOverrideReason) == "" {
+		return warnings, &prescriptionError{
+			msg:      "处方药品与患者过敏史冲突，如确需使用请填写坚持用药原因",
+			warnings: warnings,
+		}
+	}
+	if !hasAllergy {
+		prescription.AllergyOverrideReason = ""
+	}
+	return warnings, nil
+}
+
+// checkStoredPrescription 按已保存的处方内容重新审核，用于处方完成前的最终核对
+func checkStoredPrescription(tx *sql.Tx, id int) error {
+	var prescription models.Prescription
+	err := tx.QueryRow(`
+		SELECT id, patient_id, doctor_id, allergy_override_reason, patient_weight, prescription_type, herbal_doses
+		FROM prescriptions WHERE id = ?`, id).Scan(
+		&prescription.ID, &prescription.PatientID, &prescription.DoctorID, &prescription.AllergyOverrideReason,
+		&prescription.PatientWeight, &prescription.PrescriptionType, &prescription.HerbalDoses)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`
+		SELECT medicine_id, medicine_name, dosage, usage, frequency, days, quantity, herb_grams, special_processing
+		FROM prescription_items WHERE prescription_id = ? AND medicine_id > 0 ORDER BY id`, id)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var item models.PrescriptionItem
+		err := rows.Scan(&item.MedicineID, &item.MedicineName, &item.Dosage, &item.Usage, &item.Frequency, &item.Days, &item.Quantity,
+			&item.HerbGrams, &item.SpecialProcessing)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		prescription.Items = append(prescription.Items, item)
+	}
+	rows.Close()
+
+	if _, err := checkPrescription(tx, &prescription); err != nil {
+		return err
+	}
+
+	// 药品管理类别可能在开方后调整，完成时按最新类别确定处方笺类别
+	_, err = tx.Exec("UPDATE prescriptions SET prescription_form = ? WHERE id = ?", prescription.PrescriptionForm, id)
+	return err
+}
@@ -0,0 +1,415 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/database"
+	"lighthospital/models"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// doseUnitAliases 剂量单位的常见写法，统一为标准写法
+var doseUnitAliases = map[string]string{
+	"g": "g", "克": "g",
+	"mg": "mg", "毫克": "mg",
+	"μg": "μg", "µg": "μg", "ug": "μg", "mcg": "μg", "微克": "μg",
+	"l": "l", "升": "l",
+	"ml": "ml", "毫升": "ml",
+}
+
+// doseUnitFactors 可互相换算的剂量单位，质量折算为 mg，体积折算为 ml
+var doseUnitFactors = map[string]struct {
+	base   string
+	factor float64
+}{
+	"g":  {"mg", 1000},
+	"mg": {"mg", 1},
+	"μg": {"mg", 0.001},
+	"l":  {"ml", 1000},
+	"ml": {"ml", 1},
+}
+
+var (
+	dosePattern       = regexp.MustCompile(`([0-9]+(?:\.[0-9]+)?)\s*([^\s0-9/,，;；]+)`)
+	timesPerDayRegexp = regexp.MustCompile(`(?:每日|每天|一日|日)\s*([0-9]+|[一二两三四五六])\s*次`)
+	everyHoursRegexp  = regexp.MustCompile(`(?:每\s*([0-9]+)\s*小时|q\s*([0-9]+)\s*h)`)
+)
+
+var chineseNumbers = map[string]int{"一": 1, "二": 2, "两": 2, "三": 3, "四": 4, "五": 5, "六": 6}
+
+// normalizeDoseUnit 统一剂量单位写法，无法识别的单位（如片、粒）原样返回
+func normalizeDoseUnit(unit string) string {
+	unit = strings.TrimSpace(unit)
+	if normalized, ok := doseUnitAliases[strings.ToLower(unit)]; ok {
+		return normalized
+	}
+	return unit
+}
+
+// parseDose 从剂量文本（如 "250mg"、"0.5 g"、"2片"）中解析数值和单位
+func parseDose(text string) (float64, string, bool) {
+	match := dosePattern.FindStringSubmatch(text)
+	if match == nil {
+		return 0, "", false
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return value, normalizeDoseUnit(match[2]), true
+}
+
+// convertDose 将剂量换算为目标单位，单位不可换算时返回 false
+func convertDose(value float64, from, to string) (float64, bool) {
+	if from == to {
+		return value, true
+	}
+	fromFactor, ok1 := doseUnitFactors[from]
+	toFactor, ok2 := doseUnitFactors[to]
+	if !ok1 || !ok2 || fromFactor.base != toFactor.base {
+		return 0, false
+	}
+	return roundDose(value * fromFactor.factor / toFactor.factor), true
+}
+
+// parseTimesPerDay 从频次文本（如 "每日三次"、"tid"、"q8h"）中解析每日用药次数，无法识别时返回 0
+func parseTimesPerDay(frequency string) int {
+	text := strings.ToLower(strings.TrimSpace(frequency))
+	switch text {
+	case "qd", "qn", "hs":
+		return 1
+	case "bid":
+		return 2
+	case "tid":
+		return 3
+	case "qid":
+		return 4
+	}
+	if match := timesPerDayRegexp.FindStringSubmatch(text); match != nil {
+		if n, ok := chineseNumbers[match[1]]; ok {
+			return n
+		}
+		n, _ := strconv.Atoi(match[1])
+		return n
+	}
+	if match := everyHoursRegexp.FindStringSubmatch(text); match != nil {
+		hours, _ := strconv.Atoi(match[1] + match[2])
+		if hours > 0 && hours <= 24 {
+			return 24 / hours
+		}
+	}
+	if strings.Contains(text, "睡前") {
+		return 1
+	}
+	return 0
+}
+
+// rowQuerier *sql.DB 与 *sql.Tx 共有的单行查询方法
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// roundDose 剂量保留4位小数，避免单位换算产生的浮点误差
+func roundDose(dose float64) float64 {
+	return math.Round(dose*10000) / 10000
+}
+
+// loadDosingRule 查询药品的剂量规则，未设置时返回 nil
+func loadDosingRule(q rowQuerier, medicineID int) (*models.MedicineDosingRule, error) {
+	var rule models.MedicineDosingRule
+	err := q.QueryRow(`
+		SELECT medicine_id, dose_unit, max_single_dose, max_daily_dose, dose_per_kg, min_age_months, updated_at
+		FROM medicine_dosing_rules WHERE medicine_id = ?`, medicineID).Scan(
+		&rule.MedicineID, &rule.DoseUnit, &rule.MaxSingleDose, &rule.MaxDailyDose, &rule.DosePerKg, &rule.MinAgeMonths, &rule.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// saveDosingRule 保存药品剂量规则，各项均未设置时删除规则
+func saveDosingRule(tx *sql.Tx, medicineID int, rule *models.MedicineDosingRule) error {
+	rule.DoseUnit = normalizeDoseUnit(rule.DoseUnit)
+	if rule.MaxSingleDose <= 0 && rule.MaxDailyDose <= 0 && rule.DosePerKg <= 0 && rule.MinAgeMonths <= 0 {
+		_, err := tx.Exec("DELETE FROM medicine_dosing_rules WHERE medicine_id = ?", medicineID)
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO medicine_dosing_rules (medicine_id, dose_unit, max_single_dose, max_daily_dose, dose_per_kg, min_age_months, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (medicine_id) DO UPDATE SET
+			dose_unit = excluded.dose_unit, max_single_dose = excluded.max_single_dose, max_daily_dose = excluded.max_daily_dose,
+			dose_per_kg = excluded.dose_per_kg, min_age_months = excluded.min_age_months, updated_at = excluded.updated_at`,
+		medicineID, rule.DoseUnit, rule.MaxSingleDose, rule.MaxDailyDose, rule.DosePerKg, rule.MinAgeMonths, time.Now())
+	return err
+}
+
+// validateDosingRule 校验剂量规则参数
+func validateDosingRule(rule *models.MedicineDosingRule) string {
+	if rule.MaxSingleDose < 0 || rule.MaxDailyDose < 0 || rule.DosePerKg < 0 || rule.MinAgeMonths < 0 {
+		return "剂量规则不能为负数"
+	}
+	if (rule.MaxSingleDose > 0 || rule.MaxDailyDose > 0 || rule.DosePerKg > 0) && strings.TrimSpace(rule.DoseUnit) == "" {
+		return "请填写剂量单位"
+	}
+	if rule.MaxSingleDose > 0 && rule.MaxDailyDose > 0 && rule.MaxDailyDose < rule.MaxSingleDose {
+		return "每日最大剂量不能小于单次最大剂量"
+	}
+	return ""
+}
+
+// patientDosingProfile 查询剂量核对所需的患者年龄（按出生日期计算，含显示文本）和体重；
+// 未提供体重时取该患者最近一次测量的体重，没有测量记录时取最近一次处方记录的体重
+func patientDosingProfile(q rowQuerier, patientID int, weight float64) (models.Patient, float64, error) {
+	var patient models.Patient
+	err := q.QueryRow("SELECT age, birth_date FROM patients WHERE id = ?", patientID).Scan(&patient.Age, &patient.BirthDate)
+	if err != nil {
+		return patient, 0, err
+	}
+	patient.FillAge(time.Now())
+	if patient.AgeText == "" {
+		patient.AgeText = "年龄未登记"
+	}
+	if weight <= 0 {
+		err := q.QueryRow(`
+			SELECT weight FROM vital_signs WHERE patient_id = ? AND weight > 0
+			ORDER BY measured_at DESC, id DESC LIMIT 1`, patientID).Scan(&weight)
+		if err != nil && err != sql.ErrNoRows {
+			return patient, 0, err
+		}
+	}
+	if weight <= 0 {
+		err := q.QueryRow(`
+			SELECT patient_weight FROM prescriptions WHERE patient_id = ? AND patient_weight > 0
+			ORDER BY created_at DESC, id DESC LIMIT 1`, patientID).Scan(&weight)
+		if err != nil && err != sql.ErrNoRows {
+			return patient, 0, err
+		}
+	}
+	return patient, weight, nil
+}
+
+// checkMinAge 按出生日期计算的月龄核对最低用药年龄，低于最低年龄时返回提示；
+// 没有出生日期的按登记的周岁换算，年龄未登记的同样提示
+func checkMinAge(patient models.Patient, medicineName string, rule *models.MedicineDosingRule, now time.Time) string {
+	if rule.MinAgeMonths <= 0 {
+		return ""
+	}
+	months := patient.Age * 12
+	if birth, err := time.ParseInLocation(models.BirthDateLayout, patient.BirthDate, time.Local); err == nil {
+		years, m, _ := models.AgeOn(birth, now)
+		months = years*12 + m
+	}
+	if months >= rule.MinAgeMonths {
+		return ""
+	}
+	return fmt.Sprintf("患者 %s，低于药品【%s】的最低用药年龄 %s", patient.AgeText, medicineName, formatMonths(rule.MinAgeMonths))
+}
+
+// formatMonths 将月数显示为岁和月，如 6个月、1岁6个月、2岁
+func formatMonths(months int) string {
+	switch {
+	case months < 12:
+		return fmt.Sprintf("%d个月", months)
+	case months%12 == 0:
+		return fmt.Sprintf("%d岁", months/12)
+	default:
+		return fmt.Sprintf("%d岁%d个月", months/12, months%12)
+	}
+}
+
+// suggestDose 按剂量规则和体重计算建议单次剂量
+func suggestDose(medicineID int, medicineName string, rule *models.MedicineDosingRule, weight float64) models.DoseSuggestion {
+	suggestion := models.DoseSuggestion{
+		MedicineID:    medicineID,
+		MedicineName:  medicineName,
+		DoseUnit:      rule.DoseUnit,
+		MaxSingleDose: rule.MaxSingleDose,
+		MaxDailyDose:  rule.MaxDailyDose,
+	}
+	switch {
+	case rule.DosePerKg > 0 && weight > 0:
+		dose := roundDose(rule.DosePerKg * weight)
+		suggestion.Basis = fmt.Sprintf("按体重 %g%s/kg × %gkg", rule.DosePerKg, rule.DoseUnit, weight)
+		if rule.MaxSingleDose > 0 && dose > rule.MaxSingleDose {
+			dose = rule.MaxSingleDose
+			suggestion.Basis += "，超过单次最大剂量，按最大剂量"
+		}
+		suggestion.SuggestedDose = dose
+	case rule.DosePerKg > 0:
+		suggestion.Basis = "未记录体重，无法按体重计算"
+	case rule.MaxSingleDose > 0:
+		suggestion.SuggestedDose = rule.MaxSingleDose
+		suggestion.Basis = "单次最大剂量"
+	}
+	return suggestion
+}
+
+// suggestDoses 计算处方中设置了剂量规则的药品的建议剂量
+func suggestDoses(tx *sql.Tx, prescription *models.Prescription) ([]models.DoseSuggestion, error) {
+	suggestions := []models.DoseSuggestion{}
+	_, weight, err := patientDosingProfile(tx, prescription.PatientID, prescription.PatientWeight)
+	if err == sql.ErrNoRows {
+		return suggestions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range prescription.Items {
+		rule, err := loadDosingRule(tx, item.MedicineID)
+		if err != nil {
+			return nil, err
+		}
+		if rule == nil {
+			continue
+		}
+		suggestions = append(suggestions, suggestDose(item.MedicineID, item.MedicineName, rule, weight))
+	}
+	return suggestions, nil
+}
+
+// checkDosage 按药品剂量规则核对患者年龄、单次剂量、每日剂量和按体重剂量
+func checkDosage(tx *sql.Tx, prescription *models.Prescription, medicines []*checkMedicine) ([]models.PrescriptionWarning, error) {
+	patient, weight, err := patientDosingProfile(tx, prescription.PatientID, prescription.PatientWeight)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var warnings []models.PrescriptionWarning
+	warn := func(medicine *checkMedicine, severity, message string) {
+		warnings = append(warnings, models.PrescriptionWarning{
+			Type:         "dosage",
+			Severity:     severity,
+			MedicineID:   medicine.ID,
+			MedicineName: medicine.Name,
+			Message:      message,
+		})
+	}
+
+	for _, medicine := range medicines {
+		rule, err := loadDosingRule(tx, medicine.ID)
+		if err != nil {
+			return nil, err
+		}
+		if rule == nil {
+			continue
+		}
+		item := medicine.Item
+
+		if msg := checkMinAge(patient, medicine.Name, rule, now); msg != "" {
+			warn(medicine, models.SeveritySevere, msg)
+		}
+
+		if strings.TrimSpace(item.Dosage) == "" || (rule.MaxSingleDose <= 0 && rule.MaxDailyDose <= 0 && rule.DosePerKg <= 0) {
+			continue
+		}
+		value, unit, ok := parseDose(item.Dosage)
+		if ok {
+			value, ok = convertDose(value, unit, rule.DoseUnit)
+		}
+		if !ok {
+			warn(medicine, models.SeverityMild,
+				fmt.Sprintf("药品【%s】的剂量【%s】无法换算为 %s，未能核对剂量", medicine.Name, item.Dosage, rule.DoseUnit))
+			continue
+		}
+
+		if rule.MaxSingleDose > 0 && value > rule.MaxSingleDose {
+			warn(medicine, models.SeveritySevere,
+				fmt.Sprintf("药品【%s】单次剂量 %g%s 超过最大单次剂量 %g%s", medicine.Name, value, rule.DoseUnit, rule.MaxSingleDose, rule.DoseUnit))
+		}
+		if rule.DosePerKg > 0 {
+			if weight > 0 {
+				limit := roundDose(rule.DosePerKg * weight)
+				if value > limit {
+					warn(medicine, models.SeveritySevere,
+						fmt.Sprintf("药品【%s】单次剂量 %g%s 超过按体重计算的剂量 %g%s（%g%s/kg × %gkg）",
+							medicine.Name, value, rule.DoseUnit, limit, rule.DoseUnit, rule.DosePerKg, rule.DoseUnit, weight))
+				}
+			} else {
+				warn(medicine, models.SeverityMild,
+					fmt.Sprintf("药品【%s】需按体重核对剂量，请填写患者体重", medicine.Name))
+			}
+		}
+		if rule.MaxDailyDose > 0 {
+			times := parseTimesPerDay(item.Frequency)
+			if times == 0 {
+				warn(medicine, models.SeverityMild,
+					fmt.Sprintf("药品【%s】的频次【%s】无法识别，未能核对每日剂量", medicine.Name, item.Frequency))
+			} else if daily := roundDose(value * float64(times)); daily > rule.MaxDailyDose {
+				warn(medicine, models.SeveritySevere,
+					fmt.Sprintf("药品【%s】每日剂量 %g%s（%g%s × %d 次）超过每日最大剂量 %g%s",
+						medicine.Name, daily, rule.DoseUnit, value, rule.DoseUnit, times, rule.MaxDailyDose, rule.DoseUnit))
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// SuggestDose 按患者年龄、体重计算药品的建议单次剂量，供开方时参考；
+// 未传体重时取患者最近一次测量的体重，没有测量记录时取最近一次处方记录的体重
+func (mc *MedicineController) SuggestDose(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的药品ID"})
+		return
+	}
+	patientID, _ := strconv.Atoi(c.Query("patient_id"))
+	weight, _ := strconv.ParseFloat(c.Query("weight"), 64)
+
+	var name string
+	if err := database.DB.QueryRow("SELECT name FROM medicines WHERE id = ?", id).Scan(&name); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "药品不存在"})
+		return
+	}
+
+	rule, err := loadDosingRule(database.DB, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询剂量规则失败"})
+		return
+	}
+	if rule == nil {
+		c.JSON(http.StatusOK, gin.H{"suggestion": nil, "warnings": []models.PrescriptionWarning{}})
+		return
+	}
+
+	warnings := []models.PrescriptionWarning{}
+	if patientID > 0 {
+		var patient models.Patient
+		patient, weight, err = patientDosingProfile(database.DB, patientID, weight)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+			return
+		}
+		if msg := checkMinAge(patient, name, rule, time.Now()); msg != "" {
+			warnings = append(warnings, models.PrescriptionWarning{
+				Type:         "dosage",
+				Severity:     models.SeveritySevere,
+				MedicineID:   id,
+				MedicineName: name,
+				Message:      msg,
+			})
+		}
+	}
+
+	suggestion := suggestDose(id, name, rule, weight)
+	c.JSON(http.StatusOK, gin.H{
+		"suggestion": suggestion,
+		"weight":     weight,
+		"warnings":   warnings,
+	})
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/models"
+	"math"
+	"strings"
+)
+
+// maxHerbalDoses 中药饮片处方单张最大剂数
+const maxHerbalDoses = 99
+
+// isGramUnit 判断药品库存单位是否为克
+func isGramUnit(unit string) bool {
+	unit = strings.ToLower(strings.TrimSpace(unit))
+	return unit == "g" || unit == "克"
+}
+
+// herbalQuantity 计算饮片总用量（克）= 每剂克数 × 剂数，不足1克的部分按1克计
+func herbalQuantity(grams float64, doses int) int {
+	return int(math.Ceil(roundDose(grams * float64(doses))))
+}
+
+// prepareHerbalPrescription 校验处方类型；中药饮片处方按每剂克数 × 剂数计算各味药的数量，
+// 饮片须以克为库存单位，扣减库存时按克扣减
+func prepareHerbalPrescription(tx *sql.Tx, prescription *models.Prescription) error {
+	switch prescription.PrescriptionType {
+	case "", models.PrescriptionWestern:
+		prescription.PrescriptionType = models.PrescriptionWestern
+		prescription.HerbalDoses = 0
+		prescription.DecoctionMethod = ""
+		for i := range prescription.Items {
+			prescription.Items[i].HerbGrams = 0
+			prescription.Items[i].SpecialProcessing = ""
+		}
+		return nil
+	case models.PrescriptionHerbal:
+	default:
+		return &prescriptionError{msg: "无效的处方类型"}
+	}
+
+	if prescription.HerbalDoses <= 0 || prescription.HerbalDoses > maxHerbalDoses {
+		return &prescriptionError{msg: fmt.Sprintf("中药处方剂数应为 1-%d 剂", maxHerbalDoses)}
+	}
+	prescription.DecoctionMethod = strings.TrimSpace(prescription.DecoctionMethod)
+
+	for i := range prescription.Items {
+		item := &prescription.Items[i]
+		if item.HerbGrams <= 0 {
+			return &prescriptionError{msg: fmt.Sprintf("第%d味药【%s】每剂克数必须大于0", i+1, item.MedicineName)}
+		}
+
+		var name, unit string
+		err := tx.QueryRow("SELECT name, unit FROM medicines WHERE id = ?", item.MedicineID).Scan(&name, &unit)
+		if err == sql.ErrNoRows {
+			return &prescriptionError{msg: fmt.Sprintf("第%d味药【%s】不存在或已删除", i+1, item.MedicineName)}
+		}
+		if err != nil {
+			return err
+		}
+		if !isGramUnit(unit) {
+			return &prescriptionError{msg: fmt.Sprintf("药品【%s】的库存单位为【%s】，中药饮片须以克为单位", name, unit)}
+		}
+
+		item.HerbGrams = roundDose(item.HerbGrams)
+		item.SpecialProcessing = strings.TrimSpace(item.SpecialProcessing)
+		item.Quantity = herbalQuantity(item.HerbGrams, prescription.HerbalDoses)
+		item.Dosage = fmt.Sprintf("%gg", item.HerbGrams)
+		item.Usage = item.SpecialProcessing
+		item.Frequency = ""
+		item.Days = 0
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// assignPrescriptionNo 处方完成时在事务内分配编号，格式为 RX+日期+当日流水号（如 RX20261017-0007）；
+// 流水号按日从1开始，计数器只增不减，已分配的编号即使处方被删除也不会再次使用
+func assignPrescriptionNo(tx *sql.Tx, id int, now time.Time) error {
+	var prescriptionNo string
+	if err := tx.QueryRow("SELECT prescription_no FROM prescriptions WHERE id = ?", id).Scan(&prescriptionNo); err != nil {
+		return err
+	}
+	if prescriptionNo != "" {
+		return nil
+	}
+
+	day := now.Format("20060102")
+	var seq int
+	err := tx.QueryRow(`
+		INSERT INTO prescription_number_sequences (seq_date, last_value) VALUES (?, 1)
+		ON CONFLICT (seq_date) DO UPDATE SET last_value = last_value + 1
+		RETURNING last_value`, day).Scan(&seq)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE prescriptions SET prescription_no = ? WHERE id = ?", fmt.Sprintf("RX%s-%04d", day, seq), id)
+	return err
+}
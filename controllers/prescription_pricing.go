@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/models"
+	"math"
+)
+
+// roundAmount 金额保留两位小数
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// amountMismatch 判断客户端提交的金额与服务端计算结果是否不一致，未提交（为0）时不校验
+func amountMismatch(submitted, computed float64) bool {
+	return submitted != 0 && math.Abs(submitted-computed) >= 0.005
+}
+
+// priceMedicineItems 按药品库当前价格重新计算处方明细金额并快照药品名称、规格和单价，返回处方总金额。
+// 药品不存在、数量不合法或客户端提交的金额与计算结果不一致时返回 prescriptionError
+func priceMedicineItems(tx *sql.Tx, items []models.PrescriptionItem) (float64, error) {
+	total := 0.0
+	for i := range items {
+		item := &items[i]
+		if item.MedicineID <= 0 {
+			return 0, &prescriptionError{msg: fmt.Sprintf("第%d项药品【%s】未关联药品库，请从药品列表中选择", i+1, item.MedicineName)}
+		}
+		if item.Quantity <= 0 {
+			return 0, &prescriptionError{msg: fmt.Sprintf("第%d项药品【%s】数量必须大于0", i+1, item.MedicineName)}
+		}
+
+		var name, specification string
+		var price float64
+		err := tx.QueryRow("SELECT name, specification, price FROM medicines WHERE id = ?", item.MedicineID).Scan(
+			&name, &specification, &price)
+		if err == sql.ErrNoRows {
+			return 0, &prescriptionError{msg: fmt.Sprintf("第%d项药品【%s】不存在或已删除", i+1, item.MedicineName)}
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if amountMismatch(item.UnitPrice, price) {
+			return 0, &prescriptionError{msg: fmt.Sprintf("药品【%s】单价已变更为 ¥%.2f，请刷新后重新提交", name, price)}
+		}
+		totalPrice := roundAmount(float64(item.Quantity) * price)
+		if amountMismatch(item.TotalPrice, totalPrice) {
+			return 0, &prescriptionError{msg: fmt.Sprintf("药品【%s】金额应为 ¥%.2f，与提交的 ¥%.2f 不一致", name, totalPrice, item.TotalPrice)}
+		}
+
+		item.MedicineName = name
+		item.Specification = specification
+		item.UnitPrice = price
+		item.TotalPrice = totalPrice
+		total += totalPrice
+	}
+	return roundAmount(total), nil
+}
+
+// pricePrescription 重新计算处方明细及总金额（中药饮片先按剂数计算用量），并校验客户端提交的总金额
+func pricePrescription(tx *sql.Tx, prescription *models.Prescription) error {
+	if err := prepareHerbalPrescription(tx, prescription); err != nil {
+		return err
+	}
+
+	total, err := priceMedicineItems(tx, prescription.Items)
+	if err != nil {
+		return err
+	}
+	if amountMismatch(prescription.TotalAmount, total) {
+		return &prescriptionError{msg: fmt.Sprintf("处方总金额应为 ¥%.2f，与提交的 ¥%.2f 不一致", total, prescription.TotalAmount)}
+	}
+	prescription.TotalAmount = total
+	return nil
+}
@@ -0,0 +1,490 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PrescriptionTemplateController struct{}
+
+// loadTemplateOwner 查询模板的适用范围和创建人
+func loadTemplateOwner(id int) (string, int, error) {
+	var scope string
+	var ownerID int
+	err := database.DB.QueryRow("SELECT scope, owner_id FROM prescription_templates WHERE id = ?", id).Scan(&scope, &ownerID)
+	return scope, ownerID, err
+}
+
+// canEditTemplate 模板只能由创建人或管理员修改
+func canEditTemplate(c *gin.Context, ownerID int) bool {
+	return ownerID == currentUserID(c) || currentUserRole(c) == "admin"
+}
+
+// validateTemplate 校验模板内容，并以药品库中的名称、规格快照明细
+func validateTemplate(template *models.PrescriptionTemplate) error {
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" {
+		return &prescriptionError{msg: "模板名称不能为空"}
+	}
+	if template.Scope == "" {
+		template.Scope = models.TemplateScopePersonal
+	}
+	if template.Scope != models.TemplateScopePersonal && template.Scope != models.TemplateScopeClinic {
+		return &prescriptionError{msg: "无效的模板范围"}
+	}
+	if len(template.Items) == 0 {
+		return &prescriptionError{msg: "模板至少包含一种药品"}
+	}
+
+	// 中药饮片模板按每剂克数保存，数量按剂数折算
+	herbal := false
+	switch template.PrescriptionType {
+	case "", models.PrescriptionWestern:
+		template.PrescriptionType = models.PrescriptionWestern
+		template.HerbalDoses = 0
+		template.DecoctionMethod = ""
+	case models.PrescriptionHerbal:
+		if template.HerbalDoses <= 0 || template.HerbalDoses > maxHerbalDoses {
+			return &prescriptionError{msg: fmt.Sprintf("中药处方剂数应为 1-%d 剂", maxHerbalDoses)}
+		}
+		template.DecoctionMethod = strings.TrimSpace(template.DecoctionMethod)
+		herbal = true
+	default:
+		return &prescriptionError{msg: "无效的处方类型"}
+	}
+
+	for i := range template.Items {
+		item := &template.Items[i]
+		if herbal {
+			if item.HerbGrams <= 0 {
+				return &prescriptionError{msg: fmt.Sprintf("第%d味药【%s】每剂克数必须大于0", i+1, item.MedicineName)}
+			}
+			item.HerbGrams = roundDose(item.HerbGrams)
+			item.SpecialProcessing = strings.TrimSpace(item.SpecialProcessing)
+			item.Quantity = herbalQuantity(item.HerbGrams, template.HerbalDoses)
+		} else {
+			item.HerbGrams = 0
+			item.SpecialProcessing = ""
+		}
+		if item.Quantity <= 0 {
+			return &prescriptionError{msg: fmt.Sprintf("第%d项药品【%s】数量必须大于0", i+1, item.MedicineName)}
+		}
+		if item.Days <= 0 {
+			item.Days = 1
+		}
+		var unit string
+		err := database.DB.QueryRow("SELECT name, specification, unit FROM medicines WHERE id = ?", item.MedicineID).Scan(
+			&item.MedicineName, &item.Specification, &unit)
+		if err == sql.ErrNoRows {
+			return &prescriptionError{msg: fmt.Sprintf("第%d项药品【%s】不存在或已删除", i+1, item.MedicineName)}
+		}
+		if err != nil {
+			return err
+		}
+		if herbal && !isGramUnit(unit) {
+			return &prescriptionError{msg: fmt.Sprintf("药品【%s】的库存单位为【%s】，中药饮片须以克为单位", item.MedicineName, unit)}
+		}
+	}
+	return nil
+}
+
+// saveTemplateItems 在事务内写入模板明细
+func saveTemplateItems(tx *sql.Tx, templateID int64, items []models.PrescriptionTemplateItem) error {
+	for _, item := range items {
+		_, err := tx.Exec(`
+			INSERT INTO prescription_template_items (template_id, medicine_id, medicine_name, specification,
+			dosage, usage, frequency, days, quantity, herb_grams, special_processing)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			templateID, item.MedicineID, item.MedicineName, item.Specification,
+			item.Dosage, item.Usage, item.Frequency, item.Days, item.Quantity, item.HerbGrams, item.SpecialProcessing)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTemplateItems 查询模板明细
+func loadTemplateItems(templateID int) ([]models.PrescriptionTemplateItem, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, template_id, medicine_id, medicine_name, specification, dosage, usage, frequency, days, quantity,
+		herb_grams, special_processing
+		FROM prescription_template_items WHERE template_id = ? ORDER BY id`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.PrescriptionTemplateItem
+	for rows.Next() {
+		var item models.PrescriptionTemplateItem
+		err := rows.Scan(&item.ID, &item.TemplateID, &item.MedicineID, &item.MedicineName, &item.Specification,
+			&item.Dosage, &item.Usage, &item.Frequency, &item.Days, &item.Quantity, &item.HerbGrams, &item.SpecialProcessing)
+		if err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (tc *PrescriptionTemplateController) Create(c *gin.Context) {
+	var template models.PrescriptionTemplate
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	if err := validateTemplate(&template); err != nil {
+		respondPrescriptionError(c, err, "创建模板失败")
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建模板失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO prescription_templates (name, pinyin, initials, scope, owner_id, diagnosis, doctor_advice, notes,
+		prescription_type, herbal_doses, decoction_method, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		template.Name, strings.ToLower(getPinyin(template.Name)), strings.ToLower(getInitials(template.Name)),
+		template.Scope, currentUserID(c), template.Diagnosis, template.DoctorAdvice, template.Notes,
+		template.PrescriptionType, template.HerbalDoses, template.DecoctionMethod, now, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建模板失败"})
+		return
+	}
+
+	templateID, _ := result.LastInsertId()
+	if err := saveTemplateItems(tx, templateID, template.Items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建模板明细失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建模板失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "模板创建成功",
+		"id":      templateID,
+	})
+}
+
+func (tc *PrescriptionTemplateController) Get(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的模板ID"})
+		return
+	}
+
+	var template models.PrescriptionTemplate
+	var ownerName string
+	err = database.DB.QueryRow(`
+		SELECT t.id, t.name, t.pinyin, t.initials, t.scope, t.owner_id, t.diagnosis, t.doctor_advice, t.notes,
+		       t.prescription_type, t.herbal_doses, t.decoction_method, t.created_at, t.updated_at,
+		       COALESCE(u.name, '') as owner_name
+		FROM prescription_templates t
+		LEFT JOIN users u ON t.owner_id = u.id
+		WHERE t.id = ?`, id).Scan(
+		&template.ID, &template.Name, &template.Pinyin, &template.Initials, &template.Scope, &template.OwnerID,
+		&template.Diagnosis, &template.DoctorAdvice, &template.Notes,
+		&template.PrescriptionType, &template.HerbalDoses, &template.DecoctionMethod,
+		&template.CreatedAt, &template.UpdatedAt, &ownerName)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+		return
+	}
+
+	// 个人模板仅创建人可见
+	if template.Scope == models.TemplateScopePersonal && template.OwnerID != currentUserID(c) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+		return
+	}
+
+	template.Owner = &models.User{ID: template.OwnerID, Name: ownerName}
+	template.Items, err = loadTemplateItems(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询模板明细失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"template": template})
+}
+
+func (tc *PrescriptionTemplateController) Update(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的模板ID"})
+		return
+	}
+
+	_, ownerID, err := loadTemplateOwner(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+		return
+	}
+	if !canEditTemplate(c, ownerID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能修改自己创建的模板"})
+		return
+	}
+
+	var template models.PrescriptionTemplate
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+	if err := validateTemplate(&template); err != nil {
+		respondPrescriptionError(c, err, "更新模板失败")
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新模板失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE prescription_templates SET name = ?, pinyin = ?, initials = ?, scope = ?, diagnosis = ?, doctor_advice = ?,
+		notes = ?, prescription_type = ?, herbal_doses = ?, decoction_method = ?, updated_at = ? WHERE id = ?`,
+		template.Name, strings.ToLower(getPinyin(template.Name)), strings.ToLower(getInitials(template.Name)),
+		template.Scope, template.Diagnosis, template.DoctorAdvice, template.Notes,
+		template.PrescriptionType, template.HerbalDoses, template.DecoctionMethod, time.Now(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新模板失败"})
+		return
+	}
+
+	// 模板不涉及历史记录，明细直接替换
+	if _, err := tx.Exec("DELETE FROM prescription_template_items WHERE template_id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新模板明细失败"})
+		return
+	}
+	if err := saveTemplateItems(tx, int64(id), template.Items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新模板明细失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新模板失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "模板更新成功"})
+}
+
+func (tc *PrescriptionTemplateController) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的模板ID"})
+		return
+	}
+
+	_, ownerID, err := loadTemplateOwner(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+		return
+	}
+	if !canEditTemplate(c, ownerID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能删除自己创建的模板"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除模板失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM prescription_template_items WHERE template_id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除模板明细失败"})
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM prescription_templates WHERE id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除模板失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除模板失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "模板删除成功"})
+}
+
+// List 列出全院模板和本人的个人模板，支持按名称、拼音、拼音首字母搜索
+func (tc *PrescriptionTemplateController) List(c *gin.Context) {
+	search := strings.TrimSpace(c.Query("search"))
+	scope := c.Query("scope")
+
+	whereClause := "WHERE (t.scope = ? OR t.owner_id = ?)"
+	args := []interface{}{models.TemplateScopeClinic, currentUserID(c)}
+	if scope != "" {
+		whereClause += " AND t.scope = ?"
+		args = append(args, scope)
+	}
+	if search != "" {
+		keyword := "%" + strings.ToLower(search) + "%"
+		whereClause += " AND (t.name LIKE ? OR t.pinyin LIKE ? OR t.initials LIKE ? OR t.diagnosis LIKE ?)"
+		args = append(args, "%"+search+"%", keyword, keyword, "%"+search+"%")
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT t.id, t.name, t.pinyin, t.initials, t.scope, t.owner_id, t.diagnosis, t.doctor_advice, t.notes,
+		       t.prescription_type, t.herbal_doses, t.decoction_method, t.created_at, t.updated_at,
+		       COALESCE(u.name, '') as owner_name
+		FROM prescription_templates t
+		LEFT JOIN users u ON t.owner_id = u.id
+		`+whereClause+` ORDER BY t.scope DESC, t.name ASC`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询模板列表失败"})
+		return
+	}
+	defer rows.Close()
+
+	var templates []models.PrescriptionTemplate
+	for rows.Next() {
+		var template models.PrescriptionTemplate
+		var ownerName string
+		err := rows.Scan(&template.ID, &template.Name, &template.Pinyin, &template.Initials, &template.Scope, &template.OwnerID,
+			&template.Diagnosis, &template.DoctorAdvice, &template.Notes,
+			&template.PrescriptionType, &template.HerbalDoses, &template.DecoctionMethod,
+			&template.CreatedAt, &template.UpdatedAt, &ownerName)
+		if err != nil {
+			continue
+		}
+		template.Owner = &models.User{ID: template.OwnerID, Name: ownerName}
+		templates = append(templates, template)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+// Instantiate 根据模板为患者生成草稿处方，价格按药品库当前价格重新计算
+func (tc *PrescriptionTemplateController) Instantiate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的模板ID"})
+		return
+	}
+
+	var req struct {
+		PatientID   int `json:"patient_id" binding:"required"`
+		EncounterID int `json:"encounter_id"` // 可选，生成的处方关联到该次就诊
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var template models.PrescriptionTemplate
+	err = database.DB.QueryRow(`
+		SELECT id, scope, owner_id, diagnosis, doctor_advice, notes, prescription_type, herbal_doses, decoction_method
+		FROM prescription_templates WHERE id = ?`, id).Scan(
+		&template.ID, &template.Scope, &template.OwnerID, &template.Diagnosis, &template.DoctorAdvice, &template.Notes,
+		&template.PrescriptionType, &template.HerbalDoses, &template.DecoctionMethod)
+	if err != nil || (template.Scope == models.TemplateScopePersonal && template.OwnerID != currentUserID(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+		return
+	}
+
+	var patientCount int
+	database.DB.QueryRow("SELECT COUNT(*) FROM patients WHERE id = ?", req.PatientID).Scan(&patientCount)
+	if patientCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+
+	templateItems, err := loadTemplateItems(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询模板明细失败"})
+		return
+	}
+
+	prescription := models.Prescription{
+		PatientID:    req.PatientID,
+		DoctorID:     currentUserID(c),
+		Diagnosis:    template.Diagnosis,
+		DoctorAdvice: template.DoctorAdvice,
+		Notes:        template.Notes,
+		EncounterID:  req.EncounterID,
+
+		PrescriptionType: template.PrescriptionType,
+		HerbalDoses:      template.HerbalDoses,
+		DecoctionMethod:  template.DecoctionMethod,
+	}
+	for _, item := range templateItems {
+		prescription.Items = append(prescription.Items, models.PrescriptionItem{
+			MedicineID:    item.MedicineID,
+			MedicineName:  item.MedicineName,
+			Specification: item.Specification,
+			Dosage:        item.Dosage,
+			Usage:         item.Usage,
+			Frequency:     item.Frequency,
+			Days:          item.Days,
+			Quantity:      item.Quantity,
+
+			HerbGrams:         item.HerbGrams,
+			SpecialProcessing: item.SpecialProcessing,
+		})
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成处方失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := pricePrescription(tx, &prescription); err != nil {
+		respondPrescriptionError(c, err, "生成处方失败")
+		return
+	}
+	if err := attachEncounter(tx, &prescription); err != nil {
+		respondPrescriptionError(c, err, "生成处方失败")
+		return
+	}
+
+	// 用药安全提示仅供医生确认，不阻止生成草稿
+	warnings, err := collectPrescriptionWarnings(tx, &prescription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "用药安全审核失败"})
+		return
+	}
+
+	prescriptionID, err := insertDraftPrescription(tx, &prescription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成处方失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成处方失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "已根据模板生成草稿处方",
+		"id":           prescriptionID,
+		"total_amount": prescription.TotalAmount,
+		"warnings":     warnings,
+	})
+}
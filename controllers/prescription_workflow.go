@@ -0,0 +1,482 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// prescriptionError 处方业务校验错误，错误信息可直接返回给前端
+type prescriptionError struct {
+	msg      string
+	warnings []models.PrescriptionWarning // 导致校验失败的用药安全提示
+}
+
+func (e *prescriptionError) Error() string {
+	return e.msg
+}
+
+// respondPrescriptionError 根据错误类型返回对应的HTTP状态
+func respondPrescriptionError(c *gin.Context, err error, fallback string) {
+	switch e := err.(type) {
+	case *prescriptionError:
+		if len(e.warnings) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": e.Error(), "warnings": e.warnings})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+	case *stockShortageError:
+		c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+	default:
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "处方不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// prescriptionStatusName 获取处方状态的中文名称
+func prescriptionStatusName(status string) string {
+	if name, ok := models.PrescriptionStatusNames[status]; ok {
+		return name
+	}
+	return status
+}
+
+// requirePrescriptionDraft 校验处方为草稿状态
+func requirePrescriptionDraft(tx *sql.Tx, id int, msg string) error {
+	var status string
+	err := tx.QueryRow("SELECT status FROM prescriptions WHERE id = ?", id).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status != models.PrescriptionDraft {
+		return &prescriptionError{msg: msg}
+	}
+	return nil
+}
+
+// transitionPrescription 在事务内按状态机变更处方状态：完成时扣减库存并分配处方编号，作废时归还库存，
+// 修订处方完成时同时作废被修订的原处方
+func transitionPrescription(tx *sql.Tx, id int, to string, userID int) error {
+	var from string
+	var stockDeducted bool
+	var amendedFromID int
+	err := tx.QueryRow("SELECT status, stock_deducted, amended_from_id FROM prescriptions WHERE id = ?", id).Scan(
+		&from, &stockDeducted, &amendedFromID)
+	if err != nil {
+		return err
+	}
+
+	if !models.CanTransitionPrescription(from, to) {
+		return &prescriptionError{msg: fmt.Sprintf("处方状态不能从【%s】变更为【%s】",
+			prescriptionStatusName(from), prescriptionStatusName(to))}
+	}
+
+	switch to {
+	case models.PrescriptionCompleted:
+		// 完成前按已保存的明细重新核对过敏史，期间新增的过敏记录同样生效
+		if err := checkStoredPrescription(tx, id); err != nil {
+			return err
+		}
+		// 先作废原处方归还库存，再按修订后的明细扣减
+		if amendedFromID > 0 {
+			var originalStatus string
+			err := tx.QueryRow("SELECT status FROM prescriptions WHERE id = ?", amendedFromID).Scan(&originalStatus)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if err == nil && originalStatus != models.PrescriptionVoided {
+				reason := fmt.Sprintf("已由修订处方（ID %d）替代", id)
+				if err := voidPrescription(tx, amendedFromID, userID, reason); err != nil {
+					return err
+				}
+			}
+		}
+		if !stockDeducted {
+			if err := deductPrescriptionStock(tx, id, userID); err != nil {
+				return err
+			}
+			stockDeducted = true
+		}
+		if err := assignPrescriptionNo(tx, id, time.Now()); err != nil {
+			return err
+		}
+		// 记录完成时间，药房审核队列按此排序
+		if _, err := tx.Exec("UPDATE prescriptions SET completed_at = ? WHERE id = ?", time.Now(), id); err != nil {
+			return err
+		}
+	case models.PrescriptionVoided:
+		if stockDeducted {
+			if err := restorePrescriptionStock(tx, id, userID); err != nil {
+				return err
+			}
+			stockDeducted = false
+		}
+	}
+
+	_, err = tx.Exec("UPDATE prescriptions SET status = ?, stock_deducted = ?, updated_at = ? WHERE id = ?",
+		to, stockDeducted, time.Now(), id)
+	return err
+}
+
+// voidPrescription 作废处方并记录原因、操作人和时间；库存由状态机归还，
+// 已开具（视为已收费）的处方同时记录应退金额，冲销原收费
+func voidPrescription(tx *sql.Tx, id int, userID int, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return &prescriptionError{msg: "请填写作废原因"}
+	}
+
+	var from string
+	var totalAmount float64
+	err := tx.QueryRow("SELECT status, total_amount FROM prescriptions WHERE id = ?", id).Scan(&from, &totalAmount)
+	if err != nil {
+		return err
+	}
+	if err := transitionPrescription(tx, id, models.PrescriptionVoided, userID); err != nil {
+		return err
+	}
+
+	refundAmount := 0.0
+	if from != models.PrescriptionDraft {
+		refundAmount = totalAmount
+	}
+	_, err = tx.Exec("UPDATE prescriptions SET void_reason = ?, voided_by = ?, voided_at = ?, refund_amount = ? WHERE id = ?",
+		reason, userID, time.Now(), refundAmount, id)
+	return err
+}
+
+// Versions 查询处方的完整修订链，按版本先后排列，包括已作废的版本
+func (pc *PrescriptionController) Versions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的处方ID"})
+		return
+	}
+
+	// 沿修订关系向上找到原始处方
+	rootID := id
+	seen := map[int]bool{}
+	for !seen[rootID] {
+		seen[rootID] = true
+		var parentID int
+		err := database.DB.QueryRow("SELECT amended_from_id FROM prescriptions WHERE id = ?", rootID).Scan(&parentID)
+		if err == sql.ErrNoRows && rootID == id {
+			c.JSON(http.StatusNotFound, gin.H{"error": "处方不存在"})
+			return
+		}
+		if err != nil || parentID == 0 {
+			break
+		}
+		rootID = parentID
+	}
+
+	// 再从原始处方逐层向下收集所有修订版本
+	versions := []models.PrescriptionVersion{}
+	queue := []int{rootID}
+	visited := map[int]bool{}
+	for len(queue) > 0 {
+		currentID := queue[0]
+		queue = queue[1:]
+		if visited[currentID] {
+			continue
+		}
+		visited[currentID] = true
+
+		var version models.PrescriptionVersion
+		var doctorName sql.NullString
+		err := database.DB.QueryRow(`
+			SELECT p.id, p.prescription_no, p.amended_from_id, p.status, COALESCE(p.diagnosis, ''), p.total_amount,
+			       p.void_reason, p.voided_at, p.created_at, u.name
+			FROM prescriptions p
+			LEFT JOIN users u ON p.doctor_id = u.id
+			WHERE p.id = ?`, currentID).Scan(
+			&version.ID, &version.PrescriptionNo, &version.AmendedFromID, &version.Status, &version.Diagnosis,
+			&version.TotalAmount, &version.VoidReason, &version.VoidedAt, &version.CreatedAt, &doctorName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方版本失败"})
+			return
+		}
+		version.DoctorName = doctorName.String
+		version.Current = version.ID == id
+		versions = append(versions, version)
+
+		rows, err := database.DB.Query("SELECT id FROM prescriptions WHERE amended_from_id = ? ORDER BY id", currentID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方版本失败"})
+			return
+		}
+		for rows.Next() {
+			var childID int
+			if err := rows.Scan(&childID); err == nil {
+				queue = append(queue, childID)
+			}
+		}
+		rows.Close()
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].ID < versions[j].ID })
+	for i := range versions {
+		versions[i].Version = i + 1
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// Amend 为已完成的处方创建修订草稿，修订处方完成后原处方自动作废
+func (pc *PrescriptionController) Amend(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的处方ID"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建修订处方失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM prescriptions WHERE id = ?", id).Scan(&status)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "处方不存在"})
+		return
+	}
+	if status == models.PrescriptionDraft || status == models.PrescriptionVoided {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "只有已完成的处方需要修订，当前状态为【" + prescriptionStatusName(status) + "】"})
+		return
+	}
+
+	var pendingID int
+	err = tx.QueryRow("SELECT id FROM prescriptions WHERE amended_from_id = ? AND status = ?",
+		id, models.PrescriptionDraft).Scan(&pendingID)
+	if err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该处方已有未完成的修订处方", "id": pendingID})
+		return
+	}
+	if err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建修订处方失败"})
+		return
+	}
+
+	// 修订草稿按当前价格重新计价，不沿用原处方的金额；坚持用药原因须由修订医生重新填写
+	var amendment models.Prescription
+	err = tx.QueryRow("SELECT prescription_type, herbal_doses, decoction_method FROM prescriptions WHERE id = ?", id).Scan(
+		&amendment.PrescriptionType, &amendment.HerbalDoses, &amendment.DecoctionMethod)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建修订处方失败"})
+		return
+	}
+	sourceItems, err := loadSourcePrescriptionItems(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方明细失败"})
+		return
+	}
+	// 已删除或未关联药品库的明细无法重新计价，不带入修订处方，在 warnings 中提示
+	var warnings []models.PrescriptionWarning
+	amendment.Items, warnings, err = dropDeletedMedicineItems(tx, sourceItems, "未带入修订处方")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询药品失败"})
+		return
+	}
+	if err := pricePrescription(tx, &amendment); err != nil {
+		respondPrescriptionError(c, err, "创建修订处方失败")
+		return
+	}
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO prescriptions (patient_id, doctor_id, diagnosis, doctor_advice, total_amount, status, notes, amended_from_id,
+		allergy_override_reason, allergy_override_by, patient_weight, prescription_type, herbal_doses, decoction_method,
+		prescription_form, encounter_id, created_at, updated_at)
+		SELECT patient_id, ?, diagnosis, doctor_advice, ?, ?, notes, id, '', 0,
+		patient_weight, prescription_type, herbal_doses, decoction_method, prescription_form, encounter_id, ?, ?
+		FROM prescriptions WHERE id = ?`,
+		currentUserID(c), amendment.TotalAmount, models.PrescriptionDraft, now, now, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建修订处方失败"})
+		return
+	}
+	amendmentID, _ := result.LastInsertId()
+
+	if err := copyRecordDiagnoses(tx, models.DiagnosisRecordPrescription, int64(id), amendmentID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "复制处方诊断失败"})
+		return
+	}
+
+	if err := insertPrescriptionItems(tx, amendmentID, amendment.Items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "复制处方明细失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建修订处方失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "修订处方已创建",
+		"id":       amendmentID,
+		"warnings": warnings,
+	})
+}
+
+// loadSourcePrescriptionItems 查询修订、复制所依据的处方明细，不含金额；未关联药品库的明细药品ID为0
+func loadSourcePrescriptionItems(tx *sql.Tx, prescriptionID int) ([]models.PrescriptionItem, error) {
+	rows, err := tx.Query(`
+		SELECT medicine_id, medicine_name, specification, dosage, usage, frequency, days, quantity, herb_grams, special_processing
+		FROM prescription_items WHERE prescription_id = ? ORDER BY id`, prescriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.PrescriptionItem
+	for rows.Next() {
+		var item models.PrescriptionItem
+		var medicineID sql.NullInt64
+		err := rows.Scan(&medicineID, &item.MedicineName, &item.Specification,
+			&item.Dosage, &item.Usage, &item.Frequency, &item.Days, &item.Quantity, &item.HerbGrams, &item.SpecialProcessing)
+		if err != nil {
+			return nil, err
+		}
+		item.MedicineID = int(medicineID.Int64)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// dropDeletedMedicineItems 去掉药品已删除或未关联药品库的明细，每条去掉的明细生成一条提示，
+// note 说明明细的去向，如“未复制”
+func dropDeletedMedicineItems(tx *sql.Tx, items []models.PrescriptionItem, note string) ([]models.PrescriptionItem, []models.PrescriptionWarning, error) {
+	kept := []models.PrescriptionItem{}
+	warnings := []models.PrescriptionWarning{}
+	for _, item := range items {
+		exists := 0
+		if item.MedicineID > 0 {
+			err := tx.QueryRow("SELECT COUNT(*) FROM medicines WHERE id = ?", item.MedicineID).Scan(&exists)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if exists == 0 {
+			warnings = append(warnings, models.PrescriptionWarning{
+				Type:         "medicine_deleted",
+				MedicineID:   item.MedicineID,
+				MedicineName: item.MedicineName,
+				Message:      fmt.Sprintf("药品【%s】已不在药品库中，%s", item.MedicineName, note),
+			})
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept, warnings, nil
+}
+
+// Clone 以患者的既往处方为基础生成新的草稿处方，按当前价格重新计价；
+// 已删除的药品不复制，库存不足的药品照常复制，两者均在 warnings 中提示
+func (pc *PrescriptionController) Clone(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的处方ID"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "复制处方失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var prescription models.Prescription
+	err = tx.QueryRow(`
+		SELECT patient_id, diagnosis, doctor_advice, notes, prescription_type, herbal_doses, decoction_method
+		FROM prescriptions WHERE id = ?`, id).Scan(
+		&prescription.PatientID, &prescription.Diagnosis, &prescription.DoctorAdvice, &prescription.Notes,
+		&prescription.PrescriptionType, &prescription.HerbalDoses, &prescription.DecoctionMethod)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "处方不存在"})
+		return
+	}
+	prescription.DoctorID = currentUserID(c)
+
+	prescription.Diagnoses, err = loadRecordDiagnoses(tx, models.DiagnosisRecordPrescription, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方诊断失败"})
+		return
+	}
+
+	// 未关联药品库的明细同样读出，在 warnings 中提示未复制
+	sourceItems, err := loadSourcePrescriptionItems(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方明细失败"})
+		return
+	}
+
+	sourceItems, warnings, err := dropDeletedMedicineItems(tx, sourceItems, "未复制")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询药品失败"})
+		return
+	}
+	for _, item := range sourceItems {
+		stock, err := availableStock(tx, item.MedicineID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询库存失败"})
+			return
+		}
+		if stock < item.Quantity {
+			warnings = append(warnings, models.PrescriptionWarning{
+				Type:         "insufficient_stock",
+				MedicineID:   item.MedicineID,
+				MedicineName: item.MedicineName,
+				Message:      fmt.Sprintf("药品【%s】可用库存 %d，不足 %d", item.MedicineName, stock, item.Quantity),
+			})
+		}
+		prescription.Items = append(prescription.Items, item)
+	}
+
+	// 按当前价格重新计价
+	if err := pricePrescription(tx, &prescription); err != nil {
+		respondPrescriptionError(c, err, "复制处方失败")
+		return
+	}
+
+	// 用药安全提示仅供医生确认，不阻止生成草稿
+	checkWarnings, err := collectPrescriptionWarnings(tx, &prescription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "用药安全审核失败"})
+		return
+	}
+	warnings = append(warnings, checkWarnings...)
+
+	newID, err := insertDraftPrescription(tx, &prescription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "复制处方失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "复制处方失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "已复制为新的草稿处方",
+		"id":           newID,
+		"total_amount": prescription.TotalAmount,
+		"warnings":     warnings,
+	})
+}
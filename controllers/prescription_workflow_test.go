@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"lighthospital/models"
+	"testing"
+)
+
+func TestTransitionPrescription(t *testing.T) {
+	statuses := []string{
+		models.PrescriptionDraft,
+		models.PrescriptionCompleted,
+		models.PrescriptionPrinted,
+		models.PrescriptionApproved,
+		models.PrescriptionRejected,
+		models.PrescriptionDispensed,
+		models.PrescriptionVoided,
+	}
+	// 与 models/prescription.go 中的流转表一致，未列出的均不允许
+	allowed := map[string][]string{
+		models.PrescriptionDraft:     {models.PrescriptionCompleted, models.PrescriptionVoided},
+		models.PrescriptionCompleted: {models.PrescriptionApproved, models.PrescriptionRejected, models.PrescriptionPrinted, models.PrescriptionVoided},
+		models.PrescriptionPrinted:   {models.PrescriptionApproved, models.PrescriptionRejected, models.PrescriptionVoided},
+		models.PrescriptionApproved:  {models.PrescriptionDispensed, models.PrescriptionVoided},
+		models.PrescriptionRejected:  {models.PrescriptionVoided},
+		models.PrescriptionDispensed: {models.PrescriptionPrinted, models.PrescriptionVoided},
+	}
+	isAllowed := func(from, to string) bool {
+		for _, next := range allowed[from] {
+			if next == to {
+				return true
+			}
+		}
+		return false
+	}
+
+	db := openTestDB(t)
+	medicineID, _ := createTestMedicine(t, db, "阿莫西林胶囊", testBatch{"", 10})
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			from, to := from, to
+			t.Run(from+"→"+to, func(t *testing.T) {
+				want := isAllowed(from, to)
+				if got := models.CanTransitionPrescription(from, to); got != want {
+					t.Fatalf("CanTransitionPrescription(%s, %s) = %v，应为 %v", from, to, got, want)
+				}
+
+				// 草稿、已作废的处方未扣库存，其余状态已在完成时扣减
+				deducted := from != models.PrescriptionDraft && from != models.PrescriptionVoided
+				prescriptionID := createTestPrescription(t, db, from, false, map[int]int{medicineID: 4})
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer tx.Rollback()
+				if deducted {
+					if err := deductPrescriptionStock(tx, prescriptionID, 1); err != nil {
+						t.Fatal(err)
+					}
+					if _, err := tx.Exec("UPDATE prescriptions SET stock_deducted = 1 WHERE id = ?", prescriptionID); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				err = transitionPrescription(tx, prescriptionID, to, 1)
+				if !want {
+					if _, ok := err.(*prescriptionError); !ok {
+						t.Fatalf("不允许的流转应返回处方错误，得到 %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var status string
+				var stockDeducted bool
+				err = tx.QueryRow("SELECT status, stock_deducted FROM prescriptions WHERE id = ?", prescriptionID).Scan(&status, &stockDeducted)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status != to {
+					t.Errorf("状态 %s，应为 %s", status, to)
+				}
+				// 完成时扣减库存，作废时归还，其余流转不影响库存
+				wantDeducted := to != models.PrescriptionVoided && (deducted || to == models.PrescriptionCompleted)
+				if stockDeducted != wantDeducted {
+					t.Errorf("stock_deducted 为 %v，应为 %v", stockDeducted, wantDeducted)
+				}
+				var stock int
+				if err := tx.QueryRow("SELECT stock FROM medicines WHERE id = ?", medicineID).Scan(&stock); err != nil {
+					t.Fatal(err)
+				}
+				wantStock := 10
+				if wantDeducted {
+					wantStock = 6
+				}
+				if stock != wantStock {
+					t.Errorf("药品库存 %d，应为 %d", stock, wantStock)
+				}
+			})
+		}
+	}
+}
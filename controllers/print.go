@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"database/sql"
 	"lighthospital/database"
 	"lighthospital/models"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jung-kurt/gofpdf"
@@ -21,37 +23,38 @@ func (pc *PrintController) PrintPrescription(c *gin.Context) {
 
 	// 查询处方信息
 	var prescription models.Prescription
+	var doctorName sql.NullString
 	err = database.DB.QueryRow(`
 		SELECT p.id, p.patient_id, p.doctor_id, p.diagnosis, p.doctor_advice, p.total_amount, p.status, p.notes, p.created_at, p.updated_at,
+		       p.prescription_type, p.herbal_doses, p.decoction_method, p.prescription_no, p.prescription_form,
 		       u.name as doctor_name
 		FROM prescriptions p
 		LEFT JOIN users u ON p.doctor_id = u.id
 		WHERE p.id = ?`, id).Scan(
 		&prescription.ID, &prescription.PatientID, &prescription.DoctorID, &prescription.Diagnosis, &prescription.DoctorAdvice,
 		&prescription.TotalAmount, &prescription.Status, &prescription.Notes, &prescription.CreatedAt, &prescription.UpdatedAt,
-		&prescription.Doctor.Name)
+		&prescription.PrescriptionType, &prescription.HerbalDoses, &prescription.DecoctionMethod, &prescription.PrescriptionNo,
+		&prescription.PrescriptionForm, &doctorName)
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "处方不存在"})
 		return
 	}
+	prescription.Doctor = &models.User{ID: prescription.DoctorID, Name: doctorName.String}
 
 	// 查询患者信息
-	var patient models.Patient
-	err = database.DB.QueryRow(`
-		SELECT id, name, gender, age, phone, address, id_card, medical_history, created_at, updated_at
-		FROM patients WHERE id = ?`, prescription.PatientID).Scan(
-		&patient.ID, &patient.Name, &patient.Gender, &patient.Age, &patient.Phone,
-		&patient.Address, &patient.IDCard, &patient.MedicalHistory, &patient.CreatedAt, &patient.UpdatedAt)
+	patient, err := scanPatient(database.DB.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id = ?", prescription.PatientID))
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
 		return
 	}
+	maskPatient(c, &patient)
 
 	// 查询处方明细
 	rows, err := database.DB.Query(`
-		SELECT id, prescription_id, medicine_id, medicine_name, specification, dosage, usage, frequency, days, quantity, unit_price, total_price
+		SELECT id, prescription_id, medicine_id, medicine_name, specification, dosage, usage, frequency, days, quantity, unit_price, total_price,
+		       herb_grams, special_processing
 		FROM prescription_items WHERE prescription_id = ? ORDER BY id`, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方明细失败"})
@@ -63,13 +66,20 @@ func (pc *PrintController) PrintPrescription(c *gin.Context) {
 		var item models.PrescriptionItem
 		err := rows.Scan(
 			&item.ID, &item.PrescriptionID, &item.MedicineID, &item.MedicineName, &item.Specification,
-			&item.Dosage, &item.Usage, &item.Frequency, &item.Days, &item.Quantity, &item.UnitPrice, &item.TotalPrice)
+			&item.Dosage, &item.Usage, &item.Frequency, &item.Days, &item.Quantity, &item.UnitPrice, &item.TotalPrice,
+			&item.HerbGrams, &item.SpecialProcessing)
 		if err != nil {
 			continue
 		}
 		prescription.Items = append(prescription.Items, item)
 	}
 
+	prescription.Diagnoses, err = loadRecordDiagnoses(database.DB, models.DiagnosisRecordPrescription, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方诊断失败"})
+		return
+	}
+
 	// 生成PDF
 	pdf := generatePrescriptionPDF(prescription, patient)
 
@@ -85,14 +95,59 @@ func (pc *PrintController) PrintPrescription(c *gin.Context) {
 	}
 }
 
+// writePrescriptionFormHeader 按处方笺类别绘制底色和右上角标注：
+// 麻醉药品和第一类精神药品处方为淡红色并标注“麻、精一”，第二类精神药品处方标注“精二”，普通处方标注“普通”
+func writePrescriptionFormHeader(pdf *gofpdf.Fpdf, form string) {
+	label := "普通"
+	width, height := pdf.GetPageSize()
+	switch form {
+	case models.PrescriptionFormNarcotic:
+		label = "麻、精一"
+		pdf.SetFillColor(255, 225, 225)
+		pdf.Rect(0, 0, width, height, "F")
+	case models.PrescriptionFormPsychotropic2:
+		label = "精二"
+	}
+
+	x, y := pdf.GetXY()
+	_, _, right, _ := pdf.GetMargins()
+	pdf.SetFont("Arial", "B", 12)
+	pdf.SetXY(width-right-30, 8)
+	pdf.CellFormat(30, 8, label, "1", 0, "C", false, 0, "")
+	pdf.SetXY(x, y)
+}
+
+// printedBirthDate 打印用的出生日期，按年龄推算的出生日期只打印年份并注明“约”
+func printedBirthDate(patient models.Patient) string {
+	if patient.BirthDate == "" {
+		return ""
+	}
+	if patient.BirthDateEstimated {
+		return "约" + patient.BirthDate[:4] + "年"
+	}
+	return patient.BirthDate
+}
+
 func generatePrescriptionPDF(prescription models.Prescription, patient models.Patient) *gofpdf.Fpdf {
 	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetHeaderFunc(func() { writePrescriptionFormHeader(pdf, prescription.PrescriptionForm) })
 	pdf.AddPage()
 	pdf.SetFont("Arial", "B", 16)
 
 	// 标题
-	pdf.Cell(0, 10, "处方笺")
-	pdf.Ln(15)
+	title := "处方笺"
+	if prescription.PrescriptionType == models.PrescriptionHerbal {
+		title = "中药处方笺"
+	}
+	pdf.Cell(0, 10, title)
+	pdf.Ln(10)
+
+	// 处方编号，草稿尚未分配编号
+	if prescription.PrescriptionNo != "" {
+		pdf.SetFont("Arial", "", 10)
+		pdf.Cell(0, 6, "处方编号: "+prescription.PrescriptionNo)
+	}
+	pdf.Ln(5)
 
 	// 患者信息
 	pdf.SetFont("Arial", "B", 12)
@@ -102,7 +157,8 @@ func generatePrescriptionPDF(prescription models.Prescription, patient models.Pa
 	pdf.SetFont("Arial", "", 10)
 	pdf.Cell(40, 6, "姓名: "+patient.Name)
 	pdf.Cell(40, 6, "性别: "+patient.Gender)
-	pdf.Cell(40, 6, "年龄: "+strconv.Itoa(patient.Age))
+	pdf.Cell(40, 6, "年龄: "+patient.AgeText)
+	pdf.Cell(40, 6, "出生日期: "+printedBirthDate(patient))
 	pdf.Ln(8)
 
 	pdf.Cell(40, 6, "电话: "+patient.Phone)
@@ -118,8 +174,8 @@ func generatePrescriptionPDF(prescription models.Prescription, patient models.Pa
 	pdf.Ln(10)
 
 	pdf.SetFont("Arial", "", 10)
-	pdf.Cell(0, 6, prescription.Diagnosis)
-	pdf.Ln(10)
+	pdf.MultiCell(0, 6, formatDiagnoses(prescription.Diagnoses, prescription.Diagnosis), "", "L", false)
+	pdf.Ln(4)
 
 	// 医嘱信息
 	if prescription.DoctorAdvice != "" {
@@ -132,6 +188,39 @@ func generatePrescriptionPDF(prescription models.Prescription, patient models.Pa
 		pdf.Ln(10)
 	}
 
+	if prescription.PrescriptionType == models.PrescriptionHerbal {
+		writeHerbalItemsPDF(pdf, prescription)
+	} else {
+		writeMedicineItemsPDF(pdf, prescription)
+	}
+
+	// 总计
+	pdf.SetFont("Arial", "B", 10)
+	pdf.Cell(0, 8, "总计: ¥"+strconv.FormatFloat(prescription.TotalAmount, 'f', 2, 64))
+	pdf.Ln(15)
+
+	// 医生信息
+	pdf.SetFont("Arial", "", 10)
+	pdf.Cell(40, 6, "医生: "+prescription.Doctor.Name)
+	pdf.Cell(40, 6, "日期: "+prescription.CreatedAt.Format("2006-01-02"))
+	pdf.Ln(10)
+
+	// 备注
+	if prescription.Notes != "" {
+		pdf.SetFont("Arial", "B", 10)
+		pdf.Cell(0, 8, "备注")
+		pdf.Ln(8)
+
+		pdf.SetFont("Arial", "", 10)
+		pdf.Cell(0, 6, prescription.Notes)
+		pdf.Ln(10)
+	}
+
+	return pdf
+}
+
+// writeMedicineItemsPDF 输出西药、中成药处方明细表
+func writeMedicineItemsPDF(pdf *gofpdf.Fpdf, prescription models.Prescription) {
 	// 药品明细
 	pdf.SetFont("Arial", "B", 12)
 	pdf.Cell(0, 8, "药品明细")
@@ -164,30 +253,40 @@ func generatePrescriptionPDF(prescription models.Prescription, patient models.Pa
 	}
 
 	pdf.Ln(5)
+}
 
-	// 总计
-	pdf.SetFont("Arial", "B", 10)
-	pdf.Cell(0, 8, "总计: ¥"+strconv.FormatFloat(prescription.TotalAmount, 'f', 2, 64))
-	pdf.Ln(15)
-
-	// 医生信息
-	pdf.SetFont("Arial", "", 10)
-	pdf.Cell(40, 6, "医生: "+prescription.Doctor.Name)
-	pdf.Cell(40, 6, "日期: "+prescription.CreatedAt.Format("2006-01-02"))
+// writeHerbalItemsPDF 按中药处方笺格式输出饮片：每行四味药，药名后标注克数及先煎、后下等脚注，
+// 其后注明剂数和煎服法
+func writeHerbalItemsPDF(pdf *gofpdf.Fpdf, prescription models.Prescription) {
+	pdf.SetFont("Arial", "B", 12)
+	pdf.Cell(0, 8, "Rp. 中药饮片")
 	pdf.Ln(10)
 
-	// 备注
-	if prescription.Notes != "" {
-		pdf.SetFont("Arial", "B", 10)
-		pdf.Cell(0, 8, "备注")
-		pdf.Ln(8)
+	pdf.SetFont("Arial", "", 10)
+	for i, item := range prescription.Items {
+		text := item.MedicineName + " " + strconv.FormatFloat(item.HerbGrams, 'f', -1, 64) + "g"
+		if item.SpecialProcessing != "" {
+			text += "(" + item.SpecialProcessing + ")"
+		}
+		pdf.Cell(45, 7, text)
+		if (i+1)%4 == 0 {
+			pdf.Ln(7)
+		}
+	}
+	if len(prescription.Items)%4 != 0 {
+		pdf.Ln(7)
+	}
+	pdf.Ln(3)
 
+	pdf.SetFont("Arial", "B", 10)
+	pdf.Cell(40, 7, "共 "+strconv.Itoa(prescription.HerbalDoses)+" 剂")
+	pdf.Ln(8)
+	if prescription.DecoctionMethod != "" {
 		pdf.SetFont("Arial", "", 10)
-		pdf.Cell(0, 6, prescription.Notes)
-		pdf.Ln(10)
+		pdf.Cell(0, 6, "煎服法: "+prescription.DecoctionMethod)
+		pdf.Ln(8)
 	}
-
-	return pdf
+	pdf.Ln(2)
 }
 
 func (pc *PrintController) PrintAppointment(c *gin.Context) {
@@ -198,10 +297,10 @@ func (pc *PrintController) PrintAppointment(c *gin.Context) {
 	}
 
 	// 查询预约信息
-	var appointment models.Appointment
+	appointment := models.Appointment{Patient: &models.Patient{}, Doctor: &models.User{}}
 	err = database.DB.QueryRow(`
 		SELECT a.id, a.patient_id, a.doctor_id, a.appointment_time, a.duration, a.status, a.notes, a.created_at, a.updated_at,
-		       p.name as patient_name, u.name as doctor_name
+		       COALESCE(p.name, '') as patient_name, COALESCE(u.name, '') as doctor_name
 		FROM appointments a
 		LEFT JOIN patients p ON a.patient_id = p.id
 		LEFT JOIN users u ON a.doctor_id = u.id
@@ -269,3 +368,198 @@ func generateAppointmentPDF(appointment models.Appointment) *gofpdf.Fpdf {
 
 	return pdf
 }
+
+func (pc *PrintController) PrintEncounter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的病历ID"})
+		return
+	}
+
+	// 查询病历信息
+	encounter, err := loadEncounter(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "病历不存在"})
+		return
+	}
+
+	// 查询患者信息
+	patient, err := scanPatient(database.DB.QueryRow("SELECT "+patientColumns+" FROM patients WHERE id = ?", encounter.PatientID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+	maskPatient(c, &patient)
+
+	// 查询本次就诊开具的有效处方及明细
+	prescriptions, err := loadEncounterPrescriptions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询病历处方失败"})
+		return
+	}
+	for i := range prescriptions {
+		rows, err := database.DB.Query(`
+			SELECT medicine_name, specification, dosage, usage, frequency, days, quantity, herb_grams, special_processing
+			FROM prescription_items WHERE prescription_id = ? ORDER BY id`, prescriptions[i].ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询处方明细失败"})
+			return
+		}
+		for rows.Next() {
+			var item models.PrescriptionItem
+			err := rows.Scan(&item.MedicineName, &item.Specification, &item.Dosage, &item.Usage, &item.Frequency,
+				&item.Days, &item.Quantity, &item.HerbGrams, &item.SpecialProcessing)
+			if err != nil {
+				continue
+			}
+			prescriptions[i].Items = append(prescriptions[i].Items, item)
+		}
+		rows.Close()
+	}
+	encounter.Prescriptions = prescriptions
+
+	encounter.VitalSigns, err = queryVitalSigns("WHERE v.encounter_id = ? ORDER BY v.measured_at, v.id", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询生命体征失败"})
+		return
+	}
+
+	// 生成PDF
+	pdf := generateEncounterPDF(*encounter, patient)
+
+	// 设置响应头
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", "attachment; filename=encounter_"+strconv.Itoa(id)+".pdf")
+
+	// 输出PDF
+	err = pdf.Output(c.Writer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成PDF失败"})
+		return
+	}
+}
+
+func generateEncounterPDF(encounter models.Encounter, patient models.Patient) *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	pdf.SetFont("Arial", "B", 16)
+
+	// 标题
+	pdf.Cell(0, 10, "门诊病历")
+	pdf.Ln(15)
+
+	// 患者信息
+	pdf.SetFont("Arial", "", 10)
+	pdf.Cell(40, 6, "姓名: "+patient.Name)
+	pdf.Cell(30, 6, "性别: "+patient.Gender)
+	pdf.Cell(30, 6, "年龄: "+patient.AgeText)
+	pdf.Cell(0, 6, "电话: "+patient.Phone)
+	pdf.Ln(8)
+
+	pdf.Cell(70, 6, "就诊时间: "+encounter.VisitTime.Format("2006-01-02 15:04"))
+	pdf.Cell(0, 6, "接诊医生: "+encounter.DoctorName)
+	pdf.Ln(10)
+
+	// SOAP 各项记录
+	sections := []struct {
+		title   string
+		content string
+	}{
+		{"主诉", encounter.ChiefComplaint},
+		{"现病史", encounter.PresentIllness},
+		{"既往史", encounter.PastHistory},
+		{"生命体征", formatVitalSigns(encounter.VitalSigns)},
+		{"体格检查", encounter.PhysicalExam},
+		{"诊断", formatDiagnoses(encounter.Diagnoses, encounter.Diagnosis)},
+		{"处理意见", encounter.Plan},
+	}
+	for _, section := range sections {
+		if section.content == "" {
+			continue
+		}
+		pdf.SetFont("Arial", "B", 10)
+		pdf.Cell(0, 8, section.title)
+		pdf.Ln(8)
+
+		pdf.SetFont("Arial", "", 10)
+		pdf.MultiCell(0, 6, section.content, "", "L", false)
+		pdf.Ln(2)
+	}
+
+	// 本次就诊开具的处方，作废的处方不打印
+	printed := false
+	for _, prescription := range encounter.Prescriptions {
+		if prescription.Status == models.PrescriptionVoided || len(prescription.Items) == 0 {
+			continue
+		}
+		if !printed {
+			pdf.SetFont("Arial", "B", 10)
+			pdf.Cell(0, 8, "处方")
+			pdf.Ln(8)
+			printed = true
+		}
+
+		pdf.SetFont("Arial", "", 10)
+		if prescription.PrescriptionNo != "" {
+			pdf.Cell(0, 6, "处方编号: "+prescription.PrescriptionNo)
+			pdf.Ln(6)
+		}
+		for _, item := range prescription.Items {
+			line := item.MedicineName + " " + item.Specification
+			if prescription.PrescriptionType == models.PrescriptionHerbal {
+				line += " " + item.Dosage + " " + item.SpecialProcessing
+			} else {
+				line += " " + item.Dosage + " " + item.Frequency + " " + item.Usage + " ×" + strconv.Itoa(item.Quantity)
+			}
+			pdf.Cell(0, 6, "    "+line)
+			pdf.Ln(6)
+		}
+		pdf.Ln(2)
+	}
+
+	// 医生签名
+	pdf.Ln(10)
+	signature := "医生签名: "
+	if encounter.Status == models.EncounterSigned {
+		signature += encounter.DoctorName
+		if encounter.SignedAt != nil {
+			signature += "    签名时间: " + encounter.SignedAt.Format("2006-01-02 15:04")
+		}
+	} else {
+		signature += "（未签名）"
+	}
+	pdf.Cell(0, 6, signature)
+
+	return pdf
+}
+
+// formatVitalSigns 将本次就诊测量的生命体征逐条格式化为 T、BP、P、R 等简写
+func formatVitalSigns(vitals []models.VitalSign) string {
+	lines := []string{}
+	for _, vital := range vitals {
+		parts := []string{vital.MeasuredAt.Format("15:04")}
+		if vital.Temperature > 0 {
+			parts = append(parts, "T "+strconv.FormatFloat(vital.Temperature, 'f', -1, 64)+"℃")
+		}
+		if vital.SystolicBP > 0 {
+			parts = append(parts, "BP "+strconv.Itoa(vital.SystolicBP)+"/"+strconv.Itoa(vital.DiastolicBP)+"mmHg")
+		}
+		if vital.Pulse > 0 {
+			parts = append(parts, "P "+strconv.Itoa(vital.Pulse)+"次/分")
+		}
+		if vital.Respiration > 0 {
+			parts = append(parts, "R "+strconv.Itoa(vital.Respiration)+"次/分")
+		}
+		if vital.SpO2 > 0 {
+			parts = append(parts, "SpO2 "+strconv.Itoa(vital.SpO2)+"%")
+		}
+		if vital.Weight > 0 {
+			parts = append(parts, "体重 "+strconv.FormatFloat(vital.Weight, 'f', -1, 64)+"kg")
+		}
+		if vital.Height > 0 {
+			parts = append(parts, "身高 "+strconv.FormatFloat(vital.Height, 'f', -1, 64)+"cm")
+		}
+		lines = append(lines, strings.Join(parts, "  "))
+	}
+	return strings.Join(lines, "\n")
+}
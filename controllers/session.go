@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// currentUserID 获取当前登录用户ID
+func currentUserID(c *gin.Context) int {
+	session := sessions.Default(c)
+	userID, _ := session.Get("user_id").(int)
+	return userID
+}
+
+// currentUserRole 获取当前登录用户角色
+func currentUserRole(c *gin.Context) string {
+	session := sessions.Default(c)
+	role, _ := session.Get("user_role").(string)
+	return role
+}
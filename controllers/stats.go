@@ -40,9 +40,9 @@ func (sc *StatsController) GetStats(c *gin.Context) {
 	}
 	stats["total_medicines"] = totalMedicines
 
-	// 获取总处方数
+	// 获取总处方数，作废的处方（含被修订替代的原处方）不计入
 	var totalPrescriptions int
-	err = sc.DB.QueryRow("SELECT COUNT(*) FROM prescriptions").Scan(&totalPrescriptions)
+	err = sc.DB.QueryRow("SELECT COUNT(*) FROM prescriptions WHERE status != 'voided'").Scan(&totalPrescriptions)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取处方统计失败"})
 		return
@@ -65,10 +65,18 @@ func (sc *StatsController) GetStats(c *gin.Context) {
 	}
 	stats["medicine_categories"] = medicineCategories
 
+	// 获取主要诊断排行
+	topDiagnoses, err := sc.getTopDiagnosisStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取诊断统计失败"})
+		return
+	}
+	stats["top_diagnoses"] = topDiagnoses
+
 	c.JSON(http.StatusOK, gin.H{"stats": stats})
 }
 
-// getPrescriptionWeeklyStats 获取本周处方统计
+// getPrescriptionWeeklyStats 获取本周每天未作废的处方数量
 func (sc *StatsController) getPrescriptionWeeklyStats() ([]int, error) {
 	// 获取本周的开始日期（周一）
 	now := time.Now()
@@ -88,7 +96,7 @@ func (sc *StatsController) getPrescriptionWeeklyStats() ([]int, error) {
 		var count int
 		err := sc.DB.QueryRow(`
 			SELECT COUNT(*) FROM prescriptions 
-			WHERE created_at >= ? AND created_at < ?
+			WHERE created_at >= ? AND created_at < ? AND status != 'voided'
 		`, dayStart, dayEnd).Scan(&count)
 		if err != nil {
 			return nil, err
@@ -136,3 +144,36 @@ func (sc *StatsController) getMedicineCategoryStats() ([]map[string]interface{},
 
 	return categories, nil
 }
+
+// getTopDiagnosisStats 按ICD-10主要诊断统计未作废处方数量，取前10
+func (sc *StatsController) getTopDiagnosisStats() ([]map[string]interface{}, error) {
+	rows, err := sc.DB.Query(`
+		SELECT rd.code, MAX(rd.name), COUNT(*) as count
+		FROM record_diagnoses rd
+		JOIN prescriptions p ON rd.record_id = p.id
+		WHERE rd.record_type = 'prescription' AND rd.is_primary = 1 AND p.status != 'voided'
+		GROUP BY rd.code
+		ORDER BY count DESC, rd.code
+		LIMIT 10
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diagnoses := []map[string]interface{}{}
+	for rows.Next() {
+		var code, name string
+		var count int
+		if err := rows.Scan(&code, &name, &count); err != nil {
+			return nil, err
+		}
+		diagnoses = append(diagnoses, map[string]interface{}{
+			"code":  code,
+			"name":  name,
+			"count": count,
+		})
+	}
+
+	return diagnoses, nil
+}
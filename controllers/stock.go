@@ -0,0 +1,598 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/database"
+	"lighthospital/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stockShortageError 库存不足错误
+type stockShortageError struct {
+	MedicineName string
+	Required     int
+	Available    int
+	Expired      int // 已过期、不可发放的库存
+}
+
+func (e *stockShortageError) Error() string {
+	msg := fmt.Sprintf("药品【%s】库存不足：需要 %d，当前可用库存 %d", e.MedicineName, e.Required, e.Available)
+	if e.Expired > 0 {
+		msg += fmt.Sprintf("（另有 %d 已过期，不可发放）", e.Expired)
+	}
+	return msg
+}
+
+// stockChange 一次库存变动
+type stockChange struct {
+	MedicineID   int
+	BatchID      int // 为0时入库记入无批号批次
+	Quantity     int // 入库为正、出库为负
+	MovementType string
+	UserID       int
+	Reason       string
+	ReferenceID  int
+}
+
+// applyStockChange 在事务内变动单个批次及药品总库存并写入库存流水，返回变动后的药品库存
+func applyStockChange(tx *sql.Tx, change stockChange) (int, error) {
+	var name string
+	var stock int
+	err := tx.QueryRow("SELECT name, stock FROM medicines WHERE id = ?", change.MedicineID).Scan(&name, &stock)
+	if err != nil {
+		return 0, err
+	}
+
+	if change.BatchID == 0 {
+		if change.Quantity < 0 {
+			return 0, fmt.Errorf("出库必须指定批次")
+		}
+		change.BatchID, err = defaultBatchID(tx, change.MedicineID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var batchQuantity int
+	err = tx.QueryRow("SELECT quantity FROM medicine_batches WHERE id = ? AND medicine_id = ?",
+		change.BatchID, change.MedicineID).Scan(&batchQuantity)
+	if err != nil {
+		return 0, err
+	}
+	if batchQuantity+change.Quantity < 0 {
+		return 0, &stockShortageError{MedicineName: name, Required: -change.Quantity, Available: batchQuantity}
+	}
+
+	balance := stock + change.Quantity
+	now := time.Now()
+	_, err = tx.Exec("UPDATE medicine_batches SET quantity = quantity + ?, updated_at = ? WHERE id = ?",
+		change.Quantity, now, change.BatchID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE medicines SET stock = ?, updated_at = ? WHERE id = ?", balance, now, change.MedicineID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO stock_movements (medicine_id, batch_id, movement_type, quantity, balance, user_id, reason, reference_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		change.MedicineID, change.BatchID, change.MovementType, change.Quantity, balance,
+		change.UserID, change.Reason, change.ReferenceID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return balance, nil
+}
+
+// defaultBatchID 获取药品的无批号批次，不存在时创建
+func defaultBatchID(tx *sql.Tx, medicineID int) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+		SELECT id FROM medicine_batches
+		WHERE medicine_id = ? AND batch_no = '' AND expiry_date IS NULL
+		ORDER BY id LIMIT 1`, medicineID).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO medicine_batches (medicine_id, batch_no, expiry_date, quantity, created_at, updated_at)
+		VALUES (?, '', NULL, 0, ?, ?)`, medicineID, now, now)
+	if err != nil {
+		return 0, err
+	}
+	newID, _ := result.LastInsertId()
+	return int(newID), nil
+}
+
+// consumeMedicineStock 按近效期先出（FEFO）从各批次扣减库存，includeExpired 为 false 时跳过过期批次
+func consumeMedicineStock(tx *sql.Tx, change stockChange, includeExpired bool) error {
+	required := -change.Quantity
+	today := time.Now().Format("2006-01-02")
+
+	var name string
+	err := tx.QueryRow("SELECT name FROM medicines WHERE id = ?", change.MedicineID).Scan(&name)
+	if err != nil {
+		return err
+	}
+
+	// 未登记有效期的批次排在最后
+	rows, err := tx.Query(`
+		SELECT id, quantity, COALESCE(expiry_date, '')
+		FROM medicine_batches
+		WHERE medicine_id = ? AND quantity > 0
+		ORDER BY expiry_date IS NULL, expiry_date, id`, change.MedicineID)
+	if err != nil {
+		return err
+	}
+
+	type batchStock struct {
+		ID       int
+		Quantity int
+	}
+	var usable []batchStock
+	available, expired := 0, 0
+	for rows.Next() {
+		var batch batchStock
+		var expiryDate string
+		if err := rows.Scan(&batch.ID, &batch.Quantity, &expiryDate); err != nil {
+			rows.Close()
+			return err
+		}
+		if !includeExpired && expiryDate != "" && expiryDate < today {
+			expired += batch.Quantity
+			continue
+		}
+		usable = append(usable, batch)
+		available += batch.Quantity
+	}
+	rows.Close()
+
+	if available < required {
+		return &stockShortageError{MedicineName: name, Required: required, Available: available, Expired: expired}
+	}
+
+	for _, batch := range usable {
+		if required == 0 {
+			break
+		}
+		take := batch.Quantity
+		if take > required {
+			take = required
+		}
+		part := change
+		part.BatchID = batch.ID
+		part.Quantity = -take
+		if _, err := applyStockChange(tx, part); err != nil {
+			return err
+		}
+		required -= take
+	}
+	return nil
+}
+
+// changeMedicineStock 变动药品库存：未指定批次的出库按近效期先出从各批次扣减（含过期批次），返回变动后的药品库存
+func changeMedicineStock(tx *sql.Tx, change stockChange) (int, error) {
+	if change.Quantity < 0 && change.BatchID == 0 {
+		if err := consumeMedicineStock(tx, change, true); err != nil {
+			return 0, err
+		}
+		var stock int
+		err := tx.QueryRow("SELECT stock FROM medicines WHERE id = ?", change.MedicineID).Scan(&stock)
+		return stock, err
+	}
+	return applyStockChange(tx, change)
+}
+
+// availableStock 查询药品未过期批次的可用库存
+func availableStock(tx *sql.Tx, medicineID int) (int, error) {
+	var stock int
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(quantity), 0) FROM medicine_batches
+		WHERE medicine_id = ? AND quantity > 0 AND (expiry_date IS NULL OR expiry_date >= ?)`,
+		medicineID, time.Now().Format("2006-01-02")).Scan(&stock)
+	return stock, err
+}
+
+// prescriptionStockItem 处方中需要扣减库存的药品数量
+type prescriptionStockItem struct {
+	MedicineID   int
+	MedicineName string
+	Quantity     int
+}
+
+// loadPrescriptionStockItems 按药品汇总处方明细数量，未关联药品库的明细不参与库存计算
+func loadPrescriptionStockItems(tx *sql.Tx, prescriptionID int) ([]prescriptionStockItem, error) {
+	rows, err := tx.Query(`
+		SELECT medicine_id, MAX(medicine_name), SUM(quantity)
+		FROM prescription_items
+		WHERE prescription_id = ? AND medicine_id IS NOT NULL AND medicine_id > 0
+		GROUP BY medicine_id
+		ORDER BY medicine_id`, prescriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []prescriptionStockItem
+	for rows.Next() {
+		var item prescriptionStockItem
+		if err := rows.Scan(&item.MedicineID, &item.MedicineName, &item.Quantity); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// deductPrescriptionStock 在事务内按近效期先出扣减处方所用药品库存，过期批次不参与发药，
+// 任一药品可用库存不足时返回 stockShortageError
+func deductPrescriptionStock(tx *sql.Tx, prescriptionID, userID int) error {
+	items, err := loadPrescriptionStockItems(tx, prescriptionID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		err := consumeMedicineStock(tx, stockChange{
+			MedicineID:   item.MedicineID,
+			Quantity:     -item.Quantity,
+			MovementType: models.MovementDispense,
+			UserID:       userID,
+			Reason:       "处方发药",
+			ReferenceID:  prescriptionID,
+		}, false)
+		if err == sql.ErrNoRows {
+			return &stockShortageError{MedicineName: item.MedicineName, Required: item.Quantity, Available: 0}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restorePrescriptionStock 在事务内按发药流水将处方已扣减的库存退回原批次，
+// 药品或批次已删除时返回 prescriptionError
+func restorePrescriptionStock(tx *sql.Tx, prescriptionID, userID int) error {
+	// 以该处方的发药与退药流水轧差，已部分退药的只退回剩余部分
+	rows, err := tx.Query(`
+		SELECT medicine_id, batch_id, SUM(quantity)
+		FROM stock_movements
+		WHERE reference_id = ? AND movement_type IN (?, ?)
+		GROUP BY medicine_id, batch_id
+		HAVING SUM(quantity) < 0
+		ORDER BY medicine_id, batch_id`,
+		prescriptionID, models.MovementDispense, models.MovementReturn)
+	if err != nil {
+		return err
+	}
+
+	var changes []stockChange
+	for rows.Next() {
+		var change stockChange
+		var net int
+		if err := rows.Scan(&change.MedicineID, &change.BatchID, &net); err != nil {
+			rows.Close()
+			return err
+		}
+		change.Quantity = -net
+		change.MovementType = models.MovementReturn
+		change.UserID = userID
+		change.Reason = "处方作废退药"
+		change.ReferenceID = prescriptionID
+		changes = append(changes, change)
+	}
+	rows.Close()
+
+	// 药品或发药批次已删除时无法退回，不能跳过这部分库存而作废成功
+	for _, change := range changes {
+		_, err := applyStockChange(tx, change)
+		if err == sql.ErrNoRows {
+			name := fmt.Sprintf("ID %d", change.MedicineID)
+			tx.QueryRow("SELECT medicine_name FROM prescription_items WHERE prescription_id = ? AND medicine_id = ?",
+				prescriptionID, change.MedicineID).Scan(&name)
+			return &prescriptionError{msg: fmt.Sprintf("药品【%s】或其发药批次已删除，无法退回库存", name)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPrescriptionReturn 校验关联处方的退药：处方须未作废且从该批次发出过该药品，退药数量不超过该批次未退回的数量
+func checkPrescriptionReturn(tx *sql.Tx, prescriptionID, medicineID, batchID, quantity int) (string, error) {
+	var status string
+	err := tx.QueryRow("SELECT status FROM prescriptions WHERE id = ?", prescriptionID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "关联的处方不存在", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if status == models.PrescriptionVoided {
+		return "处方已作废，库存已退回", nil
+	}
+	if batchID == 0 {
+		return "关联处方退药须指定发药批次", nil
+	}
+
+	var dispensed, net int
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(CASE WHEN movement_type = ? THEN -quantity ELSE 0 END), 0), COALESCE(-SUM(quantity), 0)
+		FROM stock_movements
+		WHERE reference_id = ? AND medicine_id = ? AND batch_id = ? AND movement_type IN (?, ?)`,
+		models.MovementDispense, prescriptionID, medicineID, batchID, models.MovementDispense, models.MovementReturn).Scan(&dispensed, &net)
+	if err != nil {
+		return "", err
+	}
+	if dispensed == 0 {
+		return "该处方未从此批次发出该药品", nil
+	}
+	if quantity > net {
+		return fmt.Sprintf("退药数量超过该处方此批次未退回的数量 %d", net), nil
+	}
+	return "", nil
+}
+
+// parseDateRange 解析 start_date / end_date 查询参数（格式 2006-01-02），结束日期包含当天
+func parseDateRange(c *gin.Context) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+	if s := c.Query("start_date"); s != "" {
+		start, err = time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return start, end, err
+		}
+	}
+	if s := c.Query("end_date"); s != "" {
+		end, err = time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return start, end, err
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end, nil
+}
+
+// CreateStockMovement 登记采购入库、退货、报损等库存变动
+func (mc *MedicineController) CreateStockMovement(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的药品ID"})
+		return
+	}
+
+	var req struct {
+		MovementType string `json:"movement_type" binding:"required"`
+		BatchID      int    `json:"batch_id"`
+		Quantity     int    `json:"quantity" binding:"required"`
+		Reason       string `json:"reason"`
+		ReferenceID  int    `json:"reference_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	switch req.MovementType {
+	case models.MovementPurchase:
+		if req.Quantity <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "入库数量必须大于0"})
+			return
+		}
+	case models.MovementExpired:
+		if req.Quantity >= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "报损数量必须为负数"})
+			return
+		}
+	case models.MovementReturn:
+		if req.Quantity <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "退药数量必须大于0"})
+			return
+		}
+	case models.MovementAdjustment:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的库存变动类型"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登记库存变动失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	// 关联处方的退药会在处方作废时与发药流水轧差，须退回该处方实际发出的批次且不超过未退数量
+	if req.ReferenceID != 0 {
+		if req.MovementType != models.MovementReturn {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "仅退药可关联处方"})
+			return
+		}
+		msg, err := checkPrescriptionReturn(tx, req.ReferenceID, id, req.BatchID, req.Quantity)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "登记库存变动失败"})
+			return
+		}
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+	}
+
+	balance, err := changeMedicineStock(tx, stockChange{
+		MedicineID:   id,
+		BatchID:      req.BatchID,
+		Quantity:     req.Quantity,
+		MovementType: req.MovementType,
+		UserID:       currentUserID(c),
+		Reason:       req.Reason,
+		ReferenceID:  req.ReferenceID,
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "药品或批次不存在"})
+		return
+	}
+	if shortage, ok := err.(*stockShortageError); ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": shortage.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登记库存变动失败"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登记库存变动失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "库存变动登记成功",
+		"stock":   balance,
+	})
+}
+
+// ListStockMovements 查询药品的库存流水
+func (mc *MedicineController) ListStockMovements(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的药品ID"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	movementType := c.Query("movement_type")
+	offset := (page - 1) * limit
+
+	start, end, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式错误"})
+		return
+	}
+
+	whereClause := "WHERE m.medicine_id = ?"
+	args := []interface{}{id}
+	if movementType != "" {
+		whereClause += " AND m.movement_type = ?"
+		args = append(args, movementType)
+	}
+	if !start.IsZero() {
+		whereClause += " AND m.created_at >= ?"
+		args = append(args, start)
+	}
+	if !end.IsZero() {
+		whereClause += " AND m.created_at < ?"
+		args = append(args, end)
+	}
+
+	query := `
+		SELECT m.id, m.medicine_id, m.batch_id, m.movement_type, m.quantity, m.balance, m.user_id, m.reason, m.reference_id, m.created_at,
+		       COALESCE(u.name, '') as user_name, COALESCE(b.batch_no, ''), COALESCE(b.expiry_date, '')
+		FROM stock_movements m
+		LEFT JOIN users u ON m.user_id = u.id
+		LEFT JOIN medicine_batches b ON m.batch_id = b.id
+		` + whereClause + ` ORDER BY m.id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询库存流水失败"})
+		return
+	}
+	defer rows.Close()
+
+	var movements []models.StockMovement
+	for rows.Next() {
+		var movement models.StockMovement
+		var reason sql.NullString
+		var userName, batchNo, expiryDate string
+		err := rows.Scan(&movement.ID, &movement.MedicineID, &movement.BatchID, &movement.MovementType, &movement.Quantity, &movement.Balance,
+			&movement.UserID, &reason, &movement.ReferenceID, &movement.CreatedAt, &userName, &batchNo, &expiryDate)
+		if err != nil {
+			continue
+		}
+		movement.Reason = reason.String
+		movement.User = &models.User{ID: movement.UserID, Name: userName}
+		if movement.BatchID > 0 {
+			movement.Batch = &models.MedicineBatch{ID: movement.BatchID, BatchNo: batchNo, ExpiryDate: expiryDate}
+		}
+		movements = append(movements, movement)
+	}
+
+	// 获取总数
+	countQuery := "SELECT COUNT(*) FROM stock_movements m " + whereClause
+	countArgs := args[:len(args)-2] // 去掉 LIMIT 和 OFFSET 参数
+	var total int
+	database.DB.QueryRow(countQuery, countArgs...).Scan(&total)
+
+	c.JSON(http.StatusOK, gin.H{
+		"movements": movements,
+		"total":     total,
+		"page":      page,
+		"limit":     limit,
+	})
+}
+
+// GetStockSummary 统计期间内各药品的期初、入库、出库、期末库存
+func (mc *MedicineController) GetStockSummary(c *gin.Context) {
+	start, end, err := parseDateRange(c)
+	if err != nil || start.IsZero() || end.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请提供正确的开始和结束日期"})
+		return
+	}
+
+	query := `
+		SELECT id, name, specification, unit, stock,
+		       COALESCE((SELECT SUM(quantity) FROM stock_movements WHERE medicine_id = medicines.id AND created_at >= ?), 0),
+		       COALESCE((SELECT SUM(quantity) FROM stock_movements WHERE medicine_id = medicines.id AND created_at >= ? AND created_at < ? AND quantity > 0), 0),
+		       COALESCE((SELECT -SUM(quantity) FROM stock_movements WHERE medicine_id = medicines.id AND created_at >= ? AND created_at < ? AND quantity < 0), 0),
+		       COALESCE((SELECT SUM(quantity) FROM stock_movements WHERE medicine_id = medicines.id AND created_at >= ?), 0)
+		FROM medicines`
+	args := []interface{}{end, start, end, start, end, start}
+	if medicineID := c.Query("medicine_id"); medicineID != "" {
+		query += " WHERE id = ?"
+		args = append(args, medicineID)
+	}
+	query += " ORDER BY name ASC"
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计库存失败"})
+		return
+	}
+	defer rows.Close()
+
+	var summaries []models.StockSummary
+	for rows.Next() {
+		var summary models.StockSummary
+		var stock, afterEnd, afterStart int
+		err := rows.Scan(&summary.MedicineID, &summary.MedicineName, &summary.Specification, &summary.Unit, &stock,
+			&afterEnd, &summary.In, &summary.Out, &afterStart)
+		if err != nil {
+			continue
+		}
+		// 从当前库存倒推，兼容启用流水前已有的库存
+		summary.Closing = stock - afterEnd
+		summary.Opening = stock - afterStart
+		summaries = append(summaries, summary)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"summaries": summaries})
+}
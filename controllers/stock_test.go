@@ -0,0 +1,365 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"lighthospital/database"
+	"lighthospital/models"
+	"testing"
+	"time"
+)
+
+// openTestDB 打开建好表的内存数据库，替换 database.DB，测试结束后关闭
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLINIC_PII_KEY", base64.StdEncoding.EncodeToString(key))
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存数据库每个连接各自独立，只保留一个连接
+	db.SetMaxOpenConns(1)
+	previous := database.DB
+	t.Cleanup(func() {
+		db.Close()
+		database.DB = previous
+	})
+	database.Setup(db)
+	return db
+}
+
+// testBatch 测试用批次，expiry 为空表示未登记有效期
+type testBatch struct {
+	expiry   string
+	quantity int
+}
+
+// createTestMedicine 新建药品及批次，返回药品ID和各批次ID
+func createTestMedicine(t *testing.T, db *sql.DB, name string, batches ...testBatch) (int, []int) {
+	t.Helper()
+	total := 0
+	for _, batch := range batches {
+		total += batch.quantity
+	}
+	result, err := db.Exec(`INSERT INTO medicines (name, specification, unit, price, stock) VALUES (?, '0.25g*24粒', '盒', 10, ?)`,
+		name, total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	medicineID, _ := result.LastInsertId()
+
+	var batchIDs []int
+	for i, batch := range batches {
+		var expiry interface{}
+		if batch.expiry != "" {
+			expiry = batch.expiry
+		}
+		result, err := db.Exec(`INSERT INTO medicine_batches (medicine_id, batch_no, expiry_date, quantity) VALUES (?, ?, ?, ?)`,
+			medicineID, string(rune('A'+i)), expiry, batch.quantity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		batchIDs = append(batchIDs, int(id))
+	}
+	return int(medicineID), batchIDs
+}
+
+// batchQuantities 按批次ID顺序查询批次库存
+func batchQuantities(t *testing.T, db *sql.DB, batchIDs []int) []int {
+	t.Helper()
+	quantities := make([]int, len(batchIDs))
+	for i, id := range batchIDs {
+		if err := db.QueryRow("SELECT quantity FROM medicine_batches WHERE id = ?", id).Scan(&quantities[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return quantities
+}
+
+// medicineStock 查询药品总库存
+func medicineStock(t *testing.T, db *sql.DB, medicineID int) int {
+	t.Helper()
+	var stock int
+	if err := db.QueryRow("SELECT stock FROM medicines WHERE id = ?", medicineID).Scan(&stock); err != nil {
+		t.Fatal(err)
+	}
+	return stock
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConsumeMedicineStock(t *testing.T) {
+	day := func(offset int) string { return time.Now().AddDate(0, 0, offset).Format("2006-01-02") }
+
+	// 批次依次为：已过期5、30天后到期3、90天后到期10、未登记有效期4，共22
+	batches := []testBatch{{day(-10), 5}, {day(30), 3}, {day(90), 10}, {"", 4}}
+	tests := []struct {
+		name           string
+		quantity       int
+		includeExpired bool
+		want           []int // 扣减后各批次库存
+		wantShortage   *stockShortageError
+	}{
+		{name: "近效期批次足够", quantity: 2, want: []int{5, 1, 10, 4}},
+		{name: "跨批次按效期先后扣减", quantity: 5, want: []int{5, 0, 8, 4}},
+		{name: "未登记有效期的批次最后扣减", quantity: 15, want: []int{5, 0, 0, 2}},
+		{name: "恰好用完未过期库存", quantity: 17, want: []int{5, 0, 0, 0}},
+		{name: "过期批次不参与发药", quantity: 18, want: []int{5, 3, 10, 4},
+			wantShortage: &stockShortageError{Required: 18, Available: 17, Expired: 5}},
+		{name: "包含过期批次时先扣过期批次", quantity: 7, includeExpired: true, want: []int{0, 1, 10, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			medicineID, batchIDs := createTestMedicine(t, db, "阿莫西林胶囊", batches...)
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = consumeMedicineStock(tx, stockChange{
+				MedicineID:   medicineID,
+				Quantity:     -tt.quantity,
+				MovementType: models.MovementDispense,
+				UserID:       1,
+				ReferenceID:  99,
+			}, tt.includeExpired)
+			if tt.wantShortage != nil {
+				tx.Rollback()
+				shortage, ok := err.(*stockShortageError)
+				if !ok {
+					t.Fatalf("应返回库存不足，得到 %v", err)
+				}
+				if shortage.Required != tt.wantShortage.Required || shortage.Available != tt.wantShortage.Available ||
+					shortage.Expired != tt.wantShortage.Expired {
+					t.Fatalf("库存不足信息 %+v，应为 %+v", shortage, tt.wantShortage)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := batchQuantities(t, db, batchIDs); !equalInts(got, tt.want) {
+				t.Errorf("批次库存 %v，应为 %v", got, tt.want)
+			}
+			wantStock := 22
+			if tt.wantShortage == nil {
+				wantStock -= tt.quantity
+			}
+			if got := medicineStock(t, db, medicineID); got != wantStock {
+				t.Errorf("药品库存 %d，应为 %d", got, wantStock)
+			}
+
+			// 每个被扣减的批次一条发药流水，合计等于扣减数量
+			var moved int
+			err = db.QueryRow("SELECT COALESCE(SUM(quantity), 0) FROM stock_movements WHERE medicine_id = ? AND reference_id = 99",
+				medicineID).Scan(&moved)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantShortage == nil && moved != -tt.quantity {
+				t.Errorf("流水合计 %d，应为 %d", moved, -tt.quantity)
+			}
+			if tt.wantShortage != nil && moved != 0 {
+				t.Errorf("库存不足时不应写入流水，得到合计 %d", moved)
+			}
+		})
+	}
+}
+
+// createTestPrescription 新建处方，items 为药品ID及数量
+func createTestPrescription(t *testing.T, db *sql.DB, status string, stockDeducted bool, items map[int]int) int {
+	t.Helper()
+	result, err := db.Exec(`INSERT INTO patients (name, gender, age) VALUES ('张三', '男', 30)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patientID, _ := result.LastInsertId()
+
+	result, err = db.Exec(`INSERT INTO prescriptions (patient_id, doctor_id, diagnosis, status, stock_deducted) VALUES (?, 1, '上呼吸道感染', ?, ?)`,
+		patientID, status, stockDeducted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prescriptionID, _ := result.LastInsertId()
+
+	for medicineID, quantity := range items {
+		_, err := db.Exec(`
+			INSERT INTO prescription_items (prescription_id, medicine_id, medicine_name, specification, dosage, usage, frequency, days, quantity)
+			SELECT ?, id, name, specification, '1粒', '口服', '每日3次', 3, ? FROM medicines WHERE id = ?`,
+			prescriptionID, quantity, medicineID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return int(prescriptionID)
+}
+
+func TestDeductPrescriptionStockShortageRollsBack(t *testing.T) {
+	db := openTestDB(t)
+	enough, enoughBatches := createTestMedicine(t, db, "阿莫西林胶囊", testBatch{"", 10})
+	short, shortBatches := createTestMedicine(t, db, "布洛芬缓释胶囊", testBatch{"", 2})
+	prescriptionID := createTestPrescription(t, db, models.PrescriptionDraft, false, map[int]int{enough: 4, short: 3})
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deductPrescriptionStock(tx, prescriptionID, 1)
+	tx.Rollback()
+	if _, ok := err.(*stockShortageError); !ok {
+		t.Fatalf("应返回库存不足，得到 %v", err)
+	}
+
+	// 先扣减的药品随事务回滚，库存和流水均不变
+	if got := batchQuantities(t, db, append(enoughBatches, shortBatches...)); !equalInts(got, []int{10, 2}) {
+		t.Errorf("批次库存 %v，应为 [10 2]", got)
+	}
+	if medicineStock(t, db, enough) != 10 || medicineStock(t, db, short) != 2 {
+		t.Error("回滚后药品库存不应变化")
+	}
+	var movements int
+	db.QueryRow("SELECT COUNT(*) FROM stock_movements").Scan(&movements)
+	if movements != 0 {
+		t.Errorf("回滚后不应有库存流水，得到 %d 条", movements)
+	}
+}
+
+func TestRestorePrescriptionStock(t *testing.T) {
+	day := func(offset int) string { return time.Now().AddDate(0, 0, offset).Format("2006-01-02") }
+
+	tests := []struct {
+		name      string
+		batches   []testBatch
+		quantity  int
+		returns   []int // 作废前按批次手工退药的数量，下标对应批次
+		want      []int // 作废后各批次库存
+		wantStock int
+	}{
+		{
+			name:      "全部退回原批次",
+			batches:   []testBatch{{day(30), 3}, {day(90), 10}},
+			quantity:  5,
+			want:      []int{3, 10},
+			wantStock: 13,
+		},
+		{
+			name:      "已部分退药的只退回剩余部分",
+			batches:   []testBatch{{day(30), 3}, {day(90), 10}},
+			quantity:  5,
+			returns:   []int{1, 2},
+			want:      []int{3, 10},
+			wantStock: 13,
+		},
+		{
+			name:      "某批次已全部退回",
+			batches:   []testBatch{{day(30), 3}, {day(90), 10}},
+			quantity:  5,
+			returns:   []int{3, 0},
+			want:      []int{3, 10},
+			wantStock: 13,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			medicineID, batchIDs := createTestMedicine(t, db, "阿莫西林胶囊", tt.batches...)
+			prescriptionID := createTestPrescription(t, db, models.PrescriptionDraft, false, map[int]int{medicineID: tt.quantity})
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := deductPrescriptionStock(tx, prescriptionID, 1); err != nil {
+				t.Fatal(err)
+			}
+			for i, quantity := range tt.returns {
+				if quantity == 0 {
+					continue
+				}
+				msg, err := checkPrescriptionReturn(tx, prescriptionID, medicineID, batchIDs[i], quantity)
+				if err != nil || msg != "" {
+					t.Fatalf("退药校验未通过：%q, %v", msg, err)
+				}
+				_, err = applyStockChange(tx, stockChange{
+					MedicineID:   medicineID,
+					BatchID:      batchIDs[i],
+					Quantity:     quantity,
+					MovementType: models.MovementReturn,
+					UserID:       1,
+					Reason:       "患者退药",
+					ReferenceID:  prescriptionID,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := restorePrescriptionStock(tx, prescriptionID, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := batchQuantities(t, db, batchIDs); !equalInts(got, tt.want) {
+				t.Errorf("批次库存 %v，应为 %v", got, tt.want)
+			}
+			if got := medicineStock(t, db, medicineID); got != tt.wantStock {
+				t.Errorf("药品库存 %d，应为 %d", got, tt.wantStock)
+			}
+
+			// 发药、退药流水轧差后为0，再次作废不会重复退回
+			var net int
+			err = db.QueryRow("SELECT SUM(quantity) FROM stock_movements WHERE reference_id = ?", prescriptionID).Scan(&net)
+			if err != nil || net != 0 {
+				t.Errorf("处方流水轧差 %d，应为0（%v）", net, err)
+			}
+		})
+	}
+}
+
+func TestRestorePrescriptionStockDeletedMedicine(t *testing.T) {
+	db := openTestDB(t)
+	medicineID, _ := createTestMedicine(t, db, "阿莫西林胶囊", testBatch{"", 10})
+	prescriptionID := createTestPrescription(t, db, models.PrescriptionDraft, false, map[int]int{medicineID: 4})
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := deductPrescriptionStock(tx, prescriptionID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("DELETE FROM medicines WHERE id = ?", medicineID); err != nil {
+		t.Fatal(err)
+	}
+
+	// 无法退回的发药不能被跳过
+	if _, ok := restorePrescriptionStock(tx, prescriptionID, 1).(*prescriptionError); !ok {
+		t.Fatal("药品已删除时应返回错误")
+	}
+}
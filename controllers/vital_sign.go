@@ -0,0 +1,368 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"lighthospital/database"
+	"lighthospital/models"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// vitalSignRequest 生命体征录入参数，未测量的项目不填或填 0
+type vitalSignRequest struct {
+	EncounterID     int       `json:"encounter_id"`
+	Temperature     float64   `json:"temperature"`
+	TemperatureUnit string    `json:"temperature_unit"` // C（默认）或 F，华氏度换算为摄氏度保存
+	SystolicBP      int       `json:"systolic_bp"`
+	DiastolicBP     int       `json:"diastolic_bp"`
+	Pulse           int       `json:"pulse"`
+	Respiration     int       `json:"respiration"`
+	SpO2            int       `json:"spo2"`
+	Weight          float64   `json:"weight"`
+	Height          float64   `json:"height"`
+	Notes           string    `json:"notes"`
+	MeasuredAt      time.Time `json:"measured_at"`
+}
+
+// normalize 换算体温单位并校验各项数值是否在有效范围内，超出范围多为单位填错
+func (req *vitalSignRequest) normalize() string {
+	switch strings.ToUpper(strings.TrimSpace(req.TemperatureUnit)) {
+	case "", "C", "℃":
+	case "F", "℉":
+		if req.Temperature != 0 {
+			req.Temperature = (req.Temperature - 32) * 5 / 9
+		}
+	default:
+		return "无效的体温单位"
+	}
+	req.Temperature = math.Round(req.Temperature*10) / 10
+	req.Weight = roundDose(req.Weight)
+	req.Height = math.Round(req.Height*10) / 10
+	req.Notes = strings.TrimSpace(req.Notes)
+
+	values := []struct {
+		field string
+		value float64
+	}{
+		{"temperature", req.Temperature},
+		{"systolic_bp", float64(req.SystolicBP)},
+		{"diastolic_bp", float64(req.DiastolicBP)},
+		{"pulse", float64(req.Pulse)},
+		{"respiration", float64(req.Respiration)},
+		{"spo2", float64(req.SpO2)},
+		{"weight", req.Weight},
+		{"height", req.Height},
+	}
+	measured := false
+	for _, v := range values {
+		if v.value == 0 {
+			continue
+		}
+		measured = true
+		r := models.VitalRanges[v.field]
+		if v.value < r.ValidMin || v.value > r.ValidMax {
+			return fmt.Sprintf("%s应在 %g-%g%s 之间，请检查数值和单位", r.Name, r.ValidMin, r.ValidMax, r.Unit)
+		}
+	}
+	if !measured {
+		return "请至少录入一项生命体征"
+	}
+
+	if (req.SystolicBP == 0) != (req.DiastolicBP == 0) {
+		return "血压须同时填写收缩压和舒张压"
+	}
+	if req.SystolicBP > 0 && req.SystolicBP <= req.DiastolicBP {
+		return "收缩压应高于舒张压"
+	}
+
+	if req.MeasuredAt.IsZero() {
+		req.MeasuredAt = time.Now()
+	}
+	if req.MeasuredAt.After(time.Now().Add(5 * time.Minute)) {
+		return "测量时间不能晚于当前时间"
+	}
+	return ""
+}
+
+// flagVitalSign 计算 BMI 并按成人参考范围标记异常项
+func flagVitalSign(vital *models.VitalSign) {
+	vital.Flags = []models.VitalSignFlag{}
+	if vital.Weight > 0 && vital.Height > 0 {
+		meters := vital.Height / 100
+		vital.BMI = math.Round(vital.Weight/(meters*meters)*10) / 10
+	}
+
+	check := func(flagField, rangeField string, value float64) {
+		r := models.VitalRanges[rangeField]
+		switch {
+		case value == 0:
+		case value > r.NormalMax:
+			vital.Flags = append(vital.Flags, models.VitalSignFlag{Field: flagField, Level: "high",
+				Message: fmt.Sprintf("%s偏高（%g%s，参考 %g-%g）", r.Name, value, r.Unit, r.NormalMin, r.NormalMax)})
+		case value < r.NormalMin:
+			vital.Flags = append(vital.Flags, models.VitalSignFlag{Field: flagField, Level: "low",
+				Message: fmt.Sprintf("%s偏低（%g%s，参考 %g-%g）", r.Name, value, r.Unit, r.NormalMin, r.NormalMax)})
+		}
+	}
+	check("temperature", "temperature", vital.Temperature)
+	check("blood_pressure", "systolic_bp", float64(vital.SystolicBP))
+	check("blood_pressure", "diastolic_bp", float64(vital.DiastolicBP))
+	check("pulse", "pulse", float64(vital.Pulse))
+	check("respiration", "respiration", float64(vital.Respiration))
+	check("spo2", "spo2", float64(vital.SpO2))
+	check("bmi", "bmi", vital.BMI)
+}
+
+// queryVitalSigns 查询生命体征记录并标记异常项
+func queryVitalSigns(query string, args ...interface{}) ([]models.VitalSign, error) {
+	rows, err := database.DB.Query(`
+		SELECT v.id, v.patient_id, v.encounter_id, v.temperature, v.systolic_bp, v.diastolic_bp, v.pulse, v.respiration,
+		       v.spo2, v.weight, v.height, v.notes, v.measured_at, v.recorded_by, v.created_at, COALESCE(u.name, '')
+		FROM vital_signs v
+		LEFT JOIN users u ON v.recorded_by = u.id
+		`+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vitals := []models.VitalSign{}
+	for rows.Next() {
+		var vital models.VitalSign
+		err := rows.Scan(&vital.ID, &vital.PatientID, &vital.EncounterID, &vital.Temperature, &vital.SystolicBP,
+			&vital.DiastolicBP, &vital.Pulse, &vital.Respiration, &vital.SpO2, &vital.Weight, &vital.Height, &vital.Notes,
+			&vital.MeasuredAt, &vital.RecordedBy, &vital.CreatedAt, &vital.RecorderName)
+		if err != nil {
+			continue
+		}
+		flagVitalSign(&vital)
+		vitals = append(vitals, vital)
+	}
+	return vitals, rows.Err()
+}
+
+// CreateVitalSign 为患者录入一次生命体征，可关联到门诊病历
+func (pc *PatientController) CreateVitalSign(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	var req vitalSignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	var exists int
+	database.DB.QueryRow("SELECT COUNT(*) FROM patients WHERE id = ?", patientID).Scan(&exists)
+	if exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "患者不存在"})
+		return
+	}
+
+	if msg := req.normalize(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if req.EncounterID > 0 {
+		var encounterPatientID int
+		err := database.DB.QueryRow("SELECT patient_id FROM encounters WHERE id = ?", req.EncounterID).Scan(&encounterPatientID)
+		if err == sql.ErrNoRows || (err == nil && encounterPatientID != patientID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "就诊病历不存在或不属于该患者"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "录入生命体征失败"})
+			return
+		}
+	} else {
+		req.EncounterID = 0
+	}
+
+	result, err := database.DB.Exec(`
+		INSERT INTO vital_signs (patient_id, encounter_id, temperature, systolic_bp, diastolic_bp, pulse, respiration, spo2,
+		weight, height, notes, measured_at, recorded_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		patientID, req.EncounterID, req.Temperature, req.SystolicBP, req.DiastolicBP, req.Pulse, req.Respiration, req.SpO2,
+		req.Weight, req.Height, req.Notes, req.MeasuredAt, currentUserID(c), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "录入生命体征失败"})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	vital := models.VitalSign{
+		Temperature: req.Temperature,
+		SystolicBP:  req.SystolicBP,
+		DiastolicBP: req.DiastolicBP,
+		Pulse:       req.Pulse,
+		Respiration: req.Respiration,
+		SpO2:        req.SpO2,
+		Weight:      req.Weight,
+		Height:      req.Height,
+	}
+	flagVitalSign(&vital)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "生命体征录入成功",
+		"id":      id,
+		"bmi":     vital.BMI,
+		"flags":   vital.Flags,
+	})
+}
+
+// ListVitalSigns 分页查询患者的生命体征记录，最近测量的在前
+func (pc *PatientController) ListVitalSigns(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+
+	whereClause := "WHERE v.patient_id = ?"
+	args := []interface{}{patientID}
+	if encounterID, _ := strconv.Atoi(c.Query("encounter_id")); encounterID > 0 {
+		whereClause += " AND v.encounter_id = ?"
+		args = append(args, encounterID)
+	}
+
+	var total int
+	database.DB.QueryRow("SELECT COUNT(*) FROM vital_signs v "+whereClause, args...).Scan(&total)
+
+	vitals, err := queryVitalSigns(whereClause+" ORDER BY v.measured_at DESC, v.id DESC LIMIT ? OFFSET ?",
+		append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询生命体征失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"vital_signs": vitals,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+	})
+}
+
+// VitalSignTrend 按时间顺序返回患者各项生命体征的序列，供前端绘制趋势图；
+// 血压序列同时包含收缩压和舒张压，未测量的项目不出现在对应序列中
+func (pc *PatientController) VitalSignTrend(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+
+	whereClause := "WHERE v.patient_id = ?"
+	args := []interface{}{patientID}
+	if startDate := c.Query("start_date"); startDate != "" {
+		start, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的开始日期"})
+			return
+		}
+		whereClause += " AND v.measured_at >= ?"
+		args = append(args, start)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		end, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的结束日期"})
+			return
+		}
+		whereClause += " AND v.measured_at < ?"
+		args = append(args, end.AddDate(0, 0, 1))
+	}
+
+	vitals, err := queryVitalSigns(whereClause+" ORDER BY v.measured_at, v.id", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询生命体征失败"})
+		return
+	}
+
+	series := map[string][]gin.H{
+		"temperature":    {},
+		"blood_pressure": {},
+		"pulse":          {},
+		"respiration":    {},
+		"spo2":           {},
+		"weight":         {},
+		"bmi":            {},
+	}
+	for _, vital := range vitals {
+		abnormal := map[string]bool{}
+		for _, flag := range vital.Flags {
+			abnormal[flag.Field] = true
+		}
+		point := func(field string, value float64) {
+			if value != 0 {
+				series[field] = append(series[field], gin.H{"measured_at": vital.MeasuredAt, "value": value, "abnormal": abnormal[field]})
+			}
+		}
+		point("temperature", vital.Temperature)
+		point("pulse", float64(vital.Pulse))
+		point("respiration", float64(vital.Respiration))
+		point("spo2", float64(vital.SpO2))
+		point("weight", vital.Weight)
+		point("bmi", vital.BMI)
+		if vital.SystolicBP > 0 {
+			series["blood_pressure"] = append(series["blood_pressure"], gin.H{
+				"measured_at": vital.MeasuredAt,
+				"systolic":    vital.SystolicBP,
+				"diastolic":   vital.DiastolicBP,
+				"abnormal":    abnormal["blood_pressure"],
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"patient_id": patientID, "series": series})
+}
+
+// DeleteVitalSign 删除录入错误的生命体征记录，仅录入人或管理员可删除
+func (pc *PatientController) DeleteVitalSign(c *gin.Context) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的患者ID"})
+		return
+	}
+	vitalID, err := strconv.Atoi(c.Param("vitalId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录ID"})
+		return
+	}
+
+	var recordedBy int
+	err = database.DB.QueryRow("SELECT recorded_by FROM vital_signs WHERE id = ? AND patient_id = ?", vitalID, patientID).Scan(&recordedBy)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "生命体征记录不存在"})
+		return
+	}
+	if recordedBy != currentUserID(c) && currentUserRole(c) != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能删除本人录入的记录"})
+		return
+	}
+
+	if _, err := database.DB.Exec("DELETE FROM vital_signs WHERE id = ?", vitalID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除生命体征失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "生命体征记录已删除"})
+}
@@ -12,15 +12,23 @@ import (
 var DB *sql.DB
 
 func InitDB() {
-	var err error
-	DB, err = sql.Open("sqlite", "./clinic.db")
+	db, err := sql.Open("sqlite", "./clinic.db")
 	if err != nil {
 		log.Fatal(err)
 	}
+	Setup(db)
+}
+
+// Setup 在已打开的数据库上建表、加载密钥、执行迁移并初始化默认数据，测试时可传入内存数据库
+func Setup(db *sql.DB) {
+	DB = db
 
 	// 创建表
 	createTables()
 
+	// 加载患者信息密钥
+	initPII()
+
 	// 执行数据库迁移
 	migrateDatabase()
 
@@ -37,6 +45,8 @@ func createTables() {
 		password TEXT NOT NULL,
 		name TEXT NOT NULL,
 		role TEXT NOT NULL DEFAULT 'doctor',
+		antibiotic_level TEXT NOT NULL DEFAULT 'non_restricted',
+		controlled_drug_qualified INTEGER NOT NULL DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -53,6 +63,12 @@ func createTables() {
 		address TEXT,
 		id_card TEXT,
 		medical_history TEXT,
+		birth_date TEXT NOT NULL DEFAULT '',
+		birth_date_estimated INTEGER NOT NULL DEFAULT 0,
+		phone_bidx TEXT NOT NULL DEFAULT '',
+		phone_tail_bidx TEXT NOT NULL DEFAULT '',
+		id_card_bidx TEXT NOT NULL DEFAULT '',
+		id_card_tail_bidx TEXT NOT NULL DEFAULT '',
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -69,6 +85,9 @@ func createTables() {
 		min_stock INTEGER NOT NULL DEFAULT 0,
 		category TEXT,
 		manufacturer TEXT,
+		ingredients TEXT NOT NULL DEFAULT '',
+		drug_class TEXT NOT NULL DEFAULT 'rx',
+		antibiotic_level TEXT NOT NULL DEFAULT '',
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
@@ -84,6 +103,27 @@ func createTables() {
 		total_amount REAL NOT NULL DEFAULT 0,
 		status TEXT NOT NULL DEFAULT 'draft',
 		notes TEXT,
+		stock_deducted INTEGER NOT NULL DEFAULT 0,
+		amended_from_id INTEGER NOT NULL DEFAULT 0,
+		allergy_override_reason TEXT NOT NULL DEFAULT '',
+		allergy_override_by INTEGER NOT NULL DEFAULT 0,
+		patient_weight REAL NOT NULL DEFAULT 0,
+		prescription_type TEXT NOT NULL DEFAULT 'western',
+		herbal_doses INTEGER NOT NULL DEFAULT 0,
+		decoction_method TEXT NOT NULL DEFAULT '',
+		prescription_no TEXT NOT NULL DEFAULT '',
+		void_reason TEXT NOT NULL DEFAULT '',
+		voided_by INTEGER NOT NULL DEFAULT 0,
+		voided_at DATETIME,
+		refund_amount REAL NOT NULL DEFAULT 0,
+		completed_at DATETIME,
+		reviewed_by INTEGER NOT NULL DEFAULT 0,
+		reviewed_at DATETIME,
+		review_note TEXT NOT NULL DEFAULT '',
+		dispensed_by INTEGER NOT NULL DEFAULT 0,
+		dispensed_at DATETIME,
+		prescription_form TEXT NOT NULL DEFAULT 'normal',
+		encounter_id INTEGER NOT NULL DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (patient_id) REFERENCES patients (id),
@@ -105,6 +145,8 @@ func createTables() {
 		quantity INTEGER NOT NULL DEFAULT 1,
 		unit_price REAL NOT NULL DEFAULT 0,
 		total_price REAL NOT NULL DEFAULT 0,
+		herb_grams REAL NOT NULL DEFAULT 0,
+		special_processing TEXT NOT NULL DEFAULT '',
 		FOREIGN KEY (prescription_id) REFERENCES prescriptions (id),
 		FOREIGN KEY (medicine_id) REFERENCES medicines (id)
 	);`
@@ -140,6 +182,271 @@ func createTables() {
 		FOREIGN KEY (user_id) REFERENCES users (id)
 	);`
 
+	// 库存流水表（只追加，不修改不删除）
+	createStockMovementsTable := `
+	CREATE TABLE IF NOT EXISTS stock_movements (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		medicine_id INTEGER NOT NULL,
+		movement_type TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		balance INTEGER NOT NULL,
+		batch_id INTEGER NOT NULL DEFAULT 0,
+		user_id INTEGER NOT NULL DEFAULT 0,
+		reason TEXT,
+		reference_id INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (medicine_id) REFERENCES medicines (id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_stock_movements_medicine ON stock_movements (medicine_id, created_at);`
+
+	// 药品批次表，药品库存按批次存放
+	createMedicineBatchesTable := `
+	CREATE TABLE IF NOT EXISTS medicine_batches (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		medicine_id INTEGER NOT NULL,
+		batch_no TEXT NOT NULL DEFAULT '',
+		expiry_date TEXT,
+		quantity INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (medicine_id) REFERENCES medicines (id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_medicine_batches_medicine ON medicine_batches (medicine_id, expiry_date);`
+
+	// 协定处方模板表
+	createPrescriptionTemplatesTable := `
+	CREATE TABLE IF NOT EXISTS prescription_templates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		pinyin TEXT,
+		initials TEXT,
+		scope TEXT NOT NULL DEFAULT 'personal',
+		owner_id INTEGER NOT NULL,
+		diagnosis TEXT,
+		doctor_advice TEXT,
+		notes TEXT,
+		prescription_type TEXT NOT NULL DEFAULT 'western',
+		herbal_doses INTEGER NOT NULL DEFAULT 0,
+		decoction_method TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (owner_id) REFERENCES users (id)
+	);`
+
+	// 协定处方模板明细表
+	createPrescriptionTemplateItemsTable := `
+	CREATE TABLE IF NOT EXISTS prescription_template_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		template_id INTEGER NOT NULL,
+		medicine_id INTEGER NOT NULL,
+		medicine_name TEXT NOT NULL,
+		specification TEXT NOT NULL,
+		dosage TEXT NOT NULL,
+		usage TEXT NOT NULL,
+		frequency TEXT NOT NULL,
+		days INTEGER NOT NULL DEFAULT 1,
+		quantity INTEGER NOT NULL DEFAULT 1,
+		herb_grams REAL NOT NULL DEFAULT 0,
+		special_processing TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (template_id) REFERENCES prescription_templates (id),
+		FOREIGN KEY (medicine_id) REFERENCES medicines (id)
+	);`
+
+	// 患者过敏史表，删除时仅标记 deleted_at 以保留变更记录
+	createPatientAllergiesTable := `
+	CREATE TABLE IF NOT EXISTS patient_allergies (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patient_id INTEGER NOT NULL,
+		allergen_type TEXT NOT NULL,
+		medicine_id INTEGER NOT NULL DEFAULT 0,
+		allergen TEXT NOT NULL,
+		reaction TEXT,
+		severity TEXT NOT NULL DEFAULT 'moderate',
+		notes TEXT,
+		created_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		deleted_at DATETIME,
+		FOREIGN KEY (patient_id) REFERENCES patients (id)
+	);`
+
+	// 药物相互作用规则表，规则双方可以是药品名称或药品分类
+	createDrugInteractionsTable := `
+	CREATE TABLE IF NOT EXISTS drug_interactions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type_a TEXT NOT NULL,
+		name_a TEXT NOT NULL,
+		type_b TEXT NOT NULL,
+		name_b TEXT NOT NULL,
+		severity TEXT NOT NULL DEFAULT 'moderate',
+		message TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_drug_interactions_pair ON drug_interactions (type_a, name_a, type_b, name_b);`
+
+	// 药品剂量规则表，每个药品一条，用于儿童按年龄、体重核对剂量
+	createMedicineDosingRulesTable := `
+	CREATE TABLE IF NOT EXISTS medicine_dosing_rules (
+		medicine_id INTEGER PRIMARY KEY,
+		dose_unit TEXT NOT NULL DEFAULT '',
+		max_single_dose REAL NOT NULL DEFAULT 0,
+		max_daily_dose REAL NOT NULL DEFAULT 0,
+		dose_per_kg REAL NOT NULL DEFAULT 0,
+		min_age_months INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (medicine_id) REFERENCES medicines (id)
+	);`
+
+	// 处方编号每日流水号表，只增不减，保证编号不因处方删除而重复使用
+	createPrescriptionNumberSequencesTable := `
+	CREATE TABLE IF NOT EXISTS prescription_number_sequences (
+		seq_date TEXT PRIMARY KEY,
+		last_value INTEGER NOT NULL DEFAULT 0
+	);`
+
+	// 药品管理类别开方规则表
+	createDrugClassRulesTable := `
+	CREATE TABLE IF NOT EXISTS drug_class_rules (
+		drug_class TEXT PRIMARY KEY,
+		max_days INTEGER NOT NULL DEFAULT 0,
+		require_id_card INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// 麻醉药品、精神药品发药专用登记表，记录发药时的药品和患者信息快照
+	createControlledDrugRegisterTable := `
+	CREATE TABLE IF NOT EXISTS controlled_drug_register (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		prescription_id INTEGER NOT NULL,
+		prescription_no TEXT NOT NULL DEFAULT '',
+		medicine_id INTEGER NOT NULL,
+		medicine_name TEXT NOT NULL,
+		specification TEXT NOT NULL DEFAULT '',
+		drug_class TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		patient_id INTEGER NOT NULL,
+		patient_name TEXT NOT NULL DEFAULT '',
+		patient_id_card TEXT NOT NULL DEFAULT '',
+		doctor_id INTEGER NOT NULL,
+		doctor_name TEXT NOT NULL DEFAULT '',
+		dispensed_by INTEGER NOT NULL,
+		dispenser_name TEXT NOT NULL DEFAULT '',
+		dispensed_at DATETIME NOT NULL,
+		FOREIGN KEY (prescription_id) REFERENCES prescriptions (id),
+		FOREIGN KEY (medicine_id) REFERENCES medicines (id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_controlled_drug_register_medicine ON controlled_drug_register (medicine_id, dispensed_at);`
+
+	// 门诊病历表，按 SOAP 记录主诉、现病史、体格检查、诊断和处理意见，签名后不可修改
+	createEncountersTable := `
+	CREATE TABLE IF NOT EXISTS encounters (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patient_id INTEGER NOT NULL,
+		doctor_id INTEGER NOT NULL,
+		appointment_id INTEGER NOT NULL DEFAULT 0,
+		visit_time DATETIME NOT NULL,
+		chief_complaint TEXT NOT NULL DEFAULT '',
+		present_illness TEXT NOT NULL DEFAULT '',
+		past_history TEXT NOT NULL DEFAULT '',
+		physical_exam TEXT NOT NULL DEFAULT '',
+		diagnosis TEXT NOT NULL DEFAULT '',
+		plan TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'draft',
+		signed_by INTEGER NOT NULL DEFAULT 0,
+		signed_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (patient_id) REFERENCES patients (id),
+		FOREIGN KEY (doctor_id) REFERENCES users (id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_encounters_patient ON encounters (patient_id, visit_time);`
+
+	// 生命体征表，未测量的项目记为 0
+	createVitalSignsTable := `
+	CREATE TABLE IF NOT EXISTS vital_signs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patient_id INTEGER NOT NULL,
+		encounter_id INTEGER NOT NULL DEFAULT 0,
+		temperature REAL NOT NULL DEFAULT 0,
+		systolic_bp INTEGER NOT NULL DEFAULT 0,
+		diastolic_bp INTEGER NOT NULL DEFAULT 0,
+		pulse INTEGER NOT NULL DEFAULT 0,
+		respiration INTEGER NOT NULL DEFAULT 0,
+		spo2 INTEGER NOT NULL DEFAULT 0,
+		weight REAL NOT NULL DEFAULT 0,
+		height REAL NOT NULL DEFAULT 0,
+		notes TEXT NOT NULL DEFAULT '',
+		measured_at DATETIME NOT NULL,
+		recorded_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (patient_id) REFERENCES patients (id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_vital_signs_patient ON vital_signs (patient_id, measured_at);`
+
+	// ICD-10 诊断字典，编码不区分大小写，pinyin、initials 由名称生成或随导入文件提供
+	createDiagnosisCodesTable := `
+	CREATE TABLE IF NOT EXISTS diagnosis_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		name TEXT NOT NULL,
+		pinyin TEXT NOT NULL DEFAULT '',
+		initials TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// 处方和病历的编码诊断，record_type 区分所属记录，诊断名称保存开具时的字典名称
+	createRecordDiagnosesTable := `
+	CREATE TABLE IF NOT EXISTS record_diagnoses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		record_type TEXT NOT NULL,
+		record_id INTEGER NOT NULL,
+		code TEXT NOT NULL,
+		name TEXT NOT NULL,
+		is_primary BOOLEAN NOT NULL DEFAULT 0,
+		sort_order INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_record_diagnoses_record ON record_diagnoses (record_type, record_id);
+	CREATE INDEX IF NOT EXISTS idx_record_diagnoses_code ON record_diagnoses (code);`
+
+	// 患者档案合并记录，merged_patient 为被合并档案的JSON快照，reassigned 为各表转移的记录数
+	createPatientMergesTable := `
+	CREATE TABLE IF NOT EXISTS patient_merges (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		surviving_patient_id INTEGER NOT NULL,
+		merged_patient_id INTEGER NOT NULL,
+		merged_patient TEXT NOT NULL,
+		reassigned TEXT NOT NULL DEFAULT '{}',
+		score INTEGER NOT NULL DEFAULT 0,
+		reason TEXT NOT NULL DEFAULT '',
+		merged_by INTEGER NOT NULL,
+		merged_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_patient_merges_surviving ON patient_merges (surviving_patient_id);`
+
+	// 患者附件，文件按内容哈希保存在附件目录中，prescription_id、encounter_id 为 0 表示未关联
+	createPatientAttachmentsTable := `
+	CREATE TABLE IF NOT EXISTS patient_attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		patient_id INTEGER NOT NULL,
+		prescription_id INTEGER NOT NULL DEFAULT 0,
+		encounter_id INTEGER NOT NULL DEFAULT 0,
+		category TEXT NOT NULL DEFAULT 'other',
+		file_name TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		sha256 TEXT NOT NULL,
+		has_thumbnail BOOLEAN NOT NULL DEFAULT 0,
+		description TEXT NOT NULL DEFAULT '',
+		uploaded_by INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (patient_id) REFERENCES patients (id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_patient_attachments_patient ON patient_attachments (patient_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_patient_attachments_sha256 ON patient_attachments (sha256);`
+
 	tables := []string{
 		createUsersTable,
 		createPatientsTable,
@@ -148,6 +455,22 @@ func createTables() {
 		createPrescriptionItemsTable,
 		createAppointmentsTable,
 		createOperationLogsTable,
+		createStockMovementsTable,
+		createMedicineBatchesTable,
+		createPrescriptionTemplatesTable,
+		createPrescriptionTemplateItemsTable,
+		createPatientAllergiesTable,
+		createDrugInteractionsTable,
+		createMedicineDosingRulesTable,
+		createPrescriptionNumberSequencesTable,
+		createDrugClassRulesTable,
+		createControlledDrugRegisterTable,
+		createEncountersTable,
+		createVitalSignsTable,
+		createDiagnosisCodesTable,
+		createRecordDiagnosesTable,
+		createPatientMergesTable,
+		createPatientAttachmentsTable,
 	}
 
 	for _, table := range tables {
@@ -210,33 +533,305 @@ func initDefaultData() {
 			name, spec, unit, category, manufacturer string
 			price                                    float64
 			stock                                    int
+			drugClass, antibioticLevel               string
 		}{
-			{"阿莫西林胶囊", "0.25g*24粒", "盒", "抗生素", "华北制药", 15.50, 100},
-			{"布洛芬片", "0.1g*20片", "盒", "解热镇痛", "中美史克", 8.80, 80},
-			{"感冒灵颗粒", "10g*10袋", "盒", "感冒药", "999药业", 12.00, 60},
-			{"维生素C片", "0.1g*100片", "瓶", "维生素", "东北制药", 5.50, 120},
-			{"板蓝根颗粒", "10g*20袋", "盒", "清热解毒", "白云山", 18.00, 50},
+			{"阿莫西林胶囊", "0.25g*24粒", "盒", "抗生素", "华北制药", 15.50, 100, "rx", "non_restricted"},
+			{"布洛芬片", "0.1g*20片", "盒", "解热镇痛", "中美史克", 8.80, 80, "otc_b", ""},
+			{"感冒灵颗粒", "10g*10袋", "盒", "感冒药", "999药业", 12.00, 60, "otc_a", ""},
+			{"维生素C片", "0.1g*100片", "瓶", "维生素", "东北制药", 5.50, 120, "otc_b", ""},
+			{"板蓝根颗粒", "10g*20袋", "盒", "清热解毒", "白云山", 18.00, 50, "otc_a", ""},
 		}
 
 		for _, med := range sampleMedicines {
-			_, err := DB.Exec(`
-				INSERT INTO medicines (name, specification, unit, price, stock, min_stock, category, manufacturer, created_at, updated_at)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-				med.name, med.spec, med.unit, med.price, med.stock, 10, med.category, med.manufacturer, time.Now(), time.Now())
+			result, err := DB.Exec(`
+				INSERT INTO medicines (name, specification, unit, price, stock, min_stock, category, manufacturer, drug_class, antibiotic_level,
+				created_at, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				med.name, med.spec, med.unit, med.price, med.stock, 10, med.category, med.manufacturer, med.drugClass, med.antibioticLevel,
+				time.Now(), time.Now())
 			if err != nil {
 				log.Printf("添加示例药品失败: %v", err)
+				continue
+			}
+
+			medicineID, _ := result.LastInsertId()
+			_, err = DB.Exec(`
+				INSERT INTO medicine_batches (medicine_id, batch_no, expiry_date, quantity, created_at, updated_at)
+				VALUES (?, '', NULL, ?, ?, ?)`,
+				medicineID, med.stock, time.Now(), time.Now())
+			if err != nil {
+				log.Printf("添加示例药品批次失败: %v", err)
 			}
 		}
 		log.Println("示例药品数据已添加")
 	}
+
+	// 默认开方规则：麻醉药品、第一类精神药品每张处方不超过3日用量并须登记身份证号，第二类精神药品不超过7日用量
+	defaultDrugClassRules := []struct {
+		drugClass     string
+		maxDays       int
+		requireIDCard bool
+	}{
+		{"narcotic", 3, true},
+		{"psychotropic_1", 3, true},
+		{"psychotropic_2", 7, false},
+		{"rx", 0, false},
+		{"otc_a", 0, false},
+		{"otc_b", 0, false},
+	}
+	for _, rule := range defaultDrugClassRules {
+		_, err := DB.Exec(`
+			INSERT OR IGNORE INTO drug_class_rules (drug_class, max_days, require_id_card, updated_at) VALUES (?, ?, ?, ?)`,
+			rule.drugClass, rule.maxDays, rule.requireIDCard, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 func migrateDatabase() {
 	// 检查是否需要添加新字段
-	// 这里可以添加数据库迁移逻辑
+	addColumnIfNotExists("prescriptions", "stock_deducted", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("stock_movements", "batch_id", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescriptions", "amended_from_id", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("medicines", "ingredients", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("prescriptions", "allergy_override_reason", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("prescriptions", "allergy_override_by", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescriptions", "patient_weight", "REAL NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescriptions", "prescription_type", "TEXT NOT NULL DEFAULT 'western'")
+	addColumnIfNotExists("prescriptions", "herbal_doses", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescriptions", "decoction_method", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("prescription_items", "herb_grams", "REAL NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescription_items", "special_processing", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("prescriptions", "prescription_no", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("prescriptions", "void_reason", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("prescriptions", "voided_by", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescriptions", "voided_at", "DATETIME")
+	addColumnIfNotExists("prescriptions", "refund_amount", "REAL NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescriptions", "completed_at", "DATETIME")
+	addColumnIfNotExists("prescriptions", "reviewed_by", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescriptions", "reviewed_at", "DATETIME")
+	addColumnIfNotExists("prescriptions", "review_note", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("prescriptions", "dispensed_by", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescriptions", "dispensed_at", "DATETIME")
+	addColumnIfNotExists("prescriptions", "prescription_form", "TEXT NOT NULL DEFAULT 'normal'")
+	addColumnIfNotExists("medicines", "drug_class", "TEXT NOT NULL DEFAULT 'rx'")
+	addColumnIfNotExists("medicines", "antibiotic_level", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("users", "antibiotic_level", "TEXT NOT NULL DEFAULT 'non_restricted'")
+	addColumnIfNotExists("users", "controlled_drug_qualified", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescriptions", "encounter_id", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("patients", "birth_date", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("patients", "birth_date_estimated", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("patients", "phone_bidx", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("patients", "phone_tail_bidx", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("patients", "id_card_bidx", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("patients", "id_card_tail_bidx", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("medicine_dosing_rules", "min_age_months", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescription_templates", "prescription_type", "TEXT NOT NULL DEFAULT 'western'")
+	addColumnIfNotExists("prescription_templates", "herbal_doses", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescription_templates", "decoction_method", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("prescription_template_items", "herb_grams", "REAL NOT NULL DEFAULT 0")
+	addColumnIfNotExists("prescription_template_items", "special_processing", "TEXT NOT NULL DEFAULT ''")
+
+	// 最低用药年龄原按岁保存，改为按月保存以便设置“6个月以下禁用”等规则
+	if columnExists("medicine_dosing_rules", "min_age") {
+		_, err := DB.Exec("UPDATE medicine_dosing_rules SET min_age_months = min_age * 12, min_age = 0 WHERE min_age > 0")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 处方编号唯一，未完成的草稿编号为空
+	_, err := DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_prescriptions_no ON prescriptions (prescription_no) WHERE prescription_no != ''`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_prescriptions_encounter ON prescriptions (encounter_id)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 启用批次管理前的库存归入无批号批次
+	_, err = DB.Exec(`
+		INSERT INTO medicine_batches (medicine_id, batch_no, expiry_date, quantity, created_at, updated_at)
+		SELECT m.id, '', NULL, m.stock - COALESCE((SELECT SUM(b.quantity) FROM medicine_batches b WHERE b.medicine_id = m.id), 0), ?, ?
+		FROM medicines m
+		WHERE m.stock > COALESCE((SELECT SUM(b.quantity) FROM medicine_batches b WHERE b.medicine_id = m.id), 0)`,
+		time.Now(), time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	estimatePatientBirthDates()
+	createPatientIDCardIndex()
+
+	// 患者电话、身份证号的查询索引
+	for _, column := range []string{"phone_bidx", "phone_tail_bidx", "id_card_tail_bidx"} {
+		_, err = DB.Exec("CREATE INDEX IF NOT EXISTS idx_patients_" + column + " ON patients (" + column + ")")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	log.Println("数据库迁移完成")
 }
 
+// estimatePatientBirthDates 为只登记了年龄的患者按建档时间推算出生日期，并标记为估算
+func estimatePatientBirthDates() {
+	rows, err := DB.Query("SELECT id, age, created_at FROM patients WHERE birth_date = '' AND age > 0")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	type estimate struct {
+		id        int
+		birthDate string
+	}
+	var estimates []estimate
+	for rows.Next() {
+		var id, age int
+		var createdAt sql.NullTime
+		if err := rows.Scan(&id, &age, &createdAt); err != nil {
+			rows.Close()
+			log.Fatal(err)
+		}
+		registered := time.Now()
+		if createdAt.Valid {
+			registered = createdAt.Time
+		}
+		estimates = append(estimates, estimate{id, registered.AddDate(-age, 0, 0).Format("2006-01-02")})
+	}
+	rows.Close()
+
+	for _, e := range estimates {
+		_, err := DB.Exec("UPDATE patients SET birth_date = ?, birth_date_estimated = 1 WHERE id = ?", e.birthDate, e.id)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(estimates) > 0 {
+		log.Printf("已为 %d 名患者按年龄推算出生日期", len(estimates))
+	}
+}
+
+// createPatientIDCardIndex 统一明文身份证号的格式后加密患者敏感信息，并按身份证号查询索引建立唯一索引；
+// 旧数据中存在重复身份证号时暂不建索引，启动日志列出冲突的档案，合并后自动建立
+func createPatientIDCardIndex() {
+	_, err := DB.Exec(`UPDATE patients SET id_card = UPPER(TRIM(COALESCE(id_card, '')))
+		WHERE id_card IS NULL OR (id_card NOT LIKE ? AND id_card != UPPER(TRIM(id_card)))`, piiPrefix+"%")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encryptPlaintextPII()
+
+	// 身份证号加密后密文各不相同，唯一约束改为建立在查询索引上
+	if _, err := DB.Exec("DROP INDEX IF EXISTS idx_patients_id_card"); err != nil {
+		log.Fatal(err)
+	}
+
+	created, err := EnsurePatientIDCardIndex()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if created {
+		return
+	}
+	conflicts, err := PatientIDCardConflicts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("警告：有 %d 个身份证号登记在多份患者档案中，身份证号唯一约束暂未启用，期间由程序拒绝登记重复的身份证号；"+
+		"请在“全院查重”（GET /api/patients/duplicates）中合并以下档案，全部合并后自动启用：", len(conflicts))
+	for _, ids := range conflicts {
+		log.Printf("  患者ID %v", ids)
+	}
+}
+
+// EnsurePatientIDCardIndex 没有重复身份证号时建立身份证号唯一索引，返回索引是否已建立
+func EnsurePatientIDCardIndex() (bool, error) {
+	var exists int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_patients_id_card_bidx'").Scan(&exists)
+	if err != nil || exists > 0 {
+		return exists > 0, err
+	}
+
+	conflicts, err := PatientIDCardConflicts()
+	if err != nil || len(conflicts) > 0 {
+		return false, err
+	}
+
+	_, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_patients_id_card_bidx ON patients (id_card_bidx) WHERE id_card_bidx != ''`)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PatientIDCardConflicts 列出身份证号登记在多份档案中的患者ID，每组为同一身份证号的档案
+func PatientIDCardConflicts() ([][]int, error) {
+	rows, err := DB.Query(`
+		SELECT id_card_bidx, id FROM patients
+		WHERE id_card_bidx IN (SELECT id_card_bidx FROM patients WHERE id_card_bidx != '' GROUP BY id_card_bidx HAVING COUNT(*) > 1)
+		ORDER BY id_card_bidx, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conflicts := [][]int{}
+	last := ""
+	for rows.Next() {
+		var index string
+		var id int
+		if err := rows.Scan(&index, &id); err != nil {
+			return nil, err
+		}
+		if index != last || len(conflicts) == 0 {
+			conflicts = append(conflicts, []int{})
+			last = index
+		}
+		conflicts[len(conflicts)-1] = append(conflicts[len(conflicts)-1], id)
+	}
+	return conflicts, rows.Err()
+}
+
+// addColumnIfNotExists 为旧版本数据库补充新增字段
+func addColumnIfNotExists(table, column, definition string) {
+	if columnExists(table, column) {
+		return
+	}
+
+	_, err := DB.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("已为表 %s 添加字段 %s", table, column)
+}
+
+// columnExists 检查表中是否存在指定字段
+func columnExists(table, column string) bool {
+	rows, err := DB.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			log.Fatal(err)
+		}
+		if name == column {
+			return true
+		}
+	}
+	return false
+}
+
 // GetDB 获取数据库连接
 func GetDB() *sql.DB {
 	return DB
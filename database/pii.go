@@ -0,0 +1,447 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// 患者身份证号、电话、地址、病史以 AES-256-GCM 加密保存，密文带 piiPrefix 前缀以区分升级前的明文。
+// 密钥不保存在数据库中，按以下顺序读取：环境变量 CLINIC_PII_KEY（Base64 编码的32字节密钥）、
+// 环境变量 CLINIC_PII_KEY_FILE 指定的密钥文件、程序目录下的 clinic.key；都不存在时生成 clinic.key。
+// 电话和身份证号另存 HMAC 查询索引（完整号码及后4位），用于按号码查找患者。
+// 麻精药品登记中的患者身份证号、档案合并记录中的档案快照同样加密
+const (
+	piiPrefix         = "enc:v1:"
+	piiKeySize        = 32
+	piiKeyEnv         = "CLINIC_PII_KEY"
+	piiKeyFileEnv     = "CLINIC_PII_KEY_FILE"
+	defaultPIIKeyFile = "./clinic.key"
+
+	// piiTailLength 电话、身份证号按末尾几位建立查询索引
+	piiTailLength = 4
+)
+
+// ErrPIIKeyMismatch 数据库中的密文无法用当前密钥解密
+var ErrPIIKeyMismatch = errors.New("患者信息密钥与数据库不匹配")
+
+// piiCipher 加密敏感字段和计算查询索引所用的密钥
+type piiCipher struct {
+	aead     cipher.AEAD
+	indexKey []byte
+}
+
+var pii *piiCipher
+
+// PatientPII 加密后待写入 patients 表的敏感字段及查询索引
+type PatientPII struct {
+	Phone           string
+	Address         string
+	IDCard          string
+	MedicalHistory  string
+	PhoneIndex      string
+	PhoneTailIndex  string
+	IDCardIndex     string
+	IDCardTailIndex string
+}
+
+func newPIICipher(key []byte) (*piiCipher, error) {
+	if len(key) != piiKeySize {
+		return nil, fmt.Errorf("患者信息密钥长度应为%d字节", piiKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// 查询索引使用由主密钥派生的独立密钥
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("lighthospital blind index"))
+	return &piiCipher{aead: aead, indexKey: mac.Sum(nil)}, nil
+}
+
+func (pc *piiCipher) encrypt(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	nonce := make([]byte, pc.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("生成加密随机数失败：%w", err)
+	}
+	sealed := pc.aead.Seal(nonce, nonce, []byte(value), nil)
+	return piiPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (pc *piiCipher) decrypt(value string) (string, error) {
+	// 升级前的明文原样返回，启动迁移时统一加密
+	if !strings.HasPrefix(value, piiPrefix) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, piiPrefix))
+	if err != nil || len(sealed) < pc.aead.NonceSize() {
+		return "", ErrPIIKeyMismatch
+	}
+	nonceSize := pc.aead.NonceSize()
+	plain, err := pc.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", ErrPIIKeyMismatch
+	}
+	return string(plain), nil
+}
+
+func (pc *piiCipher) blindIndex(field, value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, pc.indexKey)
+	mac.Write([]byte(field + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (pc *piiCipher) patientPII(phone, address, idCard, medicalHistory string) (PatientPII, error) {
+	phone = NormalizePhone(phone)
+	idCard = NormalizeIDCard(idCard)
+	values := PatientPII{
+		PhoneIndex:      pc.blindIndex("phone", phone),
+		PhoneTailIndex:  pc.blindIndex("phone_tail", piiTail(phone)),
+		IDCardIndex:     pc.blindIndex("id_card", idCard),
+		IDCardTailIndex: pc.blindIndex("id_card_tail", piiTail(idCard)),
+	}
+	for _, field := range []struct {
+		plain  string
+		cipher *string
+	}{
+		{phone, &values.Phone},
+		{address, &values.Address},
+		{idCard, &values.IDCard},
+		{medicalHistory, &values.MedicalHistory},
+	} {
+		var err error
+		if *field.cipher, err = pc.encrypt(field.plain); err != nil {
+			return PatientPII{}, err
+		}
+	}
+	return values, nil
+}
+
+// piiTail 取号码末尾几位，号码不足位数时不建立索引
+func piiTail(value string) string {
+	if len(value) < piiTailLength {
+		return ""
+	}
+	return value[len(value)-piiTailLength:]
+}
+
+// NormalizePhone 去除电话号码首尾空白
+func NormalizePhone(phone string) string {
+	return strings.TrimSpace(phone)
+}
+
+// NormalizeIDCard 身份证号去除首尾空白，末位 x 统一为大写
+func NormalizeIDCard(idCard string) string {
+	return strings.ToUpper(strings.TrimSpace(idCard))
+}
+
+// EncryptPII 用当前密钥加密敏感字段，空值不加密
+func EncryptPII(value string) (string, error) {
+	return pii.encrypt(value)
+}
+
+// DecryptPII 解密敏感字段，未加密的旧数据原样返回
+func DecryptPII(value string) (string, error) {
+	return pii.decrypt(value)
+}
+
+// EncryptPatientPII 加密患者敏感字段并计算电话、身份证号的查询索引
+func EncryptPatientPII(phone, address, idCard, medicalHistory string) (PatientPII, error) {
+	return pii.patientPII(phone, address, idCard, medicalHistory)
+}
+
+// PhoneIndexes 按电话号码查找时比较的索引：完整号码、后4位
+func PhoneIndexes(phone string) (string, string) {
+	phone = NormalizePhone(phone)
+	return pii.blindIndex("phone", phone), pii.blindIndex("phone_tail", piiTail(phone))
+}
+
+// IDCardIndexes 按身份证号查找时比较的索引：完整号码、后4位
+func IDCardIndexes(idCard string) (string, string) {
+	idCard = NormalizeIDCard(idCard)
+	return pii.blindIndex("id_card", idCard), pii.blindIndex("id_card_tail", piiTail(idCard))
+}
+
+// loadPIIKey 读取患者信息密钥，没有配置密钥时生成密钥文件；返回密钥及所在文件（来自环境变量时为空）
+func loadPIIKey() ([]byte, string, error) {
+	if encoded := strings.TrimSpace(os.Getenv(piiKeyEnv)); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, "", fmt.Errorf("环境变量 %s 不是有效的 Base64 密钥", piiKeyEnv)
+		}
+		return key, "", nil
+	}
+
+	keyFile := piiKeyFile()
+	content, err := os.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		key := make([]byte, piiKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, "", fmt.Errorf("生成患者信息密钥失败：%w", err)
+		}
+		if err := writePIIKeyFile(keyFile, key); err != nil {
+			return nil, "", err
+		}
+		log.Printf("已生成患者信息密钥文件 %s，请与数据库分开妥善备份，丢失后已加密的患者信息无法恢复", keyFile)
+		return key, keyFile, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, "", fmt.Errorf("密钥文件 %s 不是有效的 Base64 密钥", keyFile)
+	}
+	return key, keyFile, nil
+}
+
+func piiKeyFile() string {
+	if keyFile := strings.TrimSpace(os.Getenv(piiKeyFileEnv)); keyFile != "" {
+		return keyFile
+	}
+	return defaultPIIKeyFile
+}
+
+func writePIIKeyFile(path string, key []byte) error {
+	return os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+}
+
+// initPII 加载密钥，并确认数据库中已有的密文能用该密钥解密
+func initPII() {
+	key, _, err := loadPIIKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pii, err = newPIICipher(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var sample string
+	err = DB.QueryRow(`
+		SELECT value FROM (
+			SELECT phone AS value FROM patients UNION ALL SELECT address FROM patients
+			UNION ALL SELECT id_card FROM patients UNION ALL SELECT medical_history FROM patients
+		) WHERE value LIKE ? LIMIT 1`, piiPrefix+"%").Scan(&sample)
+	if err == nil {
+		if _, err := pii.decrypt(sample); err != nil {
+			log.Fatalf("%v，请检查环境变量 %s 或密钥文件 %s", err, piiKeyEnv, piiKeyFile())
+		}
+	}
+}
+
+// encryptPlaintextPII 加密升级前以明文保存的患者敏感字段并补全查询索引
+func encryptPlaintextPII() {
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	count, err := reencryptPII(tx, pii, pii, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	if count > 0 {
+		log.Printf("已加密 %d 条患者敏感信息记录", count)
+	}
+}
+
+// reencryptPII 用 from 解密患者档案和麻精药品登记中的敏感字段，再用 to 加密并重算查询索引；
+// all 为 false 时只处理仍有明文或缺少查询索引的记录
+func reencryptPII(tx *sql.Tx, from, to *piiCipher, all bool) (int, error) {
+	rows, err := tx.Query(`
+		SELECT id, COALESCE(phone, ''), COALESCE(address, ''), COALESCE(id_card, ''), COALESCE(medical_history, ''),
+		       phone_bidx, id_card_bidx
+		FROM patients`)
+	if err != nil {
+		return 0, err
+	}
+	type patientPII struct {
+		id                                     int
+		phone, address, idCard, medicalHistory string
+	}
+	var patients []patientPII
+	for rows.Next() {
+		var p patientPII
+		var phoneIndex, idCardIndex string
+		if err := rows.Scan(&p.id, &p.phone, &p.address, &p.idCard, &p.medicalHistory, &phoneIndex, &idCardIndex); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !all && piiEncrypted(p.phone, p.address, p.idCard, p.medicalHistory) &&
+			(p.phone == "") == (phoneIndex == "") && (p.idCard == "") == (idCardIndex == "") {
+			continue
+		}
+		patients = append(patients, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range patients {
+		for _, value := range []*string{&p.phone, &p.address, &p.idCard, &p.medicalHistory} {
+			if *value, err = from.decrypt(*value); err != nil {
+				return 0, err
+			}
+		}
+		values, err := to.patientPII(p.phone, p.address, p.idCard, p.medicalHistory)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`
+			UPDATE patients SET phone = ?, address = ?, id_card = ?, medical_history = ?,
+			phone_bidx = ?, phone_tail_bidx = ?, id_card_bidx = ?, id_card_tail_bidx = ?
+			WHERE id = ?`,
+			values.Phone, values.Address, values.IDCard, values.MedicalHistory,
+			values.PhoneIndex, values.PhoneTailIndex, values.IDCardIndex, values.IDCardTailIndex, p.id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	count := len(patients)
+
+	// 麻精药品登记中的患者身份证号、档案合并记录中被合并档案的快照
+	for _, column := range []struct{ table, column string }{
+		{"controlled_drug_register", "patient_id_card"},
+		{"patient_merges", "merged_patient"},
+	} {
+		n, err := reencryptColumn(tx, from, to, column.table, column.column, all)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// reencryptColumn 用 from 解密表中单个敏感字段后以 to 重新加密；all 为 false 时只加密明文
+func reencryptColumn(tx *sql.Tx, from, to *piiCipher, table, column string, all bool) (int, error) {
+	rows, err := tx.Query("SELECT id, " + column + " FROM " + table + " WHERE " + column + " != ''")
+	if err != nil {
+		return 0, err
+	}
+	type record struct {
+		id    int
+		value string
+	}
+	var records []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if all || !piiEncrypted(r.value) {
+			records = append(records, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range records {
+		value, err := from.decrypt(r.value)
+		if err != nil {
+			return 0, err
+		}
+		if value, err = to.encrypt(value); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE "+table+" SET "+column+" = ? WHERE id = ?", value, r.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(records), nil
+}
+
+// piiEncrypted 各字段均为空或已加密
+func piiEncrypted(values ...string) bool {
+	for _, value := range values {
+		if value != "" && !strings.HasPrefix(value, piiPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// RotatePIIKey 生成新的患者信息密钥，在一个事务内用新密钥重新加密全部敏感字段并重算查询索引。
+// 新密钥先写入“密钥文件.new”，提交成功后替换原密钥文件，原密钥改名保留，用于恢复轮换前的备份
+func RotatePIIKey() error {
+	_, keyFile, err := loadPIIKey()
+	if err != nil {
+		return err
+	}
+
+	newKey := make([]byte, piiKeySize)
+	if _, err := rand.Read(newKey); err != nil {
+		return fmt.Errorf("生成患者信息密钥失败：%w", err)
+	}
+	next, err := newPIICipher(newKey)
+	if err != nil {
+		return err
+	}
+
+	newKeyFile := piiKeyFile() + ".new"
+	if keyFile != "" {
+		newKeyFile = keyFile + ".new"
+	}
+	if err := writePIIKeyFile(newKeyFile, newKey); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	count, err := reencryptPII(tx, pii, next, true)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	pii = next
+	log.Printf("已用新密钥重新加密 %d 条记录", count)
+
+	if keyFile == "" {
+		log.Printf("当前密钥来自环境变量 %s，新密钥已写入 %s，请将环境变量更新为该文件内容后删除此文件", piiKeyEnv, newKeyFile)
+		return nil
+	}
+	oldKeyFile := keyFile + ".old-" + time.Now().Format("20060102150405")
+	if err := os.Rename(keyFile, oldKeyFile); err != nil {
+		return fmt.Errorf("数据已使用 %s 中的新密钥加密，但替换密钥文件失败：%v", newKeyFile, err)
+	}
+	if err := os.Rename(newKeyFile, keyFile); err != nil {
+		return fmt.Errorf("数据已使用 %s 中的新密钥加密，但替换密钥文件失败：%v", newKeyFile, err)
+	}
+	log.Printf("新密钥已写入 %s，原密钥保存为 %s，轮换前的数据库备份需用原密钥恢复", keyFile, oldKeyFile)
+	return nil
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestCipher 生成随机密钥的加密器
+func newTestCipher(t *testing.T) *piiCipher {
+	t.Helper()
+	key := make([]byte, piiKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	pc, err := newPIICipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+// openTestDB 打开内存数据库并建表，密钥文件写在临时目录中
+func openTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv(piiKeyEnv, "")
+	t.Setenv(piiKeyFileEnv, filepath.Join(t.TempDir(), "clinic.key"))
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存数据库每个连接各自独立，只保留一个连接
+	db.SetMaxOpenConns(1)
+	DB = db
+	t.Cleanup(func() {
+		db.Close()
+		DB = nil
+		pii = nil
+	})
+
+	createTables()
+	initPII()
+}
+
+func TestPIIEncryptDecrypt(t *testing.T) {
+	pc := newTestCipher(t)
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"电话", "13800001111"},
+		{"身份证号", "11010519491231002X"},
+		{"中文地址", "北京市东城区某某胡同1号"},
+		{"空值", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := pc.encrypt(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.value == "" {
+				if encrypted != "" {
+					t.Fatalf("空值不应加密，得到 %q", encrypted)
+				}
+				return
+			}
+			if !strings.HasPrefix(encrypted, piiPrefix) || strings.Contains(encrypted, tt.value) {
+				t.Fatalf("密文格式不正确：%q", encrypted)
+			}
+			decrypted, err := pc.decrypt(encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decrypted != tt.value {
+				t.Fatalf("解密得到 %q，应为 %q", decrypted, tt.value)
+			}
+		})
+	}
+}
+
+func TestPIIDecryptPlaintext(t *testing.T) {
+	// 升级前的明文原样返回
+	pc := newTestCipher(t)
+	got, err := pc.decrypt("13800001111")
+	if err != nil || got != "13800001111" {
+		t.Fatalf("明文应原样返回，得到 %q, %v", got, err)
+	}
+}
+
+func TestPIIEncryptUsesRandomNonce(t *testing.T) {
+	pc := newTestCipher(t)
+	first, err := pc.encrypt("13800001111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pc.encrypt("13800001111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("同一值两次加密的密文不应相同")
+	}
+
+	// 查询索引与随机数无关，同一值始终相同
+	if pc.blindIndex("phone", "13800001111") != pc.blindIndex("phone", "13800001111") {
+		t.Fatal("同一值的查询索引应相同")
+	}
+}
+
+func TestPIIWrongKey(t *testing.T) {
+	pc := newTestCipher(t)
+	other := newTestCipher(t)
+	encrypted, err := pc.encrypt("11010519491231002X")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"其他密钥的密文", encrypted},
+		{"非Base64", piiPrefix + "!!!"},
+		{"长度不足", piiPrefix + base64.StdEncoding.EncodeToString([]byte("short"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := other.decrypt(tt.value); err != ErrPIIKeyMismatch {
+				t.Fatalf("应返回 ErrPIIKeyMismatch，得到 %v", err)
+			}
+		})
+	}
+
+	if pc.blindIndex("phone", "13800001111") == other.blindIndex("phone", "13800001111") {
+		t.Fatal("不同密钥的查询索引不应相同")
+	}
+}
+
+func TestRotatePIIKeyKeepsLookup(t *testing.T) {
+	openTestDB(t)
+
+	const phone, idCard = "13800001111", "11010519491231002x"
+	values, err := EncryptPatientPII(phone, "北京市东城区", idCard, "高血压")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := DB.Exec(`
+		INSERT INTO patients (name, gender, age, phone, address, id_card, medical_history,
+		phone_bidx, phone_tail_bidx, id_card_bidx, id_card_tail_bidx)
+		VALUES ('张三', '女', 76, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		values.Phone, values.Address, values.IDCard, values.MedicalHistory,
+		values.PhoneIndex, values.PhoneTailIndex, values.IDCardIndex, values.IDCardTailIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patientID, _ := result.LastInsertId()
+
+	oldKey, err := os.ReadFile(piiKeyFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RotatePIIKey(); err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := os.ReadFile(piiKeyFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(oldKey, newKey) {
+		t.Fatal("轮换后密钥文件应为新密钥")
+	}
+
+	// 轮换后按完整号码、后4位查找仍能找到患者，身份证号末位 x 不区分大小写
+	phoneIndex, phoneTail := PhoneIndexes(phone)
+	idCardIndex, idCardTail := IDCardIndexes(strings.ToUpper(idCard))
+	lookups := []struct {
+		name   string
+		column string
+		index  string
+	}{
+		{"完整电话", "phone_bidx", phoneIndex},
+		{"电话后4位", "phone_tail_bidx", phoneTail},
+		{"完整身份证号", "id_card_bidx", idCardIndex},
+		{"身份证号后4位", "id_card_tail_bidx", idCardTail},
+	}
+	for _, lookup := range lookups {
+		t.Run(lookup.name, func(t *testing.T) {
+			var id int64
+			err := DB.QueryRow("SELECT id FROM patients WHERE "+lookup.column+" = ?", lookup.index).Scan(&id)
+			if err != nil || id != patientID {
+				t.Fatalf("按%s未找到患者：id=%d, err=%v", lookup.name, id, err)
+			}
+		})
+	}
+
+	var storedPhone, storedIDCard string
+	if err := DB.QueryRow("SELECT phone, id_card FROM patients WHERE id = ?", patientID).Scan(&storedPhone, &storedIDCard); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecryptPII(storedPhone); err != nil || got != phone {
+		t.Fatalf("新密钥解密电话得到 %q, %v", got, err)
+	}
+	if got, err := DecryptPII(storedIDCard); err != nil || got != strings.ToUpper(idCard) {
+		t.Fatalf("新密钥解密身份证号得到 %q, %v", got, err)
+	}
+
+	// 原密钥不能再解密轮换后的数据
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(oldKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := newPIICipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := previous.decrypt(storedPhone); err != ErrPIIKeyMismatch {
+		t.Fatalf("原密钥解密轮换后的密文应失败，得到 %v", err)
+	}
+}
@@ -21,6 +21,14 @@ func main() {
 	database.InitDB()
 	log.Println("数据库初始化完成")
 
+	// 轮换患者信息密钥：停止服务后执行 lighthospital rotate-key
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		if err := database.RotatePIIKey(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// 设置Gin模式
 	gin.SetMode(gin.ReleaseMode)
 
@@ -74,6 +82,11 @@ func main() {
 		authorized := api.Group("/")
 		authorized.Use(middleware.AuthRequired())
 		{
+			// 开具处方、书写病历需有处方权；药师负责审核发药，与护士一样不能开方
+			prescriber := middleware.RoleRequired("admin", "doctor")
+			// 录入生命体征
+			vitalRecorder := middleware.RoleRequired("admin", "doctor", "nurse")
+
 			// 患者管理
 			patients := authorized.Group("/patients")
 			{
@@ -85,6 +98,26 @@ func main() {
 				patients.GET("", patientController.List)
 				patients.POST("/search", patientController.Search)
 				patients.POST("/find-or-create", middleware.OperationLogger("快速查找或创建", "患者"), patientController.FindOrCreateByName)
+				patients.GET("/duplicates", patientController.ScanDuplicates)
+				patients.GET("/merges", middleware.RoleRequired("admin"), patientController.ListMerges)
+				patients.GET("/:id/duplicates", patientController.Duplicates)
+				patients.POST("/:id/merge", middleware.RoleRequired("admin"), middleware.OperationLogger("合并档案", "患者"), patientController.Merge)
+				patients.POST("/:id/reveal", middleware.OperationLogger("查看敏感信息", "患者"), patientController.Reveal)
+				patients.GET("/:id/allergies", patientController.ListAllergies)
+				patients.POST("/:id/allergies", middleware.OperationLogger("添加过敏记录", "患者"), patientController.CreateAllergy)
+				patients.PUT("/:id/allergies/:allergyId", middleware.OperationLogger("更新过敏记录", "患者"), patientController.UpdateAllergy)
+				patients.DELETE("/:id/allergies/:allergyId", middleware.OperationLogger("删除过敏记录", "患者"), patientController.DeleteAllergy)
+				patients.GET("/:id/timeline", patientController.Timeline)
+				patients.GET("/:id/encounters", patientController.ListEncounters)
+				patients.GET("/:id/vitals", patientController.ListVitalSigns)
+				patients.GET("/:id/vitals/trend", patientController.VitalSignTrend)
+				patients.POST("/:id/vitals", vitalRecorder, middleware.OperationLogger("录入生命体征", "患者"), patientController.CreateVitalSign)
+				patients.DELETE("/:id/vitals/:vitalId", vitalRecorder, middleware.OperationLogger("删除生命体征", "患者"), patientController.DeleteVitalSign)
+				patients.GET("/:id/attachments", patientController.ListAttachments)
+				patients.POST("/:id/attachments", middleware.OperationLogger("上传附件", "患者"), patientController.UploadAttachment)
+				patients.GET("/:id/attachments/:attachmentId", middleware.OperationLogger("下载附件", "患者"), patientController.DownloadAttachment)
+				patients.GET("/:id/attachments/:attachmentId/thumbnail", patientController.AttachmentThumbnail)
+				patients.DELETE("/:id/attachments/:attachmentId", middleware.OperationLogger("删除附件", "患者"), patientController.DeleteAttachment)
 			}
 
 			// 药品管理
@@ -101,19 +134,91 @@ func main() {
 				medicines.PUT("/:id/stock", middleware.OperationLogger("更新库存", "药品"), medicineController.UpdateStock)
 				medicines.GET("/categories", medicineController.GetCategories)
 				medicines.GET("/low-stock", medicineController.GetLowStock)
+				medicines.GET("/stock-summary", medicineController.GetStockSummary)
+				medicines.GET("/expiring", medicineController.GetExpiringBatches)
+				medicines.GET("/:id/batches", medicineController.ListBatches)
+				medicines.POST("/:id/batches", middleware.OperationLogger("批次入库", "药品"), medicineController.ReceiveBatch)
+				medicines.GET("/:id/suggested-dose", medicineController.SuggestDose)
+				medicines.GET("/:id/movements", medicineController.ListStockMovements)
+				medicines.POST("/:id/movements", middleware.OperationLogger("登记库存变动", "药品"), medicineController.CreateStockMovement)
 			}
 
 			// 处方管理
 			prescriptions := authorized.Group("/prescriptions")
 			{
 				prescriptionController := &controllers.PrescriptionController{}
-				prescriptions.POST("", middleware.OperationLogger("创建", "处方"), prescriptionController.Create)
+				prescriptions.POST("", prescriber, middleware.OperationLogger("创建", "处方"), prescriptionController.Create)
 				prescriptions.GET("/:id", prescriptionController.Get)
-				prescriptions.PUT("/:id", middleware.OperationLogger("更新", "处方"), prescriptionController.Update)
-				prescriptions.DELETE("/:id", middleware.OperationLogger("删除", "处方"), prescriptionController.Delete)
+				prescriptions.PUT("/:id", prescriber, middleware.OperationLogger("更新", "处方"), prescriptionController.Update)
+				prescriptions.DELETE("/:id", prescriber, middleware.OperationLogger("删除或作废", "处方"), prescriptionController.Delete)
 				prescriptions.GET("", prescriptionController.List)
 				prescriptions.POST("/search", prescriptionController.Search)
-				prescriptions.PUT("/:id/status", middleware.OperationLogger("更新状态", "处方"), prescriptionController.UpdateStatus)
+				prescriptions.PUT("/:id/status", prescriber, middleware.OperationLogger("更新状态", "处方"), prescriptionController.UpdateStatus)
+				prescriptions.POST("/:id/amend", prescriber, middleware.OperationLogger("修订", "处方"), prescriptionController.Amend)
+				prescriptions.POST("/:id/clone", prescriber, middleware.OperationLogger("复制", "处方"), prescriptionController.Clone)
+				prescriptions.GET("/:id/versions", prescriptionController.Versions)
+			}
+
+			// 协定处方模板
+			templates := authorized.Group("/prescription-templates")
+			{
+				templateController := &controllers.PrescriptionTemplateController{}
+				templates.POST("", prescriber, middleware.OperationLogger("创建", "处方模板"), templateController.Create)
+				templates.GET("/:id", templateController.Get)
+				templates.PUT("/:id", prescriber, middleware.OperationLogger("更新", "处方模板"), templateController.Update)
+				templates.DELETE("/:id", prescriber, middleware.OperationLogger("删除", "处方模板"), templateController.Delete)
+				templates.GET("", templateController.List)
+				templates.POST("/:id/instantiate", prescriber, middleware.OperationLogger("按模板开具", "处方"), templateController.Instantiate)
+			}
+
+			// 药物相互作用规则，维护操作仅限管理员
+			interactions := authorized.Group("/drug-interactions")
+			{
+				interactionController := &controllers.DrugInteractionController{}
+				interactions.GET("", interactionController.List)
+				interactions.POST("", middleware.RoleRequired("admin"), middleware.OperationLogger("创建", "相互作用规则"), interactionController.Create)
+				interactions.PUT("/:id", middleware.RoleRequired("admin"), middleware.OperationLogger("更新", "相互作用规则"), interactionController.Update)
+				interactions.DELETE("/:id", middleware.RoleRequired("admin"), middleware.OperationLogger("删除", "相互作用规则"), interactionController.Delete)
+				interactions.POST("/import", middleware.RoleRequired("admin"), middleware.OperationLogger("导入", "相互作用规则"), interactionController.Import)
+			}
+
+			// ICD-10 诊断字典，维护操作仅限管理员
+			diagnoses := authorized.Group("/diagnoses")
+			{
+				diagnosisController := &controllers.DiagnosisController{}
+				diagnoses.GET("", diagnosisController.List)
+				diagnoses.GET("/lookup", diagnosisController.Lookup)
+				diagnoses.POST("", middleware.RoleRequired("admin"), middleware.OperationLogger("创建", "诊断字典"), diagnosisController.Create)
+				diagnoses.PUT("/:id", middleware.RoleRequired("admin"), middleware.OperationLogger("更新", "诊断字典"), diagnosisController.Update)
+				diagnoses.POST("/import", middleware.RoleRequired("admin"), middleware.OperationLogger("导入", "诊断字典"), diagnosisController.Import)
+			}
+
+			// 药品管理类别开方规则及麻精药品专用登记
+			drugClassController := &controllers.DrugClassController{}
+			authorized.GET("/drug-class-rules", drugClassController.ListRules)
+			authorized.PUT("/drug-class-rules/:class", middleware.RoleRequired("admin"), middleware.OperationLogger("更新", "开方规则"), drugClassController.UpdateRule)
+			authorized.GET("/controlled-drug-register", middleware.RoleRequired("pharmacist", "admin"), drugClassController.Register)
+
+			// 药房审核与发药，仅限药师和管理员
+			pharmacy := authorized.Group("/pharmacy")
+			pharmacy.Use(middleware.RoleRequired("pharmacist", "admin"))
+			{
+				pharmacyController := &controllers.PharmacyController{}
+				pharmacy.GET("/queue", pharmacyController.Queue)
+				pharmacy.POST("/prescriptions/:id/approve", middleware.OperationLogger("审核通过", "处方"), pharmacyController.Approve)
+				pharmacy.POST("/prescriptions/:id/reject", middleware.OperationLogger("审核驳回", "处方"), pharmacyController.Reject)
+				pharmacy.POST("/prescriptions/:id/dispense", middleware.OperationLogger("发药", "处方"), pharmacyController.Dispense)
+			}
+
+			// 门诊病历
+			encounters := authorized.Group("/encounters")
+			{
+				encounterController := &controllers.EncounterController{}
+				encounters.POST("", prescriber, middleware.OperationLogger("创建", "病历"), encounterController.Create)
+				encounters.GET("/:id", encounterController.Get)
+				encounters.PUT("/:id", prescriber, middleware.OperationLogger("更新", "病历"), encounterController.Update)
+				encounters.POST("/:id/sign", prescriber, middleware.OperationLogger("签名", "病历"), encounterController.Sign)
+				encounters.GET("", encounterController.List)
 			}
 
 			// 预约管理
@@ -136,6 +241,7 @@ func main() {
 				printController := &controllers.PrintController{}
 				print.GET("/prescription/:id", printController.PrintPrescription)
 				print.GET("/appointment/:id", printController.PrintAppointment)
+				print.GET("/encounter/:id", printController.PrintEncounter)
 			}
 
 			// 医生管理（仅管理员）
@@ -151,6 +257,10 @@ func main() {
 				doctors.POST("/:id/reset-password", doctorController.ResetPassword)
 			}
 
+			// 数据备份（仅管理员），打包数据库和附件目录
+			backupController := &controllers.BackupController{}
+			authorized.GET("/backup", middleware.RoleRequired("admin"), middleware.OperationLogger("下载备份", "系统"), backupController.Download)
+
 			// 统计信息
 			stats := authorized.Group("/stats")
 			{
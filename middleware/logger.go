@@ -8,6 +8,14 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// operationDescriptionKey 处理函数补充的操作说明在上下文中的键
+const operationDescriptionKey = "operation_description"
+
+// SetOperationDescription 为当前请求的操作日志补充说明，如查看敏感信息的原因
+func SetOperationDescription(c *gin.Context, description string) {
+	c.Set(operationDescriptionKey, description)
+}
+
 func OperationLogger(action, module string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 先执行请求
@@ -17,13 +25,14 @@ func OperationLogger(action, module string) gin.HandlerFunc {
 		session := sessions.Default(c)
 		userID := session.Get("user_id")
 		username := session.Get("username")
+		description := c.GetString(operationDescriptionKey)
 
 		if userID != nil {
 			go func() {
 				_, err := database.DB.Exec(`
 					INSERT INTO operation_logs (user_id, username, action, module, description, ip, user_agent, created_at)
 					VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-					userID, username, action, module, "", c.ClientIP(), c.Request.UserAgent(), time.Now())
+					userID, username, action, module, description, c.ClientIP(), c.Request.UserAgent(), time.Now())
 				if err != nil {
 					// 日志记录失败不影响主流程
 					return
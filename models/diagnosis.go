@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// 编码诊断所属的记录类型
+const (
+	DiagnosisRecordPrescription = "prescription" // 处方
+	DiagnosisRecordEncounter    = "encounter"    // 门诊病历
+)
+
+// DiagnosisCode ICD-10 诊断字典条目，拼音和首字母用于检索
+type DiagnosisCode struct {
+	ID        int       `json:"id" db:"id"`
+	Code      string    `json:"code" db:"code"` // ICD-10 编码，如 J06.900
+	Name      string    `json:"name" db:"name"`
+	Pinyin    string    `json:"pinyin" db:"pinyin"`
+	Initials  string    `json:"initials" db:"initials"`
+	Enabled   bool      `json:"enabled" db:"enabled"` // 停用的诊断不能再用于新开处方和病历
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// CodedDiagnosis 处方或病历上的编码诊断，名称保存开具时的字典名称
+type CodedDiagnosis struct {
+	Code      string `json:"code" db:"code"`
+	Name      string `json:"name" db:"name"`
+	IsPrimary bool   `json:"is_primary" db:"is_primary"` // 每条记录有且仅有一个主要诊断，其余为次要诊断
+}
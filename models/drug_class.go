@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+)
+
+// 药品管理类别
+const (
+	DrugClassOTCA          = "otc_a"          // 甲类非处方药
+	DrugClassOTCB          = "otc_b"          // 乙类非处方药
+	DrugClassRx            = "rx"             // 处方药
+	DrugClassPsychotropic2 = "psychotropic_2" // 第二类精神药品
+	DrugClassPsychotropic1 = "psychotropic_1" // 第一类精神药品
+	DrugClassNarcotic      = "narcotic"       // 麻醉药品
+)
+
+// DrugClassNames 药品管理类别中文名称
+var DrugClassNames = map[string]string{
+	DrugClassOTCA:          "甲类非处方药",
+	DrugClassOTCB:          "乙类非处方药",
+	DrugClassRx:            "处方药",
+	DrugClassPsychotropic2: "第二类精神药品",
+	DrugClassPsychotropic1: "第一类精神药品",
+	DrugClassNarcotic:      "麻醉药品",
+}
+
+// IsControlledDrugClass 是否为麻醉药品、精神药品，需有麻精药品处方权并专册登记
+func IsControlledDrugClass(class string) bool {
+	return class == DrugClassNarcotic || class == DrugClassPsychotropic1 || class == DrugClassPsychotropic2
+}
+
+// 抗菌药物分级，空表示非抗菌药物
+const (
+	AntibioticNonRestricted = "non_restricted" // 非限制使用级
+	AntibioticRestricted    = "restricted"     // 限制使用级
+	AntibioticSpecial       = "special"        // 特殊使用级
+)
+
+// AntibioticLevelNames 抗菌药物分级中文名称
+var AntibioticLevelNames = map[string]string{
+	AntibioticNonRestricted: "非限制使用级",
+	AntibioticRestricted:    "限制使用级",
+	AntibioticSpecial:       "特殊使用级",
+}
+
+// AntibioticLevelRank 抗菌药物分级高低，医生的处方权级别不低于药品级别才能开具
+var AntibioticLevelRank = map[string]int{
+	AntibioticNonRestricted: 1,
+	AntibioticRestricted:    2,
+	AntibioticSpecial:       3,
+}
+
+// 处方笺类别，决定打印颜色及右上角标注
+const (
+	PrescriptionFormNormal        = "normal"         // 普通处方，白色
+	PrescriptionFormNarcotic      = "narcotic"       // 麻醉药品和第一类精神药品处方，淡红色，标注“麻、精一”
+	PrescriptionFormPsychotropic2 = "psychotropic_2" // 第二类精神药品处方，白色，标注“精二”
+)
+
+// DrugClassRule 药品管理类别的开方规则，0 表示不限制
+type DrugClassRule struct {
+	DrugClass     string    `json:"drug_class" db:"drug_class"`
+	MaxDays       int       `json:"max_days" db:"max_days"`               // 单张处方最大用药天数
+	RequireIDCard bool      `json:"require_id_card" db:"require_id_card"` // 是否须登记患者身份证号
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ControlledDrugRecord 麻醉药品、精神药品发药专用登记
+type ControlledDrugRecord struct {
+	ID             int       `json:"id" db:"id"`
+	PrescriptionID int       `json:"prescription_id" db:"prescription_id"`
+	PrescriptionNo string    `json:"prescription_no" db:"prescription_no"`
+	MedicineID     int       `json:"medicine_id" db:"medicine_id"`
+	MedicineName   string    `json:"medicine_name" db:"medicine_name"`
+	Specification  string    `json:"specification" db:"specification"`
+	DrugClass      string    `json:"drug_class" db:"drug_class"`
+	Quantity       int       `json:"quantity" db:"quantity"`
+	PatientID      int       `json:"patient_id" db:"patient_id"`
+	PatientName    string    `json:"patient_name" db:"patient_name"`
+	PatientIDCard  string    `json:"patient_id_card" db:"patient_id_card"`
+	DoctorID       int       `json:"doctor_id" db:"doctor_id"`
+	DoctorName     string    `json:"doctor_name" db:"doctor_name"`
+	DispensedBy    int       `json:"dispensed_by" db:"dispensed_by"`
+	DispenserName  string    `json:"dispenser_name" db:"dispenser_name"`
+	DispensedAt    time.Time `json:"dispensed_at" db:"dispensed_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// 相互作用规则的对象类型
+const (
+	InteractionMedicine = "medicine" // 药品名称
+	InteractionCategory = "category" // 药品分类
+)
+
+// DrugInteraction 药物相互作用规则，双方任意顺序出现在同一处方中即命中
+type DrugInteraction struct {
+	ID        int       `json:"id" db:"id"`
+	TypeA     string    `json:"type_a" db:"type_a"` // medicine, category
+	NameA     string    `json:"name_a" db:"name_a"`
+	TypeB     string    `json:"type_b" db:"type_b"` // medicine, category
+	NameB     string    `json:"name_b" db:"name_b"`
+	Severity  string    `json:"severity" db:"severity"` // mild, moderate, severe
+	Message   string    `json:"message" db:"message"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
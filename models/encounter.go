@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// 门诊病历状态
+const (
+	EncounterDraft  = "draft"  // 未签名，可修改
+	EncounterSigned = "signed" // 医生已签名，不可修改
+)
+
+// Encounter 门诊病历，按 SOAP 记录一次就诊，本次就诊开具的处方关联到病历
+type Encounter struct {
+	ID             int        `json:"id" db:"id"`
+	PatientID      int        `json:"patient_id" db:"patient_id"`
+	DoctorID       int        `json:"doctor_id" db:"doctor_id"`
+	AppointmentID  int        `json:"appointment_id" db:"appointment_id"` // 来源预约，0 表示直接就诊
+	VisitTime      time.Time  `json:"visit_time" db:"visit_time"`
+	ChiefComplaint string     `json:"chief_complaint" db:"chief_complaint"` // 主诉（S）
+	PresentIllness string     `json:"present_illness" db:"present_illness"` // 现病史（S）
+	PastHistory    string     `json:"past_history" db:"past_history"`       // 既往史（S）
+	PhysicalExam   string     `json:"physical_exam" db:"physical_exam"`     // 体格检查（O）
+	Diagnosis      string     `json:"diagnosis" db:"diagnosis"`             // 诊断（A）
+	Plan           string     `json:"plan" db:"plan"`                       // 处理意见（P）
+	Status         string     `json:"status" db:"status"`                   // draft, signed
+	SignedBy       int        `json:"signed_by" db:"signed_by"`
+	SignedAt       *time.Time `json:"signed_at,omitempty" db:"signed_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+
+	// ICD-10 编码诊断，主要诊断在前；Diagnosis 作为自由文本补充说明
+	Diagnoses []CodedDiagnosis `json:"diagnoses"`
+
+	// 关联数据
+	PatientName   string         `json:"patient_name,omitempty"`
+	DoctorName    string         `json:"doctor_name,omitempty"`
+	Prescriptions []Prescription `json:"prescriptions,omitempty"`
+	VitalSigns    []VitalSign    `json:"vital_signs,omitempty"`
+}
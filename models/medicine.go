@@ -14,8 +14,27 @@ type Medicine struct {
 	MinStock    int       `json:"min_stock" db:"min_stock"`
 	Category    string    `json:"category" db:"category"`
 	Manufacturer string   `json:"manufacturer" db:"manufacturer"`
+	Ingredients string    `json:"ingredients" db:"ingredients"` // 主要成分，多个用逗号分隔
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+
+	// 管理类别及抗菌药物分级
+	DrugClass       string `json:"drug_class" db:"drug_class"`             // otc_a, otc_b, rx, psychotropic_2, psychotropic_1, narcotic
+	AntibioticLevel string `json:"antibiotic_level" db:"antibiotic_level"` // 空表示非抗菌药物
+
+	// 剂量规则，仅在查询单个药品时返回
+	DosingRule *MedicineDosingRule `json:"dosing_rule,omitempty"`
+}
+
+// MedicineDosingRule 药品剂量规则，剂量均以 DoseUnit 为单位，0 表示不限制
+type MedicineDosingRule struct {
+	MedicineID    int       `json:"medicine_id" db:"medicine_id"`
+	DoseUnit      string    `json:"dose_unit" db:"dose_unit"`             // 剂量单位，如 mg、ml
+	MaxSingleDose float64   `json:"max_single_dose" db:"max_single_dose"` // 单次最大剂量
+	MaxDailyDose  float64   `json:"max_daily_dose" db:"max_daily_dose"`   // 每日最大剂量
+	DosePerKg     float64   `json:"dose_per_kg" db:"dose_per_kg"`         // 按体重计算的单次剂量（每公斤）
+	MinAgeMonths  int       `json:"min_age_months" db:"min_age_months"`   // 最低用药年龄（月），如 6 表示不满6个月不宜使用
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
 }
 
 type MedicineSearch struct {
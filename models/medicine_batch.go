@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+type MedicineBatch struct {
+	ID         int       `json:"id" db:"id"`
+	MedicineID int       `json:"medicine_id" db:"medicine_id"`
+	BatchNo    string    `json:"batch_no" db:"batch_no"`
+	ExpiryDate string    `json:"expiry_date" db:"expiry_date"` // 有效期至，格式 2006-01-02，为空表示未登记
+	Quantity   int       `json:"quantity" db:"quantity"`
+	Expired    bool      `json:"expired"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+
+	// 关联数据
+	Medicine *Medicine `json:"medicine,omitempty"`
+}
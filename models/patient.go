@@ -1,25 +1,120 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
+// BirthDateLayout 出生日期的保存格式
+const BirthDateLayout = "2006-01-02"
+
 type Patient struct {
 	ID             int       `json:"id" db:"id"`
 	Name           string    `json:"name" db:"name"`
 	Pinyin         string    `json:"pinyin" db:"pinyin"`
 	Gender         string    `json:"gender" db:"gender"`
-	Age            int       `json:"age" db:"age"`
+	Age            int       `json:"age" db:"age"` // 周岁，读取时按出生日期计算；未登记出生日期时为建档时录入的年龄
 	Phone          string    `json:"phone" db:"phone"`
 	Address        string    `json:"address" db:"address"`
 	IDCard         string    `json:"id_card" db:"id_card"`
 	MedicalHistory string    `json:"medical_history" db:"medical_history"`
 	CreatedAt      time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+
+	// 出生日期，格式 2006-01-02；只录入年龄时按年龄推算并标记为估算
+	BirthDate          string `json:"birth_date" db:"birth_date"`
+	BirthDateEstimated bool   `json:"birth_date_estimated" db:"birth_date_estimated"`
+	AgeText            string `json:"age_text"` // 按年龄段显示的年龄，如 15天、3个月、2岁3个月、30岁
+
+	Masked bool `json:"masked,omitempty"` // 电话、身份证号已按角色脱敏
 }
 
 type PatientSearch struct {
 	Name   string `json:"name"`
 	Phone  string `json:"phone"`
 	IDCard string `json:"id_card"`
+	MinAge *int   `json:"min_age"` // 按计算年龄（周岁）筛选
+	MaxAge *int   `json:"max_age"`
+}
+
+// AgeOn 计算出生日期到指定日期的周岁，以及不足一岁部分的月数和天数
+func AgeOn(birth, on time.Time) (years, months, days int) {
+	birth = time.Date(birth.Year(), birth.Month(), birth.Day(), 0, 0, 0, 0, time.UTC)
+	on = time.Date(on.Year(), on.Month(), on.Day(), 0, 0, 0, 0, time.UTC)
+	if on.Before(birth) {
+		return 0, 0, 0
+	}
+
+	totalMonths := (on.Year()-birth.Year())*12 + int(on.Month()-birth.Month())
+	if on.Day() < birth.Day() {
+		totalMonths--
+	}
+	anchor := birth.AddDate(0, totalMonths, 0)
+	// 出生日为月末时 AddDate 会溢出到下月，此时少算一个月
+	for anchor.After(on) {
+		totalMonths--
+		anchor = birth.AddDate(0, totalMonths, 0)
+	}
+	return totalMonths / 12, totalMonths % 12, int(on.Sub(anchor).Hours() / 24)
+}
+
+// FormatAge 按年龄段显示年龄：不满1个月显示天数，不满1岁显示月数，不满6岁显示岁和月，其余显示周岁
+func FormatAge(years, months, days int) string {
+	switch {
+	case years == 0 && months == 0:
+		return fmt.Sprintf("%d天", days)
+	case years == 0:
+		return fmt.Sprintf("%d个月", months)
+	case years < 6 && months > 0:
+		return fmt.Sprintf("%d岁%d个月", years, months)
+	default:
+		return fmt.Sprintf("%d岁", years)
+	}
+}
+
+// FillAge 按出生日期计算截至 now 的年龄，填写 Age 和 AgeText
+func (p *Patient) FillAge(now time.Time) {
+	birth, err := time.ParseInLocation(BirthDateLayout, p.BirthDate, time.Local)
+	if err != nil {
+		if p.Age > 0 {
+			p.AgeText = fmt.Sprintf("%d岁", p.Age)
+		}
+		return
+	}
+	years, months, days := AgeOn(birth, now)
+	p.Age = years
+	p.AgeText = FormatAge(years, months, days)
+}
+
+// MaskPhone 电话号码脱敏，保留前3位和后4位，如 138****1234
+func MaskPhone(phone string) string {
+	return maskMiddle(phone, 3, 4)
+}
+
+// MaskIDCard 身份证号脱敏，保留前4位和后4位，如 3201**********1234
+func MaskIDCard(idCard string) string {
+	return maskMiddle(idCard, 4, 4)
+}
+
+// maskMiddle 保留首尾若干位，中间替换为 *；号码过短时只保留末2位
+func maskMiddle(value string, head, tail int) string {
+	runes := []rune(value)
+	if len(runes) == 0 {
+		return value
+	}
+	if len(runes) <= head+tail {
+		head, tail = 0, 2
+		if len(runes) <= tail {
+			tail = 0
+		}
+	}
+	return string(runes[:head]) + strings.Repeat("*", len(runes)-head-tail) + string(runes[len(runes)-tail:])
+}
+
+// MaskPII 电话和身份证号脱敏
+func (p *Patient) MaskPII() {
+	p.Phone = MaskPhone(p.Phone)
+	p.IDCard = MaskIDCard(p.IDCard)
+	p.Masked = true
 }
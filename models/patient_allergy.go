@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// 过敏原类型
+const (
+	AllergenMedicine   = "medicine"   // 具体药品
+	AllergenCategory   = "category"   // 药品分类
+	AllergenIngredient = "ingredient" // 成分
+)
+
+// 严重程度
+const (
+	SeverityMild     = "mild"     // 轻度
+	SeverityModerate = "moderate" // 中度
+	SeveritySevere   = "severe"   // 重度
+)
+
+type PatientAllergy struct {
+	ID           int        `json:"id" db:"id"`
+	PatientID    int        `json:"patient_id" db:"patient_id"`
+	AllergenType string     `json:"allergen_type" db:"allergen_type"` // medicine, category, ingredient
+	MedicineID   int        `json:"medicine_id" db:"medicine_id"`     // 过敏原为具体药品时填写
+	Allergen     string     `json:"allergen" db:"allergen"`           // 药品名称、分类或成分
+	Reaction     string     `json:"reaction" db:"reaction"`
+	Severity     string     `json:"severity" db:"severity"` // mild, moderate, severe
+	Notes        string     `json:"notes" db:"notes"`
+	CreatedBy    int        `json:"created_by" db:"created_by"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
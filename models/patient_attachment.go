@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// 附件类别
+const (
+	AttachmentLabReport = "lab_report" // 检验报告
+	AttachmentImaging   = "imaging"    // 影像报告，如 X 光、B 超
+	AttachmentScan      = "scan"       // 其他纸质资料扫描件
+	AttachmentOther     = "other"
+)
+
+// AttachmentCategoryNames 附件类别及中文名称
+var AttachmentCategoryNames = map[string]string{
+	AttachmentLabReport: "检验报告",
+	AttachmentImaging:   "影像报告",
+	AttachmentScan:      "扫描件",
+	AttachmentOther:     "其他",
+}
+
+// PatientAttachment 患者附件，文件按内容的 SHA-256 保存在附件目录中，内容相同的文件只存一份
+type PatientAttachment struct {
+	ID             int       `json:"id" db:"id"`
+	PatientID      int       `json:"patient_id" db:"patient_id"`
+	PrescriptionID int       `json:"prescription_id" db:"prescription_id"` // 关联的处方，0 表示未关联
+	EncounterID    int       `json:"encounter_id" db:"encounter_id"`       // 关联的门诊病历，0 表示未关联
+	Category       string    `json:"category" db:"category"`
+	FileName       string    `json:"file_name" db:"file_name"` // 上传时的文件名
+	MimeType       string    `json:"mime_type" db:"mime_type"` // 按文件内容识别的类型
+	Size           int64     `json:"size" db:"size"`
+	SHA256         string    `json:"sha256" db:"sha256"`
+	HasThumbnail   bool      `json:"has_thumbnail" db:"has_thumbnail"`
+	Description    string    `json:"description" db:"description"`
+	UploadedBy     int       `json:"uploaded_by" db:"uploaded_by"`
+	UploaderName   string    `json:"uploader_name,omitempty"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
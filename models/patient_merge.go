@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// 疑似重复程度
+const (
+	DuplicateHigh   = "high"   // 高度疑似，身份证号或姓名加电话一致
+	DuplicateMedium = "medium" // 疑似，需人工核对
+)
+
+// DuplicateCandidate 疑似重复的患者档案及评分依据
+type DuplicateCandidate struct {
+	Patient Patient  `json:"patient"`
+	Score   int      `json:"score"`
+	Level   string   `json:"level"` // high, medium
+	Reasons []string `json:"reasons"`
+}
+
+// DuplicatePair 全院查重结果中的一对疑似重复档案
+type DuplicatePair struct {
+	PatientA Patient  `json:"patient_a"`
+	PatientB Patient  `json:"patient_b"`
+	Score    int      `json:"score"`
+	Level    string   `json:"level"`
+	Reasons  []string `json:"reasons"`
+}
+
+// PatientMerge 患者档案合并记录，保存被合并档案的快照及各表转移的记录数
+type PatientMerge struct {
+	ID                 int            `json:"id" db:"id"`
+	SurvivingPatientID int            `json:"surviving_patient_id" db:"surviving_patient_id"` // 保留的档案
+	MergedPatientID    int            `json:"merged_patient_id" db:"merged_patient_id"`       // 被合并删除的档案
+	MergedPatient      Patient        `json:"merged_patient" db:"merged_patient"`             // 合并前的档案快照
+	Reassigned         map[string]int `json:"reassigned" db:"reassigned"`                     // 按表统计转移的记录数
+	Score              int            `json:"score" db:"score"`                               // 合并时的查重评分
+	Reason             string         `json:"reason" db:"reason"`
+	MergedBy           int            `json:"merged_by" db:"merged_by"`
+	MergedByName       string         `json:"merged_by_name,omitempty"`
+	MergedAt           time.Time      `json:"merged_at" db:"merged_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// 诊疗时间线事件类型
+const (
+	TimelineAppointment  = "appointment"  // 预约，时间为预约时间
+	TimelineEncounter    = "encounter"    // 门诊病历，时间为就诊时间
+	TimelinePrescription = "prescription" // 处方，时间为开具时间
+	TimelineVitalSign    = "vital_sign"   // 生命体征，时间为测量时间
+	TimelineAllergy      = "allergy"      // 过敏史变更，时间为登记或删除时间
+	TimelineAttachment   = "attachment"   // 上传的检验报告等附件，时间为上传时间
+)
+
+// TimelineEvent 患者诊疗时间线中的一条记录，Data 为对应类型的完整记录
+type TimelineEvent struct {
+	Type   string      `json:"type"`
+	Action string      `json:"action,omitempty"` // 过敏史变更：added, removed
+	ID     int         `json:"id"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+}
@@ -4,17 +4,115 @@ import (
 	"time"
 )
 
+// 处方状态
+const (
+	PrescriptionDraft     = "draft"     // 草稿
+	PrescriptionCompleted = "completed" // 已完成
+	PrescriptionApproved  = "approved"  // 药师审核通过
+	PrescriptionRejected  = "rejected"  // 药师审核驳回
+	PrescriptionDispensed = "dispensed" // 已发药
+	PrescriptionPrinted   = "printed"   // 已打印
+	PrescriptionVoided    = "voided"    // 已作废
+)
+
+// 处方类型
+const (
+	PrescriptionWestern = "western" // 西药、中成药
+	PrescriptionHerbal  = "herbal"  // 中药饮片
+)
+
+// PrescriptionStatusNames 处方状态中文名称
+var PrescriptionStatusNames = map[string]string{
+	PrescriptionDraft:     "草稿",
+	PrescriptionCompleted: "已完成",
+	PrescriptionApproved:  "审核通过",
+	PrescriptionRejected:  "审核驳回",
+	PrescriptionDispensed: "已发药",
+	PrescriptionPrinted:   "已打印",
+	PrescriptionVoided:    "已作废",
+}
+
+// prescriptionTransitions 处方状态允许的流转；已完成（含已打印）的处方须经药师审核通过后才能发药，
+// 被驳回的处方由医生修订或作废
+var prescriptionTransitions = map[string][]string{
+	PrescriptionDraft:     {PrescriptionCompleted, PrescriptionVoided},
+	PrescriptionCompleted: {PrescriptionApproved, PrescriptionRejected, PrescriptionPrinted, PrescriptionVoided},
+	PrescriptionPrinted:   {PrescriptionApproved, PrescriptionRejected, PrescriptionVoided},
+	PrescriptionApproved:  {PrescriptionDispensed, PrescriptionVoided},
+	PrescriptionRejected:  {PrescriptionVoided},
+	PrescriptionDispensed: {PrescriptionPrinted, PrescriptionVoided},
+}
+
+// PharmacyStatuses 只能由药师通过审核、发药操作变更的状态
+var PharmacyStatuses = map[string]bool{
+	PrescriptionApproved:  true,
+	PrescriptionRejected:  true,
+	PrescriptionDispensed: true,
+}
+
+// CanTransitionPrescription 判断处方状态能否从 from 变更为 to
+func CanTransitionPrescription(from, to string) bool {
+	for _, next := range prescriptionTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 type Prescription struct {
-	ID           int       `json:"id" db:"id"`
-	PatientID    int       `json:"patient_id" db:"patient_id"`
-	DoctorID     int       `json:"doctor_id" db:"doctor_id"`
-	Diagnosis    string    `json:"diagnosis" db:"diagnosis"`
-	DoctorAdvice string    `json:"doctor_advice" db:"doctor_advice"`
-	TotalAmount  float64   `json:"total_amount" db:"total_amount"`
-	Status       string    `json:"status" db:"status"` // draft, completed, printed
-	Notes        string    `json:"notes" db:"notes"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID            int       `json:"id" db:"id"`
+	PatientID     int       `json:"patient_id" db:"patient_id"`
+	DoctorID      int       `json:"doctor_id" db:"doctor_id"`
+	Diagnosis     string    `json:"diagnosis" db:"diagnosis"`
+	DoctorAdvice  string    `json:"doctor_advice" db:"doctor_advice"`
+	TotalAmount   float64   `json:"total_amount" db:"total_amount"`
+	Status        string    `json:"status" db:"status"` // draft, completed, approved, rejected, dispensed, printed, voided
+	Notes         string    `json:"notes" db:"notes"`
+	StockDeducted bool      `json:"stock_deducted" db:"stock_deducted"`   // 是否已扣减库存
+	AmendedFromID int       `json:"amended_from_id" db:"amended_from_id"` // 被修订的原处方ID
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+
+	// 过敏冲突时医生坚持用药的原因及操作人
+	AllergyOverrideReason string `json:"allergy_override_reason" db:"allergy_override_reason"`
+	AllergyOverrideBy     int    `json:"allergy_override_by" db:"allergy_override_by"`
+
+	// 开方时记录的患者体重（kg），用于按体重核对剂量
+	PatientWeight float64 `json:"patient_weight" db:"patient_weight"`
+
+	// 处方类型；中药饮片处方按剂开具，记录剂数和煎服法
+	PrescriptionType string `json:"prescription_type" db:"prescription_type"` // western, herbal
+	HerbalDoses      int    `json:"herbal_doses" db:"herbal_doses"`           // 剂数
+	DecoctionMethod  string `json:"decoction_method" db:"decoction_method"`   // 煎服法
+
+	// 处方编号，格式 RX20261017-0007，完成时按日分配
+	PrescriptionNo string `json:"prescription_no" db:"prescription_no"`
+
+	// 所属门诊病历，0 表示未关联就诊记录
+	EncounterID int `json:"encounter_id" db:"encounter_id"`
+
+	// ICD-10 编码诊断，主要诊断在前；Diagnosis 作为自由文本补充说明
+	Diagnoses []CodedDiagnosis `json:"diagnoses"`
+
+	// 处方笺类别，按所含药品的管理类别确定
+	PrescriptionForm string `json:"prescription_form" db:"prescription_form"` // normal, narcotic, psychotropic_2
+
+	// 作废信息；已收费的处方作废时记录应退金额
+	VoidReason   string     `json:"void_reason" db:"void_reason"`
+	VoidedBy     int        `json:"voided_by" db:"voided_by"`
+	VoidedAt     *time.Time `json:"voided_at,omitempty" db:"voided_at"`
+	RefundAmount float64    `json:"refund_amount" db:"refund_amount"`
+
+	// 药师审核及发药记录；驳回时 ReviewNote 为退回医生的原因
+	CompletedAt   *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	ReviewedBy    int        `json:"reviewed_by" db:"reviewed_by"`
+	ReviewerName  string     `json:"reviewer_name,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty" db:"reviewed_at"`
+	ReviewNote    string     `json:"review_note" db:"review_note"`
+	DispensedBy   int        `json:"dispensed_by" db:"dispensed_by"`
+	DispenserName string     `json:"dispenser_name,omitempty"`
+	DispensedAt   *time.Time `json:"dispensed_at,omitempty" db:"dispensed_at"`
 
 	// 关联数据
 	Patient *Patient           `json:"patient,omitempty"`
@@ -36,14 +134,58 @@ type PrescriptionItem struct {
 	UnitPrice      float64 `json:"unit_price" db:"unit_price"`
 	TotalPrice     float64 `json:"total_price" db:"total_price"`
 
+	// 中药饮片：每剂克数及特殊处理（先煎、后下等），数量 = 克数 × 剂数
+	HerbGrams         float64 `json:"herb_grams" db:"herb_grams"`
+	SpecialProcessing string  `json:"special_processing" db:"special_processing"`
+
 	// 关联数据
 	Medicine *Medicine `json:"medicine,omitempty"`
 }
 
+// PrescriptionVersion 处方修订链中的一个版本
+type PrescriptionVersion struct {
+	Version        int        `json:"version"` // 版本号，原始处方为1
+	ID             int        `json:"id"`
+	PrescriptionNo string     `json:"prescription_no"`
+	AmendedFromID  int        `json:"amended_from_id"`
+	Status         string     `json:"status"`
+	Diagnosis      string     `json:"diagnosis"`
+	TotalAmount    float64    `json:"total_amount"`
+	DoctorName     string     `json:"doctor_name"`
+	VoidReason     string     `json:"void_reason"`
+	VoidedAt       *time.Time `json:"voided_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Current        bool       `json:"current"` // 是否为本次查询的处方
+}
+
+// PrescriptionWarning 开具处方时需要医生注意的提示
+type PrescriptionWarning struct {
+	Type                string `json:"type"` // medicine_deleted, insufficient_stock, allergy, interaction, duplicate_category, dosage, drug_class
+	Severity            string `json:"severity,omitempty"`
+	MedicineID          int    `json:"medicine_id"`
+	MedicineName        string `json:"medicine_name"`
+	RelatedMedicineID   int    `json:"related_medicine_id,omitempty"` // 相互作用或重复用药的另一药品
+	RelatedMedicineName string `json:"related_medicine_name,omitempty"`
+	Message             string `json:"message"`
+}
+
 type PrescriptionSearch struct {
-	PatientName string    `json:"patient_name"`
-	DoctorName  string    `json:"doctor_name"`
-	StartDate   time.Time `json:"start_date"`
-	EndDate     time.Time `json:"end_date"`
-	Status      string    `json:"status"`
+	PrescriptionNo string    `json:"prescription_no"`
+	PatientID      int       `json:"patient_id"`
+	PatientName    string    `json:"patient_name"`
+	DoctorName     string    `json:"doctor_name"`
+	StartDate      time.Time `json:"start_date"`
+	EndDate        time.Time `json:"end_date"`
+	Status         string    `json:"status"`
+}
+
+// DoseSuggestion 按药品剂量规则及患者体重计算的建议单次剂量
+type DoseSuggestion struct {
+	MedicineID    int     `json:"medicine_id"`
+	MedicineName  string  `json:"medicine_name"`
+	SuggestedDose float64 `json:"suggested_dose"` // 建议单次剂量，0 表示无法计算
+	DoseUnit      string  `json:"dose_unit"`
+	MaxSingleDose float64 `json:"max_single_dose"`
+	MaxDailyDose  float64 `json:"max_daily_dose"`
+	Basis         string  `json:"basis"` // 计算依据
 }
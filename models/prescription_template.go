@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// 协定处方适用范围
+const (
+	TemplateScopePersonal = "personal" // 个人
+	TemplateScopeClinic   = "clinic"   // 全院
+)
+
+type PrescriptionTemplate struct {
+	ID           int       `json:"id" db:"id"`
+	Name         string    `json:"name" db:"name"`
+	Pinyin       string    `json:"pinyin" db:"pinyin"`
+	Initials     string    `json:"initials" db:"initials"`
+	Scope        string    `json:"scope" db:"scope"` // personal, clinic
+	OwnerID      int       `json:"owner_id" db:"owner_id"`
+	Diagnosis    string    `json:"diagnosis" db:"diagnosis"`
+	DoctorAdvice string    `json:"doctor_advice" db:"doctor_advice"`
+	Notes        string    `json:"notes" db:"notes"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+
+	// 中药饮片模板的处方类型、剂数和煎服法，含义同处方
+	PrescriptionType string `json:"prescription_type" db:"prescription_type"` // western, herbal
+	HerbalDoses      int    `json:"herbal_doses" db:"herbal_doses"`
+	DecoctionMethod  string `json:"decoction_method" db:"decoction_method"`
+
+	// 关联数据
+	Owner *User                      `json:"owner,omitempty"`
+	Items []PrescriptionTemplateItem `json:"items,omitempty"`
+}
+
+type PrescriptionTemplateItem struct {
+	ID            int    `json:"id" db:"id"`
+	TemplateID    int    `json:"template_id" db:"template_id"`
+	MedicineID    int    `json:"medicine_id" db:"medicine_id"`
+	MedicineName  string `json:"medicine_name" db:"medicine_name"`
+	Specification string `json:"specification" db:"specification"`
+	Dosage        string `json:"dosage" db:"dosage"`
+	Usage         string `json:"usage" db:"usage"`
+	Frequency     string `json:"frequency" db:"frequency"`
+	Days          int    `json:"days" db:"days"`
+	Quantity      int    `json:"quantity" db:"quantity"`
+
+	// 中药饮片每剂克数和特殊煎法
+	HerbGrams         float64 `json:"herb_grams" db:"herb_grams"`
+	SpecialProcessing string  `json:"special_processing" db:"special_processing"`
+}
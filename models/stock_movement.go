@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// 库存变动类型
+const (
+	MovementPurchase   = "purchase"   // 采购入库
+	MovementDispense   = "dispense"   // 处方发药
+	MovementAdjustment = "adjustment" // 手工调整
+	MovementStocktake  = "stocktake"  // 盘点修正
+	MovementExpired    = "expired"    // 过期报损
+	MovementReturn     = "return"     // 退药/退货
+)
+
+type StockMovement struct {
+	ID           int       `json:"id" db:"id"`
+	MedicineID   int       `json:"medicine_id" db:"medicine_id"`
+	BatchID      int       `json:"batch_id" db:"batch_id"`
+	MovementType string    `json:"movement_type" db:"movement_type"`
+	Quantity     int       `json:"quantity" db:"quantity"` // 变动数量，入库为正、出库为负
+	Balance      int       `json:"balance" db:"balance"`   // 变动后库存
+	UserID       int       `json:"user_id" db:"user_id"`
+	Reason       string    `json:"reason" db:"reason"`
+	ReferenceID  int       `json:"reference_id" db:"reference_id"` // 关联单据ID，如处方ID
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+
+	// 关联数据
+	User  *User          `json:"user,omitempty"`
+	Batch *MedicineBatch `json:"batch,omitempty"`
+}
+
+// StockSummary 药品在统计期间内的进销存汇总
+type StockSummary struct {
+	MedicineID    int    `json:"medicine_id"`
+	MedicineName  string `json:"medicine_name"`
+	Specification string `json:"specification"`
+	Unit          string `json:"unit"`
+	Opening       int    `json:"opening"` // 期初库存
+	In            int    `json:"in"`      // 本期入库
+	Out           int    `json:"out"`     // 本期出库
+	Closing       int    `json:"closing"` // 期末库存
+}
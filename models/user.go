@@ -4,14 +4,40 @@ import (
 	"time"
 )
 
+// 用户角色
+const (
+	RoleAdmin        = "admin"        // 管理员
+	RoleDoctor       = "doctor"       // 医生
+	RolePharmacist   = "pharmacist"   // 药师
+	RoleNurse        = "nurse"        // 护士，可录入生命体征，无处方权
+	RoleReceptionist = "receptionist" // 前台，登记患者和预约
+)
+
+// StaffRoleNames 管理员可创建的员工角色
+var StaffRoleNames = map[string]string{
+	RoleDoctor:       "医生",
+	RolePharmacist:   "药师",
+	RoleNurse:        "护士",
+	RoleReceptionist: "前台",
+}
+
+// RoleViewsFullPII 角色是否可直接查看患者完整的身份证号和电话；护士、前台看到脱敏后的号码，需填写原因查看完整号码
+func RoleViewsFullPII(role string) bool {
+	return role == RoleAdmin || role == RoleDoctor || role == RolePharmacist
+}
+
 type User struct {
 	ID        int       `json:"id" db:"id"`
 	Username  string    `json:"username" db:"username"`
 	Password  string    `json:"-" db:"password"`
 	Name      string    `json:"name" db:"name"`
-	Role      string    `json:"role" db:"role"` // admin, doctor, nurse
+	Role      string    `json:"role" db:"role"` // admin, doctor, pharmacist, nurse, receptionist
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+
+	// 处方权：可开具的抗菌药物最高级别，及是否具有麻醉药品和精神药品处方权
+	AntibioticLevel         string `json:"antibiotic_level" db:"antibiotic_level"`
+	ControlledDrugQualified bool   `json:"controlled_drug_qualified" db:"controlled_drug_qualified"`
 }
 
 type LoginRequest struct {
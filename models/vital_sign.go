@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+)
+
+// VitalSign 生命体征测量记录，未测量的项目为 0
+type VitalSign struct {
+	ID          int       `json:"id" db:"id"`
+	PatientID   int       `json:"patient_id" db:"patient_id"`
+	EncounterID int       `json:"encounter_id" db:"encounter_id"` // 所属门诊病历，0 表示未关联
+	Temperature float64   `json:"temperature" db:"temperature"`   // 体温（℃）
+	SystolicBP  int       `json:"systolic_bp" db:"systolic_bp"`   // 收缩压（mmHg）
+	DiastolicBP int       `json:"diastolic_bp" db:"diastolic_bp"` // 舒张压（mmHg）
+	Pulse       int       `json:"pulse" db:"pulse"`               // 脉搏（次/分）
+	Respiration int       `json:"respiration" db:"respiration"`   // 呼吸（次/分）
+	SpO2        int       `json:"spo2" db:"spo2"`                 // 血氧饱和度（%）
+	Weight      float64   `json:"weight" db:"weight"`             // 体重（kg）
+	Height      float64   `json:"height" db:"height"`             // 身高（cm）
+	Notes       string    `json:"notes" db:"notes"`
+	MeasuredAt  time.Time `json:"measured_at" db:"measured_at"`
+	RecordedBy  int       `json:"recorded_by" db:"recorded_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+
+	// 计算数据
+	RecorderName string          `json:"recorder_name,omitempty"`
+	BMI          float64         `json:"bmi,omitempty"`
+	Flags        []VitalSignFlag `json:"flags"`
+}
+
+// VitalSignFlag 生命体征异常提示
+type VitalSignFlag struct {
+	Field   string `json:"field"` // temperature, blood_pressure, pulse, respiration, spo2, bmi
+	Level   string `json:"level"` // high, low
+	Message string `json:"message"`
+}
+
+// VitalRange 生命体征的有效录入范围及成人正常参考范围
+type VitalRange struct {
+	Name      string  // 中文名称
+	Unit      string  // 单位
+	ValidMin  float64 // 超出有效范围视为录入错误
+	ValidMax  float64
+	NormalMin float64 // 低于正常下限标记为偏低
+	NormalMax float64 // 高于正常上限标记为偏高
+}
+
+// VitalRanges 各项生命体征的范围，血压分别按收缩压和舒张压判断
+var VitalRanges = map[string]VitalRange{
+	"temperature":  {Name: "体温", Unit: "℃", ValidMin: 30, ValidMax: 45, NormalMin: 36.0, NormalMax: 37.2},
+	"systolic_bp":  {Name: "收缩压", Unit: "mmHg", ValidMin: 40, ValidMax: 300, NormalMin: 90, NormalMax: 139},
+	"diastolic_bp": {Name: "舒张压", Unit: "mmHg", ValidMin: 20, ValidMax: 200, NormalMin: 60, NormalMax: 89},
+	"pulse":        {Name: "脉搏", Unit: "次/分", ValidMin: 20, ValidMax: 250, NormalMin: 60, NormalMax: 100},
+	"respiration":  {Name: "呼吸", Unit: "次/分", ValidMin: 4, ValidMax: 80, NormalMin: 12, NormalMax: 20},
+	"spo2":         {Name: "血氧饱和度", Unit: "%", ValidMin: 50, ValidMax: 100, NormalMin: 95, NormalMax: 100},
+	"weight":       {Name: "体重", Unit: "kg", ValidMin: 0.5, ValidMax: 300},
+	"height":       {Name: "身高", Unit: "cm", ValidMin: 30, ValidMax: 250},
+	"bmi":          {Name: "BMI", Unit: "kg/m²", NormalMin: 18.5, NormalMax: 23.9},
+}
@@ -51,6 +51,9 @@ function showMainApp() {
     document.getElementById('mainApp').classList.remove('hidden');
     document.getElementById('userInfo').textContent = `${currentUser.name} (${currentUser.role})`;
     showDoctorMenuIfAdmin();
+    if (currentUser.role === 'pharmacist' || currentUser.role === 'admin') {
+        document.getElementById('pharmacyMenu').style.display = '';
+    }
 }
 
 // 绑定事件
@@ -80,6 +83,25 @@ function bindEvents() {
     document.getElementById('medicineCategory').addEventListener('change', loadMedicines);
     document.getElementById('prescriptionStatus').addEventListener('change', loadPrescriptions);
     document.getElementById('appointmentDate').addEventListener('change', loadAppointments);
+
+    // 录入身份证号后自动填写性别和出生日期，号码校验以后端为准
+    document.getElementById('patientIdCard').addEventListener('change', fillPatientFromIdCard);
+}
+
+// 按18位身份证号填写未录入的性别和出生日期，已录入的与身份证号不符时由后端提示
+function fillPatientFromIdCard() {
+    const idCard = document.getElementById('patientIdCard').value.trim().toUpperCase();
+    if (!/^\d{17}[\dX]$/.test(idCard)) {
+        return;
+    }
+    const birthDate = document.getElementById('patientBirthDate');
+    if (!birthDate.value) {
+        birthDate.value = `${idCard.substr(6, 4)}-${idCard.substr(10, 2)}-${idCard.substr(12, 2)}`;
+    }
+    const gender = document.getElementById('patientGender');
+    if (!gender.value) {
+        gender.value = parseInt(idCard[16]) % 2 === 1 ? '男' : '女';
+    }
 }
 
 // 处理登录
@@ -150,6 +172,7 @@ function loadPage(page) {
         prescriptions: '处方管理',
         appointments: '预约管理',
         reports: '统计报表',
+        pharmacy: '药房审核',
         doctors: '医生管理'
     };
     document.getElementById('pageTitle').textContent = titles[page] || '页面';
@@ -171,6 +194,9 @@ function loadPage(page) {
         case 'reports':
             loadReports();
             break;
+        case 'pharmacy':
+            loadPharmacyQueues();
+            break;
         case 'doctors':
             loadDoctors();
             break;
@@ -186,8 +212,17 @@ async function loadPatients(page = 1) {
         const response = await fetch(url);
         if (response.ok) {
             const data = await response.json();
-            displayPatients(data.patients);
+            displayPatients(data.patients || []);
             displayPatientPagination(data.total, data.page, data.limit);
+            // 按号码搜索没有结果时说明只支持完整号码或后4位
+            if ((!data.patients || data.patients.length === 0) && data.search_note) {
+                document.getElementById('patientsTable').innerHTML =
+                    `<tr><td colspan="6" class="text-center text-muted">未找到患者。${data.search_note}</td></tr>`;
+            }
+        } else {
+            const data = await response.json();
+            document.getElementById('patientsTable').innerHTML =
+                `<tr><td colspan="6" class="text-center text-muted">${data.error || '加载患者列表失败'}</td></tr>`;
         }
     } catch (error) {
         console.error('加载患者列表失败:', error);
@@ -202,7 +237,7 @@ function displayPatients(patients) {
             <td>${patient.id}</td>
             <td>${patient.name}</td>
             <td>${patient.gender}</td>
-            <td>${patient.age}</td>
+            <td>${patient.age_text || '-'}</td>
             <td>${patient.phone || '-'}</td>
             <td>
                 <button class="btn btn-sm btn-outline-primary" onclick="editPatient(${patient.id})">
@@ -453,6 +488,7 @@ function displayPrescriptions(prescriptions) {
     const html = prescriptions.map(prescription => `
         <tr style="cursor: pointer;" onclick="viewPrescription(${prescription.id})" title="点击查看详情">
             <td>${prescription.id}</td>
+            <td>${prescription.prescription_no || '-'}</td>
             <td>${prescription.patient.name}</td>
             <td>${prescription.doctor.name}</td>
             <td>${prescription.diagnosis || '-'}</td>
@@ -471,7 +507,7 @@ function displayPrescriptions(prescriptions) {
                 <button class="btn btn-sm btn-outline-success" onclick="event.stopPropagation(); printPrescription(${prescription.id})">
                     <i class="bi bi-printer"></i>
                 </button>
-                <button class="btn btn-sm btn-outline-danger" onclick="event.stopPropagation(); deletePrescription(${prescription.id})">
+                <button class="btn btn-sm btn-outline-danger" onclick="event.stopPropagation(); deletePrescription(${prescription.id}, '${prescription.status}')">
                     <i class="bi bi-trash"></i>
                 </button>
             </td>
@@ -879,7 +915,11 @@ function getStatusBadgeColor(status) {
     const colors = {
         draft: 'secondary',
         completed: 'success',
-        printed: 'info'
+        approved: 'success',
+        rejected: 'warning',
+        dispensed: 'primary',
+        printed: 'info',
+        voided: 'danger'
     };
     return colors[status] || 'secondary';
 }
@@ -888,7 +928,11 @@ function getStatusText(status) {
     const texts = {
         draft: '草稿',
         completed: '已完成',
-        printed: '已打印'
+        approved: '审核通过',
+        rejected: '审核驳回',
+        dispensed: '已发药',
+        printed: '已打印',
+        voided: '已作废'
     };
     return texts[status] || status;
 }
@@ -939,6 +983,7 @@ function showPatientModal(patientId = null) {
         title.textContent = '添加患者';
         form.reset();
         document.getElementById('patientId').value = '';
+        document.getElementById('revealPatientBtn').classList.add('d-none');
     }
     
     modal.show();
@@ -975,6 +1020,7 @@ function showPrescriptionModal(prescriptionId = null) {
         title.textContent = '开处方';
         form.reset();
         document.getElementById('prescriptionId').value = '';
+        updatePrescriptionTypeUI();
         // 重置处方明细
         document.getElementById('prescriptionItems').innerHTML = `
             <div class="row prescription-item">
@@ -987,7 +1033,10 @@ function showPrescriptionModal(prescriptionId = null) {
                 <div class="col-md-2 mb-2">
                     <input type="text" class="form-control" placeholder="规格" name="specification">
                 </div>
-                <div class="col-md-2 mb-2">
+                <div class="col-md-1 mb-2">
+                    <input type="text" class="form-control" placeholder="单次剂量" name="dosage">
+                </div>
+                <div class="col-md-1 mb-2">
                     <input type="text" class="form-control" placeholder="用法" name="usage">
                 </div>
                 <div class="col-md-1 mb-2">
@@ -1059,7 +1108,9 @@ async function savePatient() {
     const formData = {
         name: document.getElementById('patientName').value,
         gender: document.getElementById('patientGender').value,
-        age: parseInt(document.getElementById('patientAge').value),
+        // 填写了出生日期时年龄由后端计算，否则按年龄推算出生日期
+        birth_date: document.getElementById('patientBirthDate').value,
+        age: parseInt(document.getElementById('patientAge').value) || 0,
         phone: document.getElementById('patientPhone').value,
         id_card: document.getElementById('patientIdCard').value,
         address: document.getElementById('patientAddress').value,
@@ -1100,7 +1151,18 @@ async function saveMedicine() {
         stock: parseInt(document.getElementById('medicineStock').value),
         category: document.getElementById('medicineCategory').value,
         manufacturer: document.getElementById('medicineManufacturer').value,
-        min_stock: parseInt(document.getElementById('medicineMinStock').value)
+        ingredients: document.getElementById('medicineIngredients').value,
+        drug_class: document.getElementById('medicineDrugClass').value,
+        antibiotic_level: document.getElementById('medicineAntibioticLevel').value,
+        min_stock: parseInt(document.getElementById('medicineMinStock').value),
+        dosing_rule: {
+            dose_unit: document.getElementById('medicineDoseUnit').value,
+            max_single_dose: parseFloat(document.getElementById('medicineMaxSingleDose').value) || 0,
+            max_daily_dose: parseFloat(document.getElementById('medicineMaxDailyDose').value) || 0,
+            dose_per_kg: parseFloat(document.getElementById('medicineDosePerKg').value) || 0,
+            min_age_months: (parseInt(document.getElementById('medicineMinAge').value) || 0) *
+                parseInt(document.getElementById('medicineMinAgeUnit').value)
+        }
     };
     
     const medicineId = document.getElementById('medicineId').value;
@@ -1128,21 +1190,27 @@ async function saveMedicine() {
     }
 }
 
-async function savePrescription() {
+async function savePrescription(allergyOverrideReason = '') {
     // 收集处方明细
     const items = [];
+    const herbal = document.getElementById('prescriptionType').value === 'herbal';
     document.querySelectorAll('.prescription-item').forEach(item => {
         const medicineName = item.querySelector('[name="medicineName"]').value;
         if (medicineName) {
             items.push({
+                medicine_id: parseInt(item.querySelector('[name="medicineName"]').dataset.medicineId) || 0,
                 medicine_name: medicineName,
                 specification: item.querySelector('[name="specification"]').value,
+                dosage: item.querySelector('[name="dosage"]').value,
                 usage: item.querySelector('[name="usage"]').value,
                 frequency: item.querySelector('[name="frequency"]').value,
                 days: parseInt(item.querySelector('[name="days"]').value),
                 quantity: parseInt(item.querySelector('[name="quantity"]').value),
                 unit_price: parseFloat(item.querySelector('[name="unitPrice"]').value) || 0,
-                total_price: parseFloat(item.querySelector('[name="totalPrice"]').value) || 0
+                total_price: parseFloat(item.querySelector('[name="totalPrice"]').value) || 0,
+                // 中药饮片：单次剂量一栏填写每剂克数，用法一栏填写先煎、后下等脚注
+                herb_grams: herbal ? parseFloat(item.querySelector('[name="dosage"]').value) || 0 : 0,
+                special_processing: herbal ? item.querySelector('[name="usage"]').value : ''
             });
         }
     });
@@ -1152,6 +1220,11 @@ async function savePrescription() {
         diagnosis: document.getElementById('prescriptionDiagnosis').value,
         doctor_advice: document.getElementById('prescriptionDoctorAdvice').value,
         notes: document.getElementById('prescriptionNotes').value,
+        patient_weight: parseFloat(document.getElementById('prescriptionPatientWeight').value) || 0,
+        prescription_type: herbal ? 'herbal' : 'western',
+        herbal_doses: herbal ? parseInt(document.getElementById('prescriptionHerbalDoses').value) || 0 : 0,
+        decoction_method: herbal ? document.getElementById('prescriptionDecoctionMethod').value : '',
+        allergy_override_reason: allergyOverrideReason,
         items: items
     };
     
@@ -1167,11 +1240,30 @@ async function savePrescription() {
         });
         
         if (response.ok) {
+            const result = await response.json();
             bootstrap.Modal.getInstance(document.getElementById('prescriptionModal')).hide();
             loadPrescriptions();
-            alert(prescriptionId ? '处方更新成功' : '处方创建成功');
+            let message = prescriptionId ? '处方更新成功' : '处方创建成功';
+            if (result.warnings && result.warnings.length > 0) {
+                message += '\n\n' + result.warnings.map(w => w.message).join('\n');
+            }
+            alert(message);
         } else {
             const error = await response.json();
+            const warnings = error.warnings || [];
+            if (warnings.some(w => w.type === 'allergy') && !warnings.some(w => w.type === 'drug_class')) {
+                // 过敏冲突：医生确认后填写坚持用药原因重新提交
+                const reason = prompt(error.error + '\n\n' + error.warnings.map(w => w.message).join('\n') + '\n\n请输入坚持用药原因：');
+                if (reason && reason.trim()) {
+                    savePrescription(reason.trim());
+                }
+                return;
+            }
+            // 违反管理类别开方规则等不能坚持用药的问题，需修改处方
+            if (warnings.length > 0) {
+                alert(error.error + '\n\n' + error.warnings.map(w => w.message).join('\n'));
+                return;
+            }
             alert(error.error || '操作失败');
         }
     } catch (error) {
@@ -1229,7 +1321,10 @@ function addPrescriptionItem() {
         <div class="col-md-2 mb-2">
             <input type="text" class="form-control" placeholder="规格" name="specification">
         </div>
-        <div class="col-md-2 mb-2">
+        <div class="col-md-1 mb-2">
+            <input type="text" class="form-control" placeholder="单次剂量" name="dosage">
+        </div>
+        <div class="col-md-1 mb-2">
             <input type="text" class="form-control" placeholder="用法" name="usage">
         </div>
         <div class="col-md-1 mb-2">
@@ -1261,6 +1356,29 @@ function addPrescriptionItem() {
     
     // 绑定价格自动计算事件
     setupPriceCalculation(newItem);
+    updatePrescriptionTypeUI();
+}
+
+// 切换处方类型：中药饮片处方的单次剂量一栏填写每剂克数，用法一栏填写特殊煎法，数量按克数×剂数由系统计算
+function updatePrescriptionTypeUI() {
+    const herbal = document.getElementById('prescriptionType').value === 'herbal';
+    document.querySelectorAll('#prescriptionForm .herbal-option').forEach(el => el.classList.toggle('d-none', !herbal));
+    document.getElementById('prescriptionDosageHeader').textContent = herbal ? '每剂克数' : '单次剂量';
+    document.getElementById('prescriptionUsageHeader').textContent = herbal ? '特殊煎法' : '用法';
+    document.querySelectorAll('.prescription-item').forEach(row => {
+        const dosageInput = row.querySelector('[name="dosage"]');
+        const usageInput = row.querySelector('[name="usage"]');
+        dosageInput.placeholder = herbal ? '克/剂' : '单次剂量';
+        usageInput.placeholder = herbal ? '先煎/后下' : '用法';
+        if (herbal) {
+            usageInput.setAttribute('list', 'specialProcessingOptions');
+        } else {
+            usageInput.removeAttribute('list');
+        }
+        ['frequency', 'days', 'quantity'].forEach(name => {
+            row.querySelector(`[name="${name}"]`).disabled = herbal;
+        });
+    });
 }
 
 function removePrescriptionItem(button) {
@@ -1507,7 +1625,7 @@ function renderPrescriptionDetailHtml(prescription) {
             <div class="col-md-6">
                 <strong>患者：</strong> ${patient.name || '-'}
                 <span class="ms-3">性别：${patient.gender || '-'}</span>
-                <span class="ms-3">年龄：${patient.age ? patient.age + '岁' : '-'}</span>
+                <span class="ms-3">年龄：${patient.age_text || '-'}</span>
             </div>
             <div class="col-md-6">
                 <strong>电话：</strong> ${patient.phone || '-'}
@@ -1515,7 +1633,7 @@ function renderPrescriptionDetailHtml(prescription) {
             </div>
         </div>
         <div class="mb-3"><strong>地址：</strong> ${patient.address || '-'}</div>
-        <div class="mb-3"><strong>诊断：</strong> ${prescription.diagnosis || '-'}</div>
+        <div class="mb-3"><strong>诊断：</strong> ${[...(prescription.diagnoses || []).map(d => `${d.code} ${d.name}`), prescription.diagnosis].filter(Boolean).join('；') || '-'}</div>
         <div class="mb-3"><strong>医嘱：</strong> ${prescription.doctor_advice || '-'}</div>
         <div class="mb-3"><strong>备注：</strong> ${prescription.notes || '-'}</div>
         <div class="mb-3">
@@ -1592,12 +1710,28 @@ async function printPrescription(id) {
     }
 }
 
-async function deletePrescription(id) {
-    if (!confirm('确定要删除这个处方吗？')) return;
+async function deletePrescription(id, status = 'draft') {
+    // 已开具的处方不能删除，只能填写原因作废
+    let reason = '';
+    if (status !== 'draft') {
+        if (status === 'voided') {
+            alert('该处方已作废');
+            return;
+        }
+        reason = prompt('已开具的处方不能删除，将作废处方并归还库存。\n请输入作废原因：');
+        if (!reason || !reason.trim()) return;
+    } else if (!confirm('确定要删除这个处方吗？')) {
+        return;
+    }
     
     try {
-        const response = await fetch(`${API_BASE}/prescriptions/${id}`, { method: 'DELETE' });
+        const response = await fetch(`${API_BASE}/prescriptions/${id}`, {
+            method: 'DELETE',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ reason: reason.trim() })
+        });
         if (response.ok) {
+            const result = await response.json();
             // 获取当前页码
             const currentPage = getCurrentPrescriptionPage();
             
@@ -1610,7 +1744,7 @@ async function deletePrescription(id) {
                 await loadPrescriptions(currentPage - 1);
             }
             
-            alert('处方删除成功');
+            alert(result.voided ? '处方已作废' : '处方删除成功');
         } else {
             const error = await response.json();
             alert(error.error || '删除失败');
@@ -1702,7 +1836,7 @@ async function findOrCreatePatient() {
             
             const option = document.createElement('option');
             option.value = patient.id;
-            option.textContent = `${patient.name} (${patient.gender || '未知'}, ${patient.age || '未知'}岁)`;
+            option.textContent = `${patient.name} (${patient.gender || '未知'}, ${patient.age_text || '年龄未知'})`;
             select.appendChild(option);
             select.value = patient.id;
             
@@ -1751,6 +1885,36 @@ function showToast(message, type = 'info') {
     }, 3000);
 }
 
+// 按患者年龄、体重获取药品建议剂量，显示在单次剂量输入框的提示中
+async function loadSuggestedDose(medicineId, row) {
+    const dosageInput = row.querySelector('[name="dosage"]');
+    if (!dosageInput) return;
+    
+    const params = new URLSearchParams();
+    const patientId = document.getElementById('prescriptionPatient').value;
+    const weight = document.getElementById('prescriptionPatientWeight').value;
+    if (patientId) params.append('patient_id', patientId);
+    if (weight) params.append('weight', weight);
+    
+    try {
+        const response = await fetch(`${API_BASE}/medicines/${medicineId}/suggested-dose?${params}`);
+        if (!response.ok) return;
+        const data = await response.json();
+        const suggestion = data.suggestion;
+        dosageInput.placeholder = '单次剂量';
+        dosageInput.title = '';
+        if (suggestion && suggestion.suggested_dose > 0) {
+            dosageInput.placeholder = `建议${suggestion.suggested_dose}${suggestion.dose_unit}`;
+            dosageInput.title = suggestion.basis;
+        }
+        if (data.warnings && data.warnings.length > 0) {
+            alert(data.warnings.map(w => w.message).join('\n'));
+        }
+    } catch (error) {
+        console.error('获取建议剂量失败:', error);
+    }
+}
+
 // 加载处方数据用于编辑
 async function loadPrescriptionData(prescriptionId) {
     try {
@@ -1764,6 +1928,10 @@ async function loadPrescriptionData(prescriptionId) {
             document.getElementById('prescriptionDiagnosis').value = prescription.diagnosis || '';
             document.getElementById('prescriptionDoctorAdvice').value = prescription.doctor_advice || '';
             document.getElementById('prescriptionNotes').value = prescription.notes || '';
+            document.getElementById('prescriptionPatientWeight').value = prescription.patient_weight || '';
+            document.getElementById('prescriptionType').value = prescription.prescription_type || 'western';
+            document.getElementById('prescriptionHerbalDoses').value = prescription.herbal_doses || 7;
+            document.getElementById('prescriptionDecoctionMethod').value = prescription.decoction_method || '';
             
             // 设置患者选择
             if (prescription.patient) {
@@ -1787,14 +1955,17 @@ async function loadPrescriptionData(prescriptionId) {
                     itemDiv.innerHTML = `
                         <div class="col-md-2 mb-2">
                             <div class="autocomplete-container">
-                                <input type="text" class="form-control" placeholder="药品名称" name="medicineName" value="${item.medicine_name || ''}" autocomplete="off">
+                                <input type="text" class="form-control" placeholder="药品名称" name="medicineName" value="${item.medicine_name || ''}" data-medicine-id="${item.medicine_id || ''}" autocomplete="off">
                                 <div class="autocomplete-dropdown" style="display: none;"></div>
                             </div>
                         </div>
                         <div class="col-md-2 mb-2">
                             <input type="text" class="form-control" placeholder="规格" name="specification" value="${item.specification || ''}">
                         </div>
-                        <div class="col-md-2 mb-2">
+                        <div class="col-md-1 mb-2">
+                            <input type="text" class="form-control" placeholder="单次剂量" name="dosage" value="${item.herb_grams || item.dosage || ''}">
+                        </div>
+                        <div class="col-md-1 mb-2">
                             <input type="text" class="form-control" placeholder="用法" name="usage" value="${item.usage || ''}">
                         </div>
                         <div class="col-md-1 mb-2">
@@ -1827,6 +1998,7 @@ async function loadPrescriptionData(prescriptionId) {
                 // 如果没有明细，添加一个空的明细行
                 addPrescriptionItem();
             }
+            updatePrescriptionTypeUI();
             
             // 初始化药品自动完成功能
             setTimeout(() => {
@@ -1842,6 +2014,33 @@ async function loadPrescriptionData(prescriptionId) {
     }
 }
 
+// 填写原因后查看患者完整的电话和身份证号，查看记录写入操作日志
+async function revealPatientPII() {
+    const patientId = document.getElementById('patientId').value;
+    const reason = prompt('请填写查看完整电话和身份证号的原因：');
+    if (!patientId || !reason || !reason.trim()) {
+        return;
+    }
+
+    try {
+        const response = await fetch(`${API_BASE}/patients/${patientId}/reveal`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ reason: reason.trim() })
+        });
+        const data = await response.json();
+        if (response.ok) {
+            document.getElementById('patientPhone').value = data.phone || '';
+            document.getElementById('patientIdCard').value = data.id_card || '';
+            document.getElementById('revealPatientBtn').classList.add('d-none');
+        } else {
+            alert(data.error || '查看失败');
+        }
+    } catch (error) {
+        console.error('查看患者信息失败:', error);
+    }
+}
+
 // 加载患者数据用于编辑
 async function loadPatientData(patientId) {
     try {
@@ -1854,11 +2053,15 @@ async function loadPatientData(patientId) {
             document.getElementById('patientId').value = patient.id;
             document.getElementById('patientName').value = patient.name;
             document.getElementById('patientGender').value = patient.gender;
-            document.getElementById('patientAge').value = patient.age;
+            // 按年龄推算的出生日期不回填，避免被当作准确日期保存
+            document.getElementById('patientBirthDate').value = patient.birth_date_estimated ? '' : (patient.birth_date || '');
+            document.getElementById('patientAge').value = patient.age || '';
             document.getElementById('patientPhone').value = patient.phone || '';
             document.getElementById('patientIdCard').value = patient.id_card || '';
             document.getElementById('patientAddress').value = patient.address || '';
             document.getElementById('patientMedicalHistory').value = patient.medical_history || '';
+            // 电话、身份证号已脱敏时可填写原因查看完整号码
+            document.getElementById('revealPatientBtn').classList.toggle('d-none', !patient.masked);
         } else {
             alert('加载患者数据失败');
         }
@@ -1885,7 +2088,20 @@ async function loadMedicineData(medicineId) {
             document.getElementById('medicineStock').value = medicine.stock;
             document.getElementById('medicineCategory').value = medicine.category || '';
             document.getElementById('medicineManufacturer').value = medicine.manufacturer || '';
+            document.getElementById('medicineIngredients').value = medicine.ingredients || '';
+            document.getElementById('medicineDrugClass').value = medicine.drug_class || 'rx';
+            document.getElementById('medicineAntibioticLevel').value = medicine.antibiotic_level || '';
             document.getElementById('medicineMinStock').value = medicine.min_stock || 10;
+            const rule = medicine.dosing_rule || {};
+            document.getElementById('medicineDoseUnit').value = rule.dose_unit || '';
+            document.getElementById('medicineMaxSingleDose').value = rule.max_single_dose || '';
+            document.getElementById('medicineMaxDailyDose').value = rule.max_daily_dose || '';
+            document.getElementById('medicineDosePerKg').value = rule.dose_per_kg || '';
+            // 整岁的按岁显示，其余按月显示
+            const minAgeMonths = rule.min_age_months || 0;
+            const minAgeInYears = minAgeMonths > 0 && minAgeMonths % 12 === 0;
+            document.getElementById('medicineMinAge').value = minAgeMonths ? (minAgeInYears ? minAgeMonths / 12 : minAgeMonths) : '';
+            document.getElementById('medicineMinAgeUnit').value = minAgeInYears || !minAgeMonths ? '12' : '1';
         } else {
             alert('加载药品数据失败');
         }
@@ -1960,6 +2176,120 @@ function showQuickMedicineModal() {
     modal.show();
 }
 
+// 加载药房待审核、待发药队列
+async function loadPharmacyQueues() {
+    try {
+        const [reviewResponse, dispenseResponse] = await Promise.all([
+            fetch(`${API_BASE}/pharmacy/queue?stage=review`),
+            fetch(`${API_BASE}/pharmacy/queue?stage=dispense`)
+        ]);
+        if (!reviewResponse.ok || !dispenseResponse.ok) {
+            alert('加载药房队列失败');
+            return;
+        }
+        const review = await reviewResponse.json();
+        const dispense = await dispenseResponse.json();
+
+        document.getElementById('reviewQueueTable').innerHTML = review.prescriptions.length === 0
+            ? '<tr><td colspan="7" class="text-center text-muted">暂无待审核处方</td></tr>'
+            : review.prescriptions.map(p => `
+                <tr>
+                    <td><a href="#" onclick="viewPrescription(${p.id}); return false;">${p.prescription_no || p.id}</a></td>
+                    <td>${p.patient.name}</td>
+                    <td>${p.doctor.name}</td>
+                    <td>${p.diagnosis || '-'}</td>
+                    <td>¥${p.total_amount}</td>
+                    <td>${p.completed_at ? new Date(p.completed_at).toLocaleString() : '-'}</td>
+                    <td>
+                        <button class="btn btn-sm btn-success" onclick="approvePrescription(${p.id})"><i class="bi bi-check-lg"></i> 通过</button>
+                        <button class="btn btn-sm btn-outline-danger" onclick="rejectPrescription(${p.id})"><i class="bi bi-x-lg"></i> 驳回</button>
+                    </td>
+                </tr>
+            `).join('');
+
+        document.getElementById('dispenseQueueTable').innerHTML = dispense.prescriptions.length === 0
+            ? '<tr><td colspan="6" class="text-center text-muted">暂无待发药处方</td></tr>'
+            : dispense.prescriptions.map(p => `
+                <tr>
+                    <td><a href="#" onclick="viewPrescription(${p.id}); return false;">${p.prescription_no || p.id}</a></td>
+                    <td>${p.patient.name}</td>
+                    <td>${p.doctor.name}</td>
+                    <td>${p.reviewer_name || '-'}</td>
+                    <td>${p.reviewed_at ? new Date(p.reviewed_at).toLocaleString() : '-'}</td>
+                    <td>
+                        <button class="btn btn-sm btn-primary" onclick="dispensePrescription(${p.id})"><i class="bi bi-box-seam"></i> 发药</button>
+                    </td>
+                </tr>
+            `).join('');
+
+        loadControlledDrugRegister();
+    } catch (error) {
+        console.error('加载药房队列失败:', error);
+        alert('加载药房队列失败，请检查网络连接');
+    }
+}
+
+// 加载麻醉药品、精神药品专用登记
+async function loadControlledDrugRegister() {
+    const drugClassNames = { narcotic: '麻醉药品', psychotropic_1: '第一类精神药品', psychotropic_2: '第二类精神药品' };
+    try {
+        const response = await fetch(`${API_BASE}/controlled-drug-register?limit=50`);
+        if (!response.ok) return;
+        const data = await response.json();
+        document.getElementById('controlledDrugRegisterTable').innerHTML = data.records.length === 0
+            ? '<tr><td colspan="9" class="text-center text-muted">暂无登记记录</td></tr>'
+            : data.records.map(r => `
+                <tr>
+                    <td>${new Date(r.dispensed_at).toLocaleString()}</td>
+                    <td><a href="#" onclick="viewPrescription(${r.prescription_id}); return false;">${r.prescription_no || r.prescription_id}</a></td>
+                    <td>${r.medicine_name} ${r.specification || ''}</td>
+                    <td>${drugClassNames[r.drug_class] || r.drug_class}</td>
+                    <td>${r.quantity}</td>
+                    <td>${r.patient_name}</td>
+                    <td>${r.patient_id_card || '-'}</td>
+                    <td>${r.doctor_name}</td>
+                    <td>${r.dispenser_name}</td>
+                </tr>
+            `).join('');
+    } catch (error) {
+        console.error('加载麻精药品登记失败:', error);
+    }
+}
+
+// 药房操作：审核通过、驳回、发药
+async function pharmacyAction(id, action, body = {}) {
+    try {
+        const response = await fetch(`${API_BASE}/pharmacy/prescriptions/${id}/${action}`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        const result = await response.json();
+        alert(response.ok ? result.message : (result.error || '操作失败'));
+        loadPharmacyQueues();
+    } catch (error) {
+        console.error('药房操作失败:', error);
+        alert('操作失败，请检查网络连接');
+    }
+}
+
+function approvePrescription(id) {
+    const note = prompt('审核意见（可不填）：', '');
+    if (note === null) return;
+    pharmacyAction(id, 'approve', { note });
+}
+
+function rejectPrescription(id) {
+    const reason = prompt('请输入驳回原因，将退回开方医生：');
+    if (!reason || !reason.trim()) return;
+    pharmacyAction(id, 'reject', { reason: reason.trim() });
+}
+
+function dispensePrescription(id) {
+    if (!confirm('确认已按处方发药？')) return;
+    pharmacyAction(id, 'dispense');
+}
+
 // 显示医生管理菜单（仅管理员）
 function showDoctorMenuIfAdmin() {
     if (currentUser && currentUser.role === 'admin') {
@@ -1987,6 +2317,7 @@ function displayDoctors(doctors) {
             <td>${doctor.id}</td>
             <td>${doctor.username}</td>
             <td>${doctor.name}</td>
+            <td>${{ pharmacist: '药师', nurse: '护士', receptionist: '前台' }[doctor.role] || '医生'}</td>
             <td>
                 <button class="btn btn-sm btn-outline-primary" onclick="editDoctor(${doctor.id})"><i class="bi bi-pencil"></i></button>
                 <button class="btn btn-sm btn-outline-danger" onclick="deleteDoctor(${doctor.id})"><i class="bi bi-trash"></i></button>
@@ -2034,6 +2365,9 @@ async function loadDoctorData(id) {
             document.getElementById('doctorId').value = data.doctor.id;
             document.getElementById('doctorUsername').value = data.doctor.username;
             document.getElementById('doctorName').value = data.doctor.name;
+            document.getElementById('doctorRole').value = data.doctor.role || 'doctor';
+            document.getElementById('doctorAntibioticLevel').value = data.doctor.antibiotic_level || 'non_restricted';
+            document.getElementById('doctorControlledDrugQualified').checked = !!data.doctor.controlled_drug_qualified;
         }
     } catch (error) {
         alert('加载医生信息失败');
@@ -2045,9 +2379,12 @@ async function saveDoctor() {
     const username = document.getElementById('doctorUsername').value;
     const name = document.getElementById('doctorName').value;
     const password = document.getElementById('doctorPassword').value;
+    const role = document.getElementById('doctorRole').value;
+    const antibiotic_level = document.getElementById('doctorAntibioticLevel').value;
+    const controlled_drug_qualified = document.getElementById('doctorControlledDrugQualified').checked;
     const method = id ? 'PUT' : 'POST';
     const url = id ? `/api/doctors/${id}` : '/api/doctors';
-    let body = { username, name };
+    let body = { username, name, role, antibiotic_level, controlled_drug_qualified };
     if (!id || password) body.password = password;
     if (!username || !name || (!id && !password)) {
         alert('请填写完整信息');
@@ -2125,6 +2462,8 @@ function setupMedicineAutocomplete(input) {
     // 输入事件
     input.addEventListener('input', function() {
         clearTimeout(timeoutId);
+        // 手动修改药品名称后不再关联药品库，避免错误扣减库存
+        delete this.dataset.medicineId;
         const query = this.value.trim();
         
         if (query.length < 1) {
@@ -2186,6 +2525,7 @@ function setupMedicineAutocomplete(input) {
     
     function selectMedicine(medicine, input) {
         input.value = medicine.name;
+        input.dataset.medicineId = medicine.id;
         
         // 填充其他字段
         const row = input.closest('.prescription-item');
@@ -2209,6 +2549,9 @@ function setupMedicineAutocomplete(input) {
             totalPriceInput.value = (quantity * unitPrice).toFixed(2);
         }
         
+        // 按剂量规则提示建议剂量
+        loadSuggestedDose(medicine.id, row);
+        
         // 关闭下拉框
         dropdown.style.display = 'none';
         selectedIndex = -1;
@@ -2375,7 +2718,7 @@ function setupPatientAutocomplete() {
         
         const option = document.createElement('option');
         option.value = patient.id;
-        option.textContent = `${patient.name} (${patient.gender || '未知'}, ${patient.age || '未知'}岁)`;
+        option.textContent = `${patient.name} (${patient.gender || '未知'}, ${patient.age_text || '年龄未知'})`;
         select.appendChild(option);
         select.value = patient.id;
         
@@ -2415,7 +2758,7 @@ function setupPatientAutocomplete() {
                 dropdown.innerHTML = patients.map((patient, index) => `
                     <div class="autocomplete-item" data-index="${index}">
                         <div class="patient-name">${patient.name}</div>
-                        <div class="patient-info">${patient.gender || '未知'} | ${patient.age || '未知'}岁 | ${patient.phone || '无电话'}</div>
+                        <div class="patient-info">${patient.gender || '未知'} | ${patient.age_text || '年龄未知'} | ${patient.phone || '无电话'}</div>
                         ${patient.pinyin ? `<div class="patient-pinyin">拼音: ${patient.pinyin}</div>` : ''}
                     </div>
                 `).join('');